@@ -0,0 +1,96 @@
+package api
+
+import (
+    "encoding/json"
+    "errors"
+    "io"
+    "mime"
+    "net/http"
+    "strings"
+)
+
+const (
+    // Request bodies are small, anything bigger than this is a mistake
+    DefaultMaxBodyBytes = 64 << 10
+)
+
+// Request types can implement this to have the body checked as soon as it is
+// parsed. Errors that aren't already an api or model error are reported as a
+// bad request.
+type Validator interface {
+    Validate() error
+}
+
+func isJsonContentType(contentType string) bool {
+    mediaType, params, err := mime.ParseMediaType(contentType)
+
+    if err != nil {
+        return false
+    }
+
+    if charset, found := params["charset"]; found &&
+       !strings.EqualFold(charset, "utf-8") {
+        return false
+    }
+
+    return mediaType == "application/json" ||
+           (strings.HasPrefix(mediaType, "application/") &&
+            strings.HasSuffix(mediaType, "+json"))
+}
+
+func ParseJsonRequest(request *http.Request, v interface{}) error {
+    return ParseJsonRequestLimit(request, v, DefaultMaxBodyBytes)
+}
+
+// Decode a JSON body of at most maxBytes into v. Unknown fields and anything
+// after the JSON value are rejected.
+func ParseJsonRequestLimit(request *http.Request,
+                           v interface{},
+                           maxBytes int64) error {
+    if !isJsonContentType(request.Header.Get("Content-Type")) {
+        return UnsupportedMediaType("Content-Type must be application/json, got %q",
+                                    request.Header.Get("Content-Type"))
+    }
+
+    if request.ContentLength > maxBytes {
+        return TooLarge("Request body must be at most %d bytes", maxBytes)
+    }
+
+    return decodeJson(http.MaxBytesReader(nil, request.Body, maxBytes), v)
+}
+
+func decodeJson(body io.Reader, v interface{}) error {
+    decoder := json.NewDecoder(body)
+    decoder.DisallowUnknownFields()
+
+    err := decoder.Decode(v)
+
+    if err == nil {
+        // There should only be one value in the body
+        var extra json.RawMessage
+        if decoder.Decode(&extra) != io.EOF {
+            return BadRequest("Request body must be a single JSON value")
+        }
+    }
+
+    var tooLarge *http.MaxBytesError
+    switch {
+    case err == io.EOF:
+        return BadRequest("Missing request body")
+    case errors.As(err, &tooLarge):
+        return TooLarge("Request body must be at most %d bytes", tooLarge.Limit)
+    case err != nil:
+        return BadRequest("Invalid JSON body: %s", err)
+    }
+
+    if validator, canValidate := v.(Validator); canValidate {
+        err = validator.Validate()
+
+        if err != nil && ErrorOf(err).Code == CodeInternal {
+            return BadRequest("%s", err)
+        }
+        return err
+    }
+
+    return nil
+}
@@ -0,0 +1,146 @@
+package api_test
+
+import (
+    "bytes"
+    "fmt"
+    "io/ioutil"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+
+    "github.com/dox5/dnd_royal_server/api"
+)
+
+type exampleRequest struct {
+    Name string
+    Count int
+}
+
+func (r *exampleRequest) Validate() error {
+    if r.Count < 0 {
+        return fmt.Errorf("Count must not be negative")
+    }
+    return nil
+}
+
+func jsonRequest(body string) *http.Request {
+    request := httptest.NewRequest(http.MethodPost,
+                                   "/",
+                                   bytes.NewBufferString(body))
+    request.Header.Set("Content-Type", "application/json")
+    return request
+}
+
+func expectStatus(t *testing.T, err error, status int) {
+    t.Helper()
+
+    if err == nil {
+        t.Errorf("Expected status %d but parsing succeeded", status)
+    } else if api.ErrorOf(err).Status != status {
+        t.Errorf("Expected status %d but got %d: %s",
+                 status,
+                 api.ErrorOf(err).Status,
+                 err)
+    }
+}
+
+func TestParseJsonRequestShouldDecodeBody(t *testing.T) {
+    var parsed exampleRequest
+    err := api.ParseJsonRequest(jsonRequest(`{"Name": "fog", "Count": 3}`),
+                                &parsed)
+
+    if err != nil || parsed.Name != "fog" || parsed.Count != 3 {
+        t.Errorf("Expected fog and 3 but got %+v (%v)", parsed, err)
+    }
+}
+
+func TestParseJsonRequestShouldHandleUnknownLength(t *testing.T) {
+    request := jsonRequest("")
+    request.Body = ioutil.NopCloser(strings.NewReader(`{"Count": 2}`))
+    request.ContentLength = -1
+
+    var parsed exampleRequest
+    err := api.ParseJsonRequest(request, &parsed)
+
+    if err != nil || parsed.Count != 2 {
+        t.Errorf("Expected chunked body to parse but got %+v (%v)", parsed, err)
+    }
+}
+
+func TestParseJsonRequestShouldRejectBadBodies(t *testing.T) {
+    var parsed exampleRequest
+
+    expectStatus(t,
+                 api.ParseJsonRequest(jsonRequest(`{"Colour": "red"}`), &parsed),
+                 http.StatusBadRequest)
+    expectStatus(t,
+                 api.ParseJsonRequest(jsonRequest(`{} {}`), &parsed),
+                 http.StatusBadRequest)
+    expectStatus(t,
+                 api.ParseJsonRequest(jsonRequest(``), &parsed),
+                 http.StatusBadRequest)
+    expectStatus(t,
+                 api.ParseJsonRequest(jsonRequest(`{"Count": -1}`), &parsed),
+                 http.StatusBadRequest)
+
+    large := jsonRequest(`{"Name": "` + strings.Repeat("a", 100) + `"}`)
+    expectStatus(t,
+                 api.ParseJsonRequestLimit(large, &parsed, 50),
+                 http.StatusRequestEntityTooLarge)
+
+    // Lying about the length shouldn't get round the limit
+    large = jsonRequest(`{"Name": "` + strings.Repeat("a", 100) + `"}`)
+    large.ContentLength = -1
+    expectStatus(t,
+                 api.ParseJsonRequestLimit(large, &parsed, 50),
+                 http.StatusRequestEntityTooLarge)
+
+    plain := jsonRequest(`{}`)
+    plain.Header.Set("Content-Type", "text/plain")
+    expectStatus(t,
+                 api.ParseJsonRequest(plain, &parsed),
+                 http.StatusUnsupportedMediaType)
+}
+
+func FuzzParseJsonRequest(f *testing.F) {
+    f.Add(`{"Name": "fog", "Count": 3}`, int64(64))
+    f.Add(`{"Count": -1}`, int64(64))
+    f.Add(`{"Name": "\u0000"}`, int64(8))
+    f.Add(`[1, 2, 3]`, int64(64))
+    f.Add(`{"Count": 1e400}`, int64(64))
+    f.Add(``, int64(0))
+
+    f.Fuzz(func(t *testing.T, body string, limit int64) {
+        if limit < 0 {
+            limit = -limit
+        }
+
+        request := jsonRequest(body)
+        request.ContentLength = -1
+
+        var parsed exampleRequest
+        err := api.ParseJsonRequestLimit(request, &parsed, limit)
+
+        if err != nil {
+            // Whatever is sent, it's the client's fault not ours
+            if status := api.ErrorOf(err).Status; status >= 500 {
+                t.Errorf("Expected a client error for %q but got %d: %s",
+                         body,
+                         status,
+                         err)
+            }
+            return
+        }
+
+        if int64(len(body)) > limit {
+            t.Errorf("Expected %d byte body to be over the %d byte limit",
+                     len(body),
+                     limit)
+        }
+
+        if parsed.Count < 0 {
+            t.Errorf("Expected validation to reject %+v", parsed)
+        }
+    })
+}
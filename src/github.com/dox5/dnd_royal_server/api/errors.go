@@ -0,0 +1,149 @@
+package api
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net/http"
+
+    "github.com/dox5/dnd_royal_server/model"
+)
+
+// Machine readable error codes, these are part of the API so don't change them
+const (
+    CodeNotFound = "not_found"
+    CodeUnauthorised = "unauthorised"
+    CodeForbidden = "forbidden"
+    CodeBadRequest = "bad_request"
+    CodeMethodNotAllowed = "method_not_allowed"
+    CodeConflict = "conflict"
+    CodeTooLarge = "too_large"
+    CodeUnsupportedMediaType = "unsupported_media_type"
+    CodeInternal = "internal"
+)
+
+// An error that knows which HTTP status it should be reported with
+type Error struct {
+    Status int
+    Code string
+    Message string
+}
+
+func (e *Error) Error() string {
+    return e.Message
+}
+
+type ErrorBody struct {
+    Code string
+    Message string
+}
+
+type ErrorResponse struct {
+    Error ErrorBody
+}
+
+func newError(status int, code string, format string, args ...interface{}) error {
+    return &Error{Status: status,
+                  Code: code,
+                  Message: fmt.Sprintf(format, args...)}
+}
+
+func NotFound(format string, args ...interface{}) error {
+    return newError(http.StatusNotFound, CodeNotFound, format, args...)
+}
+
+// The caller didn't prove who they are, e.g. the wrong GameMasterId
+func Unauthorised(format string, args ...interface{}) error {
+    return newError(http.StatusUnauthorized, CodeUnauthorised, format, args...)
+}
+
+// The caller is known but isn't allowed to do this
+func Forbidden(format string, args ...interface{}) error {
+    return newError(http.StatusForbidden, CodeForbidden, format, args...)
+}
+
+func BadRequest(format string, args ...interface{}) error {
+    return newError(http.StatusBadRequest, CodeBadRequest, format, args...)
+}
+
+func MethodNotAllowed(format string, args ...interface{}) error {
+    return newError(http.StatusMethodNotAllowed,
+                    CodeMethodNotAllowed,
+                    format,
+                    args...)
+}
+
+func Conflict(format string, args ...interface{}) error {
+    return newError(http.StatusConflict, CodeConflict, format, args...)
+}
+
+func TooLarge(format string, args ...interface{}) error {
+    return newError(http.StatusRequestEntityTooLarge,
+                    CodeTooLarge,
+                    format,
+                    args...)
+}
+
+func UnsupportedMediaType(format string, args ...interface{}) error {
+    return newError(http.StatusUnsupportedMediaType,
+                    CodeUnsupportedMediaType,
+                    format,
+                    args...)
+}
+
+// Something went wrong on our side
+func Internal(format string, args ...interface{}) error {
+    return newError(http.StatusInternalServerError,
+                    CodeInternal,
+                    format,
+                    args...)
+}
+
+// Work out how an error should be reported. Anything that isn't an api.Error
+// or a model.Error is a bug on our side.
+func ErrorOf(err error) *Error {
+    var apiErr *Error
+    if errors.As(err, &apiErr) {
+        return apiErr
+    }
+
+    var modelErr *model.Error
+    if errors.As(err, &modelErr) {
+        switch modelErr.Kind {
+        case model.NotFound:
+            return &Error{Status: http.StatusNotFound,
+                          Code: CodeNotFound,
+                          Message: modelErr.Message}
+        case model.Conflict:
+            return &Error{Status: http.StatusConflict,
+                          Code: CodeConflict,
+                          Message: modelErr.Message}
+        default:
+            return &Error{Status: http.StatusBadRequest,
+                          Code: CodeBadRequest,
+                          Message: modelErr.Message}
+        }
+    }
+
+    return &Error{Status: http.StatusInternalServerError,
+                  Code: CodeInternal,
+                  Message: fmt.Sprintf("Request Handling failed: %s", err)}
+}
+
+func WriteError(writer http.ResponseWriter, err error) {
+    apiErr := ErrorOf(err)
+
+    body, marshalErr := json.Marshal(ErrorResponse{
+        Error: ErrorBody{Code: apiErr.Code, Message: apiErr.Message}})
+
+    if marshalErr != nil {
+        http.Error(writer, apiErr.Message, apiErr.Status)
+        return
+    }
+
+    header := writer.Header()
+    header.Set("Content-Type", "application/json")
+    header.Set("X-Content-Type-Options", "nosniff")
+    writer.WriteHeader(apiErr.Status)
+    writer.Write(body)
+}
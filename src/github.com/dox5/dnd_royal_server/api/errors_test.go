@@ -0,0 +1,116 @@
+package api_test
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+
+    "github.com/dox5/dnd_royal_server/api"
+    "github.com/dox5/dnd_royal_server/model"
+)
+
+func TestErrorsShouldMapToStatusCodes(t *testing.T) {
+    cases := []struct {
+        err error
+        status int
+        code string
+    }{
+        {api.NotFound("missing"), http.StatusNotFound, api.CodeNotFound},
+        {api.Unauthorised("who?"), http.StatusUnauthorized, api.CodeUnauthorised},
+        {api.Forbidden("no"), http.StatusForbidden, api.CodeForbidden},
+        {api.BadRequest("what?"), http.StatusBadRequest, api.CodeBadRequest},
+        {api.MethodNotAllowed("GET"),
+         http.StatusMethodNotAllowed,
+         api.CodeMethodNotAllowed},
+        {api.Conflict("busy"), http.StatusConflict, api.CodeConflict},
+        {api.Internal("oops"),
+         http.StatusInternalServerError,
+         api.CodeInternal},
+        {fmt.Errorf("Wrapped: %w", api.NotFound("missing")),
+         http.StatusNotFound,
+         api.CodeNotFound},
+        {fmt.Errorf("Something broke"),
+         http.StatusInternalServerError,
+         api.CodeInternal},
+    }
+
+    for _, c := range cases {
+        apiErr := api.ErrorOf(c.err)
+
+        if apiErr.Status != c.status || apiErr.Code != c.code {
+            t.Errorf("Expected %v to give %d %s but got %d %s",
+                     c.err,
+                     c.status,
+                     c.code,
+                     apiErr.Status,
+                     apiErr.Code)
+        }
+    }
+}
+
+func TestModelErrorsShouldMapToStatusCodes(t *testing.T) {
+    room := model.NewRoom(model.NewPlayer())
+
+    err := room.RemovePlayerToken(42)
+
+    if api.ErrorOf(err).Status != http.StatusNotFound {
+        t.Errorf("Expected missing token to be a 404 but got %d",
+                 api.ErrorOf(err).Status)
+    }
+
+    err = room.SetDamagePerSecond(-1)
+
+    if api.ErrorOf(err).Status != http.StatusBadRequest {
+        t.Errorf("Expected negative damage to be a 400 but got %d",
+                 api.ErrorOf(err).Status)
+    }
+}
+
+func TestFormatResponseShouldWriteJsonError(t *testing.T) {
+    recorder := httptest.NewRecorder()
+
+    api.FormatResponse(recorder, nil, api.Unauthorised("Bad GameMasterId"))
+
+    if recorder.Code != http.StatusUnauthorized {
+        t.Errorf("Expected status %d but got %d",
+                 http.StatusUnauthorized,
+                 recorder.Code)
+    }
+
+    var response api.ErrorResponse
+    err := json.Unmarshal(recorder.Body.Bytes(), &response)
+
+    if err != nil {
+        t.Fatalf("Expected a JSON body but got %q: %s", recorder.Body, err)
+    }
+
+    if response.Error.Code != api.CodeUnauthorised ||
+       response.Error.Message != "Bad GameMasterId" {
+        t.Errorf("Expected unauthorised error but got %+v", response.Error)
+    }
+}
+
+func TestFormatResponseShouldWriteJsonErrorWhenResponseWontEncode(t *testing.T) {
+    recorder := httptest.NewRecorder()
+
+    api.FormatResponse(recorder, make(chan int), nil)
+
+    if recorder.Code != http.StatusInternalServerError {
+        t.Errorf("Expected status %d but got %d",
+                 http.StatusInternalServerError,
+                 recorder.Code)
+    }
+
+    var response api.ErrorResponse
+    err := json.Unmarshal(recorder.Body.Bytes(), &response)
+
+    if err != nil {
+        t.Fatalf("Expected a JSON body but got %q: %s", recorder.Body, err)
+    }
+
+    if response.Error.Code != api.CodeInternal {
+        t.Errorf("Expected internal error but got %+v", response.Error)
+    }
+}
@@ -0,0 +1,92 @@
+package api
+
+import (
+    "time"
+
+    "github.com/dox5/dnd_royal_server/model"
+)
+
+type FogState struct {
+    Current model.AnyShape
+    Target  model.AnyShape
+    Rate    model.Rate
+    Paused  bool
+    // The curve the fog follows from MoveFrom to Target, see model.Easing*
+    Easing  string
+
+    // -1 when the fog isn't following a schedule
+    StageIndex    int
+    StageCount    int
+    Holding       bool
+    TimeRemaining float32
+
+    // Picked the last random target, so it can be picked again. 0 if there
+    // hasn't been one.
+    ZoneSeed int64 `json:",string"`
+}
+
+// Rate is only reported while the fog is actually moving
+func FogStateOf(room *model.Room) FogState {
+    fog := room.Fog()
+    state := FogState{Current: model.AnyShape{Shape: fog.Current()},
+                      Target: model.AnyShape{Shape: fog.Target()},
+                      Paused: fog.Paused(),
+                      Easing: fog.Easing(),
+                      ZoneSeed: room.LastZoneSeed(),
+                      StageIndex: fog.StageIndex(),
+                      StageCount: len(fog.Schedule()),
+                      Holding: fog.Holding(),
+                      TimeRemaining: fog.StageTimeRemaining()}
+
+    if !fog.Paused() {
+        state.Rate = fog.Rate()
+    }
+
+    return state
+}
+
+// When the fog moves in server time, so clients can draw it between updates
+type FogTimeline struct {
+    ServerTime time.Time
+    // Only set while the fog is resumed. It holds at MoveFrom until
+    // MoveStart then moves along the Easing curve to reach Target at MoveEnd.
+    MoveFrom  *model.AnyShape `json:",omitempty"`
+    MoveStart *time.Time      `json:",omitempty"`
+    MoveEnd   *time.Time      `json:",omitempty"`
+}
+
+type FogLocation struct {
+    FogState
+    FogTimeline
+}
+
+// fogEpoch is the server time the fog's own time started from
+func FogLocationOf(room *model.Room,
+                   serverTime time.Time,
+                   fogEpoch time.Time) FogLocation {
+    fog := room.Fog()
+    location := FogLocation{FogState: FogStateOf(room)}
+    location.ServerTime = serverTime.UTC()
+
+    if move, moving := fog.CurrentMove(); moving && !fog.Paused() {
+        start := fogEpoch.Add(fogSeconds(move.Start)).UTC()
+        end := fogEpoch.Add(fogSeconds(move.End)).UTC()
+
+        location.MoveFrom = &model.AnyShape{Shape: move.From}
+        location.MoveStart = &start
+        location.MoveEnd = &end
+    }
+
+    return location
+}
+
+func fogSeconds(seconds float64) time.Duration {
+    return time.Duration(seconds * float64(time.Second))
+}
+
+type FogScheduleResponse struct {
+    Stages        []model.FogStage
+    StageIndex    int
+    Holding       bool
+    TimeRemaining float32
+}
@@ -1,26 +1,48 @@
 package api
 
 import (
+    "context"
     "encoding/json"
-    "fmt"
-    "io"
     "net/http"
     "strconv"
 
     "github.com/dox5/dnd_royal_server/model"
 )
 
+type pathParamsKey struct{}
+
+// Attach the parameters matched from the path, e.g. {RoomId}
+func WithPathParams(request *http.Request,
+                    params map[string]string) *http.Request {
+    if len(params) == 0 {
+        return request
+    }
+
+    ctx := context.WithValue(request.Context(), pathParamsKey{}, params)
+    return request.WithContext(ctx)
+}
+
+func PathParam(request *http.Request, name string) string {
+    params, _ := request.Context().Value(pathParamsKey{}).(map[string]string)
+    return params[name]
+}
+
+// From the path if the route has a {RoomId}, otherwise the form value
 func RoomIdFromRequest(request *http.Request) (model.Identifier, error) {
-    roomIdString := request.FormValue("RoomId")
+    roomIdString := PathParam(request, "RoomId")
 
     if len(roomIdString) == 0 {
-      return 0, fmt.Errorf("Missing RoomId parameter")
+        roomIdString = request.FormValue("RoomId")
+    }
+
+    if len(roomIdString) == 0 {
+      return 0, BadRequest("Missing RoomId parameter")
     }
 
     roomId, err := strconv.ParseUint(roomIdString, 10, 64)
 
     if err != nil {
-      return 0, fmt.Errorf("RoomId must be uint64: %s", err)
+      return 0, BadRequest("RoomId must be uint64: %s", err)
     }
 
     return model.Identifier(roomId), nil
@@ -30,8 +52,7 @@ func FormatResponse(writer http.ResponseWriter,
                     response interface{},
                     err error) {
     if err != nil {
-        msg := fmt.Sprintf("Request Handling failed: %s", err)
-        http.Error(writer, msg, http.StatusInternalServerError)
+        WriteError(writer, err)
         return
     }
 
@@ -39,8 +60,8 @@ func FormatResponse(writer http.ResponseWriter,
         formattedResponse, err := json.Marshal(response)
 
         if err != nil {
-            msg := fmt.Sprintf("Failed to format JSON response: %s", err)
-            http.Error(writer, msg, http.StatusInternalServerError)
+            WriteError(writer,
+                       Internal("Failed to format JSON response: %s", err))
             return
         }
 
@@ -48,17 +69,3 @@ func FormatResponse(writer http.ResponseWriter,
         writer.Write(formattedResponse)
     }
 }
-
-func ParseJsonRequest(request *http.Request, v interface{}) error {
-    buf := make([]byte, request.ContentLength, request.ContentLength)
-    n, err := io.ReadFull(request.Body, buf)
-
-    if int64(n) != request.ContentLength {
-        return fmt.Errorf("Expcted to read %d bytes but actually read %d bytes",
-                          request.ContentLength,
-                          n)
-    }
-
-    err = json.Unmarshal(buf, v)
-    return err
-}
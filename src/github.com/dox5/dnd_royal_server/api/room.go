@@ -1,14 +1,48 @@
 package api
 
 import (
+  "time"
+
   "github.com/dox5/dnd_royal_server/model"
 )
 
 type RoomCreateResponse struct {
     RoomId model.Identifier `json:",string"`
     GameMasterId model.Identifier `json:",string"`
+    // Send as "Authorization: Bearer <Token>" to act as the game master
+    Token string
 }
 
+// Everything needed to draw a room, as of Fog.ServerTime
 type RoomStateResponse struct {
-    Fog model.Circle
+    Fog FogLocation
+    Tokens []model.Token
+}
+
+type TimeResponse struct {
+    ServerTime time.Time
+}
+
+type RoomJoinResponse struct {
+    PlayerId model.Identifier `json:",string"`
+    TokenId model.Identifier `json:",string"`
+    // Send as "Authorization: Bearer <Token>" to act as the player
+    Token string
+}
+
+type SessionResponse struct {
+    // Replaces the token the request was made with
+    Token string
+}
+
+type PlayerResponse struct {
+    PlayerId model.Identifier `json:",string"`
+    Name string
+    Colour string
+}
+
+type MapResponse struct {
+    Asset string
+    Width int
+    Height int
 }
@@ -0,0 +1,36 @@
+package main
+
+import (
+    "io/ioutil"
+    "os"
+    "path/filepath"
+)
+
+// Write to a temporary file then move it into place so a crash part way
+// through never leaves a half written file behind. The temporary file is
+// hidden so nothing listing the directory picks it up.
+func writeFileAtomic(path string, data []byte) error {
+    tmp, err := ioutil.TempFile(filepath.Dir(path),
+                                "." + filepath.Base(path) + "-")
+
+    if err != nil {
+        return err
+    }
+
+    _, err = tmp.Write(data)
+    closeErr := tmp.Close()
+
+    if err == nil {
+        err = closeErr
+    }
+
+    if err == nil {
+        err = os.Rename(tmp.Name(), path)
+    }
+
+    if err != nil {
+        os.Remove(tmp.Name())
+    }
+
+    return err
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+    "context"
+    "crypto/hmac"
+    "crypto/rand"
+    "crypto/sha256"
+    "encoding/base64"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "io/ioutil"
+    "net/http"
+    "os"
+    "strings"
+    "time"
+
+    "github.com/dox5/dnd_royal_server/api"
+    "github.com/dox5/dnd_royal_server/model"
+)
+
+const (
+    // Signing keys shorter than this are too easy to guess
+    MinAuthKeyBytes = 32
+    DefaultSessionLifetime = 24 * time.Hour
+    // Expired sessions can still be swapped for a new one for this long, so
+    // nobody loses their room by being away for a day
+    SessionRefreshWindow = 30 * 24 * time.Hour
+    // However often it is refreshed, a session ends this long after the
+    // create or join that started it, so a leaked token doesn't last forever
+    MaxSessionAge = 90 * 24 * time.Hour
+    // Made in the room store when no key file is given
+    StoredAuthKeyFile = "auth.key"
+)
+
+type Role string
+
+const (
+    RolePlayer Role = "player"
+    RoleGameMaster Role = "gameMaster"
+)
+
+// The game master can do anything a player can
+func (r Role) Allows(required Role) bool {
+    return r == required || r == RoleGameMaster
+}
+
+// Who the caller is, as vouched for by a signed token
+type Session struct {
+    RoomId model.Identifier `json:",string"`
+    UserId model.Identifier `json:",string"`
+    Role Role
+    // Unix time in seconds
+    Expires int64
+    // When the session was first issued, kept across refreshes, Unix seconds
+    Issued int64
+}
+
+// Issues and checks session tokens. A token is the base64 encoded session
+// followed by an HMAC-SHA256 of it, so anyone can read it but only the server
+// can make one.
+type Authenticator struct {
+    key []byte
+    lifetime time.Duration
+    now func() time.Time
+}
+
+func NewAuthenticator(key []byte, lifetime time.Duration) (*Authenticator, error) {
+    if len(key) < MinAuthKeyBytes {
+        return nil, fmt.Errorf("Auth key must be at least %d bytes, got %d",
+                               MinAuthKeyBytes,
+                               len(key))
+    }
+
+    if lifetime <= 0 {
+        return nil, fmt.Errorf("Session lifetime must be positive, got %v",
+                               lifetime)
+    }
+
+    return &Authenticator{key: append([]byte{}, key...),
+                          lifetime: lifetime,
+                          now: time.Now}, nil
+}
+
+// Sessions signed with a random key don't survive a restart
+func NewRandomAuthenticator(lifetime time.Duration) (*Authenticator, error) {
+    key := make([]byte, MinAuthKeyBytes)
+    _, err := rand.Read(key)
+
+    if err != nil {
+        return nil, fmt.Errorf("Failed to generate auth key: %s", err)
+    }
+
+    return NewAuthenticator(key, lifetime)
+}
+
+func NewAuthenticatorFromFile(path string,
+                              lifetime time.Duration) (*Authenticator, error) {
+    key, err := ioutil.ReadFile(path)
+
+    if err != nil {
+        return nil, fmt.Errorf("Failed to read auth key: %s", err)
+    }
+
+    return NewAuthenticator([]byte(strings.TrimSpace(string(key))), lifetime)
+}
+
+// Reads the key from path, first saving a random one there if there isn't a
+// key yet, so sessions survive a restart without anyone having to make a key
+func NewPersistentAuthenticator(path string,
+                                lifetime time.Duration) (*Authenticator, error) {
+    key := make([]byte, MinAuthKeyBytes)
+    _, err := rand.Read(key)
+
+    if err != nil {
+        return nil, fmt.Errorf("Failed to generate auth key: %s", err)
+    }
+
+    // Never replace a key that is already there
+    file, err := os.OpenFile(path, os.O_WRONLY | os.O_CREATE | os.O_EXCL, 0600)
+
+    if err == nil {
+        _, err = file.WriteString(hex.EncodeToString(key) + "\n")
+        closeErr := file.Close()
+
+        if err == nil {
+            err = closeErr
+        }
+
+        if err != nil {
+            os.Remove(path)
+            return nil, fmt.Errorf("Failed to save auth key: %s", err)
+        }
+    } else if !os.IsExist(err) {
+        return nil, fmt.Errorf("Failed to create auth key: %s", err)
+    }
+
+    return NewAuthenticatorFromFile(path, lifetime)
+}
+
+func (a *Authenticator) sign(payload string) string {
+    mac := hmac.New(sha256.New, a.key)
+    mac.Write([]byte(payload))
+    return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (a *Authenticator) Issue(roomId model.Identifier,
+                              userId model.Identifier,
+                              role Role) (string, error) {
+    return a.signSession(Session{RoomId: roomId,
+                                  UserId: userId,
+                                  Role: role,
+                                  Issued: a.now().Unix()})
+}
+
+// A new token for the same session, which still ends MaxSessionAge after it
+// was first issued
+func (a *Authenticator) Refresh(session Session) (string, error) {
+    return a.signSession(session)
+}
+
+func (a *Authenticator) signSession(session Session) (string, error) {
+    session.Expires = a.now().Add(a.lifetime).Unix()
+
+    encoded, err := json.Marshal(session)
+
+    if err != nil {
+        return "", err
+    }
+
+    payload := base64.RawURLEncoding.EncodeToString(encoded)
+    return payload + "." + a.sign(payload), nil
+}
+
+func (a *Authenticator) Verify(token string) (Session, error) {
+    session, err := a.decode(token)
+
+    if err == nil && a.now().Unix() >= session.Expires {
+        return Session{}, api.Unauthorised("Session has expired")
+    }
+
+    return session, err
+}
+
+// Like Verify but also accepts tokens that expired less than
+// SessionRefreshWindow ago, for swapping them for a new token
+func (a *Authenticator) VerifyRefreshable(token string) (Session, error) {
+    session, err := a.decode(token)
+
+    if err != nil {
+        return Session{}, err
+    }
+
+    now := a.now()
+    refreshBy := time.Unix(session.Expires, 0).Add(SessionRefreshWindow)
+    endsAt := time.Unix(session.Issued, 0).Add(MaxSessionAge)
+
+    if !now.Before(refreshBy) || !now.Before(endsAt) {
+        return Session{}, api.Unauthorised("Session is too old to refresh")
+    }
+
+    return session, nil
+}
+
+// The session in a token signed by this server, whether or not it has expired
+func (a *Authenticator) decode(token string) (Session, error) {
+    parts := strings.Split(token, ".")
+
+    if len(parts) != 2 {
+        return Session{}, api.Unauthorised("Malformed session token")
+    }
+
+    // hmac.Equal doesn't give away how much of the signature was right
+    if !hmac.Equal([]byte(a.sign(parts[0])), []byte(parts[1])) {
+        return Session{}, api.Unauthorised("Invalid session token")
+    }
+
+    encoded, err := base64.RawURLEncoding.DecodeString(parts[0])
+
+    if err != nil {
+        return Session{}, api.Unauthorised("Malformed session token")
+    }
+
+    var session Session
+    err = json.Unmarshal(encoded, &session)
+
+    if err != nil {
+        return Session{}, api.Unauthorised("Malformed session token")
+    }
+
+    return session, nil
+}
+
+// Token from "Authorization: Bearer <token>", empty if there isn't one
+func tokenFromRequest(request *http.Request) string {
+    header := request.Header.Get("Authorization")
+
+    if strings.HasPrefix(header, "Bearer ") {
+        return strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))
+    }
+
+    return ""
+}
+
+type sessionKey struct{}
+
+type authResult struct {
+    session Session
+    err error
+}
+
+// Works out who is calling so handlers can check with authorise. Requests
+// without a token are let through, it's up to the handler whether that's ok.
+func (a *Authenticator) Middleware(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(writer http.ResponseWriter,
+                                 request *http.Request) {
+        result := authResult{err: api.Unauthorised("Missing session token")}
+
+        if token := tokenFromRequest(request); token != "" {
+            result.session, result.err = a.Verify(token)
+        }
+
+        ctx := context.WithValue(request.Context(), sessionKey{}, result)
+        next.ServeHTTP(writer, request.WithContext(ctx))
+    })
+}
+
+// Check the caller has at least the given role in the room
+func authorise(request *http.Request,
+               roomId model.Identifier,
+               role Role) (Session, error) {
+    logRoom(request, roomId)
+    result, found := request.Context().Value(sessionKey{}).(authResult)
+
+    if !found {
+        return Session{}, api.Unauthorised("Missing session token")
+    }
+
+    if result.err != nil {
+        return Session{}, result.err
+    }
+
+    if result.session.RoomId != roomId {
+        return Session{}, api.Forbidden("Session is for a different room")
+    }
+
+    if !result.session.Role.Allows(role) {
+        return Session{}, api.Forbidden("Only the game master can do that")
+    }
+
+    return result.session, nil
+}
@@ -0,0 +1,151 @@
+package main_test
+
+import (
+    "bytes"
+    "path/filepath"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/dox5/dnd_royal_server/dndbrserver"
+    "github.com/dox5/dnd_royal_server/model"
+)
+
+func testAuthKey() []byte {
+    return bytes.Repeat([]byte("k"), main.MinAuthKeyBytes)
+}
+
+func TestIssuedTokenShouldVerify(t *testing.T) {
+    auth, err := main.NewAuthenticator(testAuthKey(), time.Hour)
+
+    if err != nil {
+        t.Fatalf("Failed to create authenticator: %s", err)
+    }
+
+    token, err := auth.Issue(model.Identifier(4), model.Identifier(7), main.RolePlayer)
+
+    if err != nil {
+        t.Fatalf("Failed to issue token: %s", err)
+    }
+
+    session, err := auth.Verify(token)
+
+    if err != nil {
+        t.Fatalf("Expected token to verify but got %s", err)
+    }
+
+    if session.RoomId != 4 || session.UserId != 7 || session.Role != main.RolePlayer {
+        t.Errorf("Expected player 7 in room 4 but got %+v", session)
+    }
+}
+
+func TestTamperedTokenShouldNotVerify(t *testing.T) {
+    auth, _ := main.NewAuthenticator(testAuthKey(), time.Hour)
+    token, _ := auth.Issue(model.Identifier(4), model.Identifier(7), main.RolePlayer)
+
+    // Swap the player session for one claiming to be the game master
+    gmToken, _ := auth.Issue(model.Identifier(4), model.Identifier(7), main.RoleGameMaster)
+    forged := strings.Split(gmToken, ".")[0] + "." + strings.Split(token, ".")[1]
+
+    _, err := auth.Verify(forged)
+
+    if err == nil {
+        t.Error("Expected forged token to be rejected")
+    }
+
+    other, _ := main.NewAuthenticator(bytes.Repeat([]byte("x"), main.MinAuthKeyBytes),
+                                      time.Hour)
+    _, err = other.Verify(token)
+
+    if err == nil {
+        t.Error("Expected token signed with another key to be rejected")
+    }
+}
+
+func TestExpiredTokenShouldNotVerify(t *testing.T) {
+    auth, _ := main.NewAuthenticator(testAuthKey(), time.Nanosecond)
+    token, _ := auth.Issue(model.Identifier(4), model.Identifier(7), main.RolePlayer)
+
+    _, err := auth.Verify(token)
+
+    if err == nil {
+        t.Error("Expected expired token to be rejected")
+    }
+}
+
+func TestExpiredTokenShouldStillRefresh(t *testing.T) {
+    auth, _ := main.NewAuthenticator(testAuthKey(), time.Nanosecond)
+    token, _ := auth.Issue(model.Identifier(4), model.Identifier(7), main.RoleGameMaster)
+
+    session, err := auth.VerifyRefreshable(token)
+
+    if err != nil || session.UserId != 7 || session.Role != main.RoleGameMaster {
+        t.Errorf("Expected the game master's session to refresh but got %+v (%v)",
+                 session,
+                 err)
+    }
+
+    _, err = auth.VerifyRefreshable(token[:len(token) - 2])
+
+    if err == nil {
+        t.Error("Expected a tampered token not to refresh")
+    }
+}
+
+func TestRefreshedSessionShouldEndAtMaxAge(t *testing.T) {
+    auth, _ := main.NewAuthenticator(testAuthKey(), time.Hour)
+    now := time.Unix(1000000, 0)
+    auth.SetNow(func() time.Time { return now })
+
+    token, _ := auth.Issue(model.Identifier(4), model.Identifier(7), main.RoleGameMaster)
+
+    // Keep refreshing a day at a time, well within the refresh window
+    for elapsed := time.Duration(0); elapsed < main.MaxSessionAge; {
+        session, err := auth.VerifyRefreshable(token)
+
+        if err != nil {
+            t.Fatalf("Expected the session to refresh after %v but got %s",
+                     elapsed,
+                     err)
+        }
+
+        token, _ = auth.Refresh(session)
+        elapsed += 24 * time.Hour
+        now = now.Add(24 * time.Hour)
+    }
+
+    _, err := auth.VerifyRefreshable(token)
+
+    if err == nil {
+        t.Error("Expected the session to end MaxSessionAge after it was issued")
+    }
+}
+
+func TestPersistentAuthKeyShouldSurviveRestart(t *testing.T) {
+    path := filepath.Join(t.TempDir(), main.StoredAuthKeyFile)
+    first, err := main.NewPersistentAuthenticator(path, time.Hour)
+
+    if err != nil {
+        t.Fatalf("Failed to create authenticator: %s", err)
+    }
+
+    token, _ := first.Issue(model.Identifier(4), model.Identifier(7), main.RolePlayer)
+
+    restarted, err := main.NewPersistentAuthenticator(path, time.Hour)
+
+    if err != nil {
+        t.Fatalf("Failed to reload authenticator: %s", err)
+    }
+
+    if _, err := restarted.Verify(token); err != nil {
+        t.Errorf("Expected token to verify after a restart but got %s", err)
+    }
+}
+
+func TestShortAuthKeyShouldBeRejected(t *testing.T) {
+    _, err := main.NewAuthenticator([]byte("secret"), time.Hour)
+
+    if err == nil {
+        t.Error("Expected short key to be rejected")
+    }
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+    "crypto/tls"
+    "fmt"
+    "log/slog"
+    "os"
+    "sync"
+    "time"
+)
+
+const DefaultCertCheckPeriod = 30 * time.Second
+
+// Serves a certificate from files on disk, picking up a renewed certificate
+// without a restart. The files are checked every so often and reloaded when
+// either has been modified.
+type CertReloader struct {
+    certFile string
+    keyFile string
+    logger *slog.Logger
+
+    lock sync.RWMutex
+    cert *tls.Certificate
+    certModified time.Time
+    keyModified time.Time
+
+    stop chan struct{}
+    stopOnce sync.Once
+}
+
+func NewCertReloader(certFile string,
+                     keyFile string,
+                     logger *slog.Logger) (*CertReloader, error) {
+    reloader := &CertReloader{certFile: certFile,
+                              keyFile: keyFile,
+                              logger: logger,
+                              stop: make(chan struct{})}
+
+    _, err := reloader.Reload()
+
+    if err != nil {
+        return nil, err
+    }
+
+    return reloader, nil
+}
+
+func modified(path string) (time.Time, error) {
+    info, err := os.Stat(path)
+
+    if err != nil {
+        return time.Time{}, err
+    }
+
+    return info.ModTime(), nil
+}
+
+// Load the certificate again if the files have changed, returning whether it
+// was. A certificate that fails to load leaves the old one in use.
+func (r *CertReloader) Reload() (bool, error) {
+    certModified, err := modified(r.certFile)
+
+    if err != nil {
+        return false, fmt.Errorf("Failed to check certificate: %s", err)
+    }
+
+    keyModified, err := modified(r.keyFile)
+
+    if err != nil {
+        return false, fmt.Errorf("Failed to check private key: %s", err)
+    }
+
+    r.lock.RLock()
+    unchanged := r.cert != nil &&
+                 certModified.Equal(r.certModified) &&
+                 keyModified.Equal(r.keyModified)
+    r.lock.RUnlock()
+
+    if unchanged {
+        return false, nil
+    }
+
+    cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+
+    if err != nil {
+        return false, fmt.Errorf("Failed to load certificate: %s", err)
+    }
+
+    r.lock.Lock()
+    defer r.lock.Unlock()
+
+    r.cert = &cert
+    r.certModified = certModified
+    r.keyModified = keyModified
+    return true, nil
+}
+
+// For tls.Config.GetCertificate
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+    r.lock.RLock()
+    defer r.lock.RUnlock()
+
+    return r.cert, nil
+}
+
+// Check for changes every period until Stop is called
+func (r *CertReloader) Watch(period time.Duration) {
+    go func() {
+        ticker := time.NewTicker(period)
+        defer ticker.Stop()
+
+        for {
+            select {
+            case <- r.stop:
+                return
+            case <- ticker.C:
+                reloaded, err := r.Reload()
+
+                if err != nil {
+                    // Likely caught half way through being replaced, try
+                    // again next time
+                    r.logger.Warn("Keeping old certificate", "error", err)
+                } else if reloaded {
+                    r.logger.Info("Reloaded certificate", "file", r.certFile)
+                }
+            }
+        }
+    }()
+}
+
+func (r *CertReloader) Stop() {
+    r.stopOnce.Do(func() {
+        close(r.stop)
+    })
+}
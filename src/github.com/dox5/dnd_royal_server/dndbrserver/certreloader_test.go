@@ -0,0 +1,187 @@
+package main_test
+
+import (
+    "crypto/ecdsa"
+    "crypto/elliptic"
+    "crypto/rand"
+    "crypto/x509"
+    "crypto/x509/pkix"
+    "encoding/pem"
+    "io/ioutil"
+    "math/big"
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+
+    "github.com/dox5/dnd_royal_server/dndbrserver"
+)
+
+// Write a self signed certificate with the given serial number, marking the
+// files as modified at the given time
+func writeCert(t *testing.T,
+               certFile string,
+               keyFile string,
+               serial int64,
+               modified time.Time) {
+    key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+    if err != nil {
+        t.Fatalf("Failed to generate key: %s", err)
+    }
+
+    template := x509.Certificate{SerialNumber: big.NewInt(serial),
+                                 Subject: pkix.Name{CommonName: "localhost"},
+                                 NotBefore: time.Now().Add(-time.Hour),
+                                 NotAfter: time.Now().Add(time.Hour),
+                                 DNSNames: []string{"localhost"}}
+
+    der, err := x509.CreateCertificate(rand.Reader,
+                                       &template,
+                                       &template,
+                                       &key.PublicKey,
+                                       key)
+
+    if err != nil {
+        t.Fatalf("Failed to create certificate: %s", err)
+    }
+
+    keyDer, err := x509.MarshalECPrivateKey(key)
+
+    if err != nil {
+        t.Fatalf("Failed to marshal key: %s", err)
+    }
+
+    certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+    keyPem := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY",
+                                            Bytes: keyDer})
+
+    for path, data := range map[string][]byte{certFile: certPem,
+                                              keyFile: keyPem} {
+        err = ioutil.WriteFile(path, data, 0600)
+
+        if err != nil {
+            t.Fatalf("Failed to write %s: %s", path, err)
+        }
+
+        err = os.Chtimes(path, modified, modified)
+
+        if err != nil {
+            t.Fatalf("Failed to set modified time of %s: %s", path, err)
+        }
+    }
+}
+
+func servedSerial(t *testing.T, reloader *main.CertReloader) int64 {
+    cert, err := reloader.GetCertificate(nil)
+
+    if err != nil || cert == nil {
+        t.Fatalf("Expected a certificate but got %v, %v", cert, err)
+    }
+
+    parsed, err := x509.ParseCertificate(cert.Certificate[0])
+
+    if err != nil {
+        t.Fatalf("Failed to parse served certificate: %s", err)
+    }
+
+    return parsed.SerialNumber.Int64()
+}
+
+func TestCertificatesShouldReloadWhenChanged(t *testing.T) {
+    dir, err := ioutil.TempDir("", "certs")
+
+    if err != nil {
+        t.Fatalf("Failed to create temp dir: %s", err)
+    }
+    defer os.RemoveAll(dir)
+
+    certFile := filepath.Join(dir, "cert.pem")
+    keyFile := filepath.Join(dir, "key.pem")
+    start := time.Now().Add(-time.Minute)
+    writeCert(t, certFile, keyFile, 1, start)
+
+    reloader, err := main.NewCertReloader(certFile,
+                                          keyFile,
+                                          discardLogger())
+
+    if err != nil {
+        t.Fatalf("Failed to load certificate: %s", err)
+    }
+    defer reloader.Stop()
+
+    reloaded, err := reloader.Reload()
+
+    if reloaded || err != nil {
+        t.Errorf("Expected unchanged files not to reload but got %v, %v",
+                 reloaded,
+                 err)
+    }
+
+    writeCert(t, certFile, keyFile, 2, start.Add(time.Second))
+    reloaded, err = reloader.Reload()
+
+    if !reloaded || err != nil {
+        t.Fatalf("Expected changed files to reload but got %v, %v",
+                 reloaded,
+                 err)
+    }
+
+    if serial := servedSerial(t, reloader); serial != 2 {
+        t.Errorf("Expected new certificate to be served but got serial %d",
+                 serial)
+    }
+
+    // A half written replacement shouldn't stop us serving
+    err = ioutil.WriteFile(keyFile, []byte("not a key"), 0600)
+
+    if err != nil {
+        t.Fatalf("Failed to write key: %s", err)
+    }
+
+    _, err = reloader.Reload()
+
+    if err == nil {
+        t.Errorf("Expected a broken key to fail to load")
+    }
+
+    if serial := servedSerial(t, reloader); serial != 2 {
+        t.Errorf("Expected old certificate to be kept but got serial %d",
+                 serial)
+    }
+}
+
+func TestServerShouldUseConfiguredLimits(t *testing.T) {
+    dir, err := ioutil.TempDir("", "certs")
+
+    if err != nil {
+        t.Fatalf("Failed to create temp dir: %s", err)
+    }
+    defer os.RemoveAll(dir)
+
+    config := main.DefaultConfig()
+    config.ReadTimeout = main.Duration(7 * time.Second)
+    config.MaxHeaderBytes = 4096
+    config.TLSCertFile = filepath.Join(dir, "cert.pem")
+    config.TLSKeyFile = filepath.Join(dir, "key.pem")
+    writeCert(t, config.TLSCertFile, config.TLSKeyFile, 1, time.Now())
+
+    server, certs, err := main.NewServer(config,
+                                         nil,
+                                         discardLogger())
+
+    if err != nil {
+        t.Fatalf("Failed to create server: %s", err)
+    }
+    defer certs.Stop()
+
+    if server.ReadTimeout != 7 * time.Second || server.MaxHeaderBytes != 4096 {
+        t.Errorf("Expected configured limits but got %v and %d",
+                 server.ReadTimeout,
+                 server.MaxHeaderBytes)
+    }
+
+    if server.TLSConfig == nil || server.TLSConfig.GetCertificate == nil {
+        t.Errorf("Expected certificates to come from the reloader")
+    }
+}
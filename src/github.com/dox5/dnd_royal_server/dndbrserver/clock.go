@@ -0,0 +1,105 @@
+package main
+
+import (
+    "sort"
+    "sync"
+    "time"
+)
+
+// Where the RoomManager gets the time from, so tests can control it
+type Clock interface {
+    Now() time.Time
+    // Like time.After
+    After(d time.Duration) <-chan time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+    return time.Now()
+}
+
+func (systemClock) After(d time.Duration) <-chan time.Time {
+    return time.After(d)
+}
+
+// The real time
+func SystemClock() Clock {
+    return systemClock{}
+}
+
+type fakeWaiter struct {
+    at time.Time
+    fire chan time.Time
+}
+
+// A Clock that only moves when told to. Anything waiting on After is woken
+// once Advance takes the time past its deadline.
+type FakeClock struct {
+    lock sync.Mutex
+    now time.Time
+    waiters []fakeWaiter
+    // Signalled whenever someone starts waiting
+    waiting *sync.Cond
+}
+
+func NewFakeClock(start time.Time) *FakeClock {
+    clock := &FakeClock{now: start}
+    clock.waiting = sync.NewCond(&clock.lock)
+    return clock
+}
+
+func (c *FakeClock) Now() time.Time {
+    c.lock.Lock()
+    defer c.lock.Unlock()
+    return c.now
+}
+
+func (c *FakeClock) After(d time.Duration) <-chan time.Time {
+    c.lock.Lock()
+    defer c.lock.Unlock()
+
+    fire := make(chan time.Time, 1)
+
+    if d <= 0 {
+        fire <- c.now
+        return fire
+    }
+
+    c.waiters = append(c.waiters, fakeWaiter{at: c.now.Add(d), fire: fire})
+    c.waiting.Broadcast()
+    return fire
+}
+
+// Move the time on, waking everyone whose wait is over in deadline order
+func (c *FakeClock) Advance(d time.Duration) {
+    c.lock.Lock()
+    defer c.lock.Unlock()
+
+    c.now = c.now.Add(d)
+
+    sort.SliceStable(c.waiters, func(i, j int) bool {
+        return c.waiters[i].at.Before(c.waiters[j].at)
+    })
+
+    remaining := c.waiters[:0]
+    for _, waiter := range c.waiters {
+        if waiter.at.After(c.now) {
+            remaining = append(remaining, waiter)
+        } else {
+            waiter.fire <- waiter.at
+        }
+    }
+    c.waiters = remaining
+}
+
+// Wait until at least count goroutines are waiting on After. Waits whose
+// goroutine has stopped listening still count until their deadline passes.
+func (c *FakeClock) BlockUntil(count int) {
+    c.lock.Lock()
+    defer c.lock.Unlock()
+
+    for len(c.waiters) < count {
+        c.waiting.Wait()
+    }
+}
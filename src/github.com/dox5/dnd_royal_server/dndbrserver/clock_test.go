@@ -0,0 +1,45 @@
+package main_test
+
+import (
+    "testing"
+    "time"
+
+    "github.com/dox5/dnd_royal_server/dndbrserver"
+)
+
+func TestFakeClockShouldOnlyFireWhenAdvanced(t *testing.T) {
+    start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+    clock := main.NewFakeClock(start)
+
+    soon := clock.After(time.Second)
+    later := clock.After(time.Minute)
+
+    clock.Advance(30 * time.Second)
+
+    select {
+    case fired := <- soon:
+        if !fired.Equal(start.Add(time.Second)) {
+            t.Errorf("Expected to fire at the deadline but fired at %v", fired)
+        }
+    default:
+        t.Error("Expected wait of a second to be over")
+    }
+
+    select {
+    case <- later:
+        t.Error("Expected wait of a minute not to be over yet")
+    default:
+    }
+
+    if clock.Now() != start.Add(30 * time.Second) {
+        t.Errorf("Expected time to have moved on 30s but it is %v", clock.Now())
+    }
+
+    clock.Advance(30 * time.Second)
+
+    select {
+    case <- later:
+    default:
+        t.Error("Expected wait of a minute to be over")
+    }
+}
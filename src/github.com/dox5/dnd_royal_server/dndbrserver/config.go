@@ -0,0 +1,374 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "flag"
+    "fmt"
+    "io/ioutil"
+    "log/slog"
+    "os"
+    "strings"
+    "time"
+    "unicode"
+)
+
+const (
+    // Environment variables are the flag name in upper snake case with this
+    // in front, e.g. -listenAddress is DNDBR_LISTEN_ADDRESS
+    EnvPrefix = "DNDBR_"
+    ConfigFileEnv = EnvPrefix + "CONFIG"
+)
+
+var logLevels = []string{"debug", "info", "warn", "error"}
+
+// A time.Duration that reads and writes as a string like "1m30s", both as a
+// flag and in the config file
+type Duration time.Duration
+
+func (d Duration) String() string {
+    return time.Duration(d).String()
+}
+
+func (d *Duration) Set(value string) error {
+    parsed, err := time.ParseDuration(value)
+
+    if err != nil {
+        return err
+    }
+
+    *d = Duration(parsed)
+    return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+    return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+    var value string
+    err := json.Unmarshal(data, &value)
+
+    if err != nil {
+        return fmt.Errorf("Durations must be strings like \"10s\": %s", err)
+    }
+
+    return d.Set(value)
+}
+
+// Everything that can differ between deployments. Settings come from the
+// defaults, then the config file, then the environment, then flags, each
+// overriding the last.
+type Config struct {
+    ListenAddress string
+    StaticDir string
+    MapDir string
+    // Rooms are only kept in memory if not set
+    RoomStore string
+
+    UpdateRateHz float64
+    MaxRooms int
+    RoomIdleTimeout Duration
+
+    LogLevel string
+    // "text" for key=value lines or "json"
+    LogFormat string
+
+    AuthKeyFile string
+    SessionLifetime Duration
+
+    // Serve HTTPS when both are set
+    TLSCertFile string
+    TLSKeyFile string
+    // How often to check for a renewed certificate, 0 to never reload it
+    CertCheckPeriod Duration
+
+    ReadHeaderTimeout Duration
+    ReadTimeout Duration
+    // Event streams are cut off after this long, so it is off by default
+    WriteTimeout Duration
+    IdleTimeout Duration
+    ShutdownTimeout Duration
+    MaxHeaderBytes int
+}
+
+func DefaultConfig() Config {
+    return Config{ListenAddress: ":8000",
+                  StaticDir: "/web_static",
+                  MapDir: "maps",
+                  UpdateRateHz: float64(DefaultUpdateRateHz),
+                  RoomIdleTimeout: Duration(12 * time.Hour),
+                  LogLevel: "info",
+                  LogFormat: "text",
+                  SessionLifetime: Duration(DefaultSessionLifetime),
+                  CertCheckPeriod: Duration(DefaultCertCheckPeriod),
+                  ReadHeaderTimeout: Duration(10 * time.Second),
+                  ReadTimeout: Duration(30 * time.Second),
+                  IdleTimeout: Duration(2 * time.Minute),
+                  ShutdownTimeout: Duration(10 * time.Second),
+                  MaxHeaderBytes: 64 << 10}
+}
+
+func (c *Config) flagSet() *flag.FlagSet {
+    flags := flag.NewFlagSet("dndbrserver", flag.ContinueOnError)
+
+    flags.StringVar(&c.ListenAddress,
+                    "listenAddress",
+                    c.ListenAddress,
+                    "Address to serve on, like :8000")
+    flags.StringVar(&c.StaticDir,
+                    "staticDir",
+                    c.StaticDir,
+                    "Directory holding the web client")
+    flags.StringVar(&c.MapDir,
+                    "mapDir",
+                    c.MapDir,
+                    "Directory to keep uploaded map images in")
+    flags.StringVar(&c.RoomStore,
+                    "roomStore",
+                    c.RoomStore,
+                    "Directory to persist rooms in, rooms are only kept in " +
+                    "memory if not set")
+    flags.Float64Var(&c.UpdateRateHz,
+                     "updateRateHz",
+                     c.UpdateRateHz,
+                     "How many times a second rooms are advanced")
+    flags.IntVar(&c.MaxRooms,
+                 "maxRooms",
+                 c.MaxRooms,
+                 "Most rooms that can exist at once, 0 for no limit")
+    flags.Var(&c.RoomIdleTimeout,
+              "roomIdleTimeout",
+              "Delete rooms nobody has used for this long, 0 to keep rooms " +
+              "forever")
+    flags.StringVar(&c.LogLevel,
+                    "logLevel",
+                    c.LogLevel,
+                    "One of " + strings.Join(logLevels, ", "))
+    flags.StringVar(&c.LogFormat,
+                    "logFormat",
+                    c.LogFormat,
+                    "One of " + strings.Join(logFormats, ", "))
+    flags.StringVar(&c.AuthKeyFile,
+                    "authKeyFile",
+                    c.AuthKeyFile,
+                    "File holding the key used to sign session tokens, if " +
+                    "not set one is made in the room store, or a random " +
+                    "key is used without a room store")
+    flags.Var(&c.SessionLifetime,
+              "sessionLifetime",
+              "How long session tokens are valid for")
+    flags.StringVar(&c.TLSCertFile,
+                    "tlsCertFile",
+                    c.TLSCertFile,
+                    "Certificate to serve HTTPS with")
+    flags.StringVar(&c.TLSKeyFile,
+                    "tlsKeyFile",
+                    c.TLSKeyFile,
+                    "Private key for the certificate")
+    flags.Var(&c.CertCheckPeriod,
+              "certCheckPeriod",
+              "How often to check the certificate files for changes, 0 to " +
+              "never reload them")
+    flags.Var(&c.ReadHeaderTimeout,
+              "readHeaderTimeout",
+              "Longest time to wait for request headers")
+    flags.Var(&c.ReadTimeout,
+              "readTimeout",
+              "Longest time to wait for a whole request")
+    flags.Var(&c.WriteTimeout,
+              "writeTimeout",
+              "Longest time to spend writing a response, 0 for no limit")
+    flags.Var(&c.IdleTimeout,
+              "idleTimeout",
+              "How long to keep idle connections open")
+    flags.Var(&c.ShutdownTimeout,
+              "shutdownTimeout",
+              "How long in-flight requests get to finish when shutting down")
+    flags.IntVar(&c.MaxHeaderBytes,
+                 "maxHeaderBytes",
+                 c.MaxHeaderBytes,
+                 "Largest request headers accepted")
+
+    return flags
+}
+
+// listenAddress -> DNDBR_LISTEN_ADDRESS
+func envName(flagName string) string {
+    var name strings.Builder
+    name.WriteString(EnvPrefix)
+
+    for i, r := range flagName {
+        if unicode.IsUpper(r) && i > 0 {
+            name.WriteRune('_')
+        }
+        name.WriteRune(unicode.ToUpper(r))
+    }
+
+    return name.String()
+}
+
+func (c *Config) loadFile(path string) error {
+    data, err := ioutil.ReadFile(path)
+
+    if err != nil {
+        return fmt.Errorf("Failed to read config file: %s", err)
+    }
+
+    decoder := json.NewDecoder(bytes.NewReader(data))
+    // Catch typos rather than silently using the default
+    decoder.DisallowUnknownFields()
+    err = decoder.Decode(c)
+
+    if err != nil {
+        return fmt.Errorf("Failed to parse config file %s: %s", path, err)
+    }
+
+    return nil
+}
+
+// Work out the config from the command line arguments (without the program
+// name) and environment. The config file is given by -config or DNDBR_CONFIG.
+func LoadConfig(args []string, getenv func(string) string) (Config, error) {
+    // Parse once to find the config file and which flags were given, they
+    // are applied again at the end so they win over everything else
+    given := DefaultConfig()
+    flags := given.flagSet()
+    configFile := flags.String("config",
+                               getenv(ConfigFileEnv),
+                               "JSON file of settings, see Config")
+
+    err := flags.Parse(args)
+
+    if err != nil {
+        return Config{}, err
+    }
+
+    if flags.NArg() > 0 {
+        return Config{}, fmt.Errorf("Unexpected arguments: %v", flags.Args())
+    }
+
+    config := DefaultConfig()
+
+    if *configFile != "" {
+        err = config.loadFile(*configFile)
+
+        if err != nil {
+            return Config{}, err
+        }
+    }
+
+    settings := config.flagSet()
+
+    settings.VisitAll(func(f *flag.Flag) {
+        if value := getenv(envName(f.Name)); value != "" && err == nil {
+            err = settings.Set(f.Name, value)
+
+            if err != nil {
+                err = fmt.Errorf("Invalid %s: %s", envName(f.Name), err)
+            }
+        }
+    })
+
+    flags.Visit(func(f *flag.Flag) {
+        if f.Name != "config" && err == nil {
+            err = settings.Set(f.Name, f.Value.String())
+        }
+    })
+
+    if err != nil {
+        return Config{}, err
+    }
+
+    return config, config.Validate()
+}
+
+func (c Config) Validate() error {
+    if c.ListenAddress == "" {
+        return fmt.Errorf("Listen address must be set")
+    }
+
+    if c.StaticDir == "" || c.MapDir == "" {
+        return fmt.Errorf("Static and map directories must be set")
+    }
+
+    if c.UpdateRateHz <= 0 || c.UpdateRateHz > 100 {
+        return fmt.Errorf("Update rate must be between 0 and 100Hz, got %v",
+                          c.UpdateRateHz)
+    }
+
+    if c.MaxRooms < 0 {
+        return fmt.Errorf("Max rooms must not be negative, got %d", c.MaxRooms)
+    }
+
+    validLevel := false
+    for _, level := range logLevels {
+        validLevel = validLevel || c.LogLevel == level
+    }
+
+    if !validLevel {
+        return fmt.Errorf("Log level must be one of %s, got %q",
+                          strings.Join(logLevels, ", "),
+                          c.LogLevel)
+    }
+
+    validFormat := false
+    for _, format := range logFormats {
+        validFormat = validFormat || c.LogFormat == format
+    }
+
+    if !validFormat {
+        return fmt.Errorf("Log format must be one of %s, got %q",
+                          strings.Join(logFormats, ", "),
+                          c.LogFormat)
+    }
+
+    if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+        return fmt.Errorf("TLS needs both a certificate and a key file")
+    }
+
+    if c.SessionLifetime <= 0 || c.ShutdownTimeout <= 0 {
+        return fmt.Errorf("Session lifetime and shutdown timeout must be " +
+                          "positive")
+    }
+
+    if c.MaxHeaderBytes < 1024 {
+        return fmt.Errorf("Max header bytes must be at least 1024, got %d",
+                          c.MaxHeaderBytes)
+    }
+
+    for _, d := range []Duration{c.RoomIdleTimeout,
+                                 c.CertCheckPeriod,
+                                 c.ReadHeaderTimeout,
+                                 c.ReadTimeout,
+                                 c.WriteTimeout,
+                                 c.IdleTimeout} {
+        if d < 0 {
+            return fmt.Errorf("Timeouts must not be negative, got %v", d)
+        }
+    }
+
+    return nil
+}
+
+func (c Config) TLSEnabled() bool {
+    return c.TLSCertFile != ""
+}
+
+func (c Config) RoomSettings() RoomSettings {
+    return RoomSettings{UpdateRateHz: float32(c.UpdateRateHz),
+                        MaxRooms: c.MaxRooms}
+}
+
+// Log the settings in use by their flag names
+func (c Config) Print(logger *slog.Logger) {
+    settings := make([]interface{}, 0)
+    c.flagSet().VisitAll(func(f *flag.Flag) {
+        settings = append(settings, slog.String(f.Name, f.Value.String()))
+    })
+    logger.Info("Effective config", settings...)
+}
+
+func LoadConfigFromCommandLine() (Config, error) {
+    return LoadConfig(os.Args[1:], os.Getenv)
+}
@@ -0,0 +1,109 @@
+package main_test
+
+import (
+    "io/ioutil"
+    "path/filepath"
+    "testing"
+    "time"
+
+    "github.com/dox5/dnd_royal_server/dndbrserver"
+)
+
+func envOf(values map[string]string) func(string) string {
+    return func(name string) string {
+        return values[name]
+    }
+}
+
+func TestDefaultConfigShouldBeValid(t *testing.T) {
+    config, err := main.LoadConfig(nil, envOf(nil))
+
+    if err != nil {
+        t.Fatalf("Expected default config to be valid but got %s", err)
+    }
+
+    if config != main.DefaultConfig() {
+        t.Errorf("Expected default config but got %+v", config)
+    }
+}
+
+func TestConfigSourcesShouldOverrideInOrder(t *testing.T) {
+    dir, err := ioutil.TempDir("", "config")
+
+    if err != nil {
+        t.Fatalf("Failed to create temp dir: %s", err)
+    }
+
+    path := filepath.Join(dir, "config.json")
+    err = ioutil.WriteFile(path,
+                           []byte(`{"ListenAddress": ":1",
+                                    "StaticDir": "/file",
+                                    "MaxRooms": 10,
+                                    "ShutdownTimeout": "3s"}`),
+                           0600)
+
+    if err != nil {
+        t.Fatalf("Failed to write config: %s", err)
+    }
+
+    env := envOf(map[string]string{"DNDBR_CONFIG": path,
+                                    "DNDBR_LISTEN_ADDRESS": ":2",
+                                    "DNDBR_MAX_ROOMS": "20"})
+
+    config, err := main.LoadConfig([]string{"-listenAddress", ":3"}, env)
+
+    if err != nil {
+        t.Fatalf("Failed to load config: %s", err)
+    }
+
+    if config.ListenAddress != ":3" {
+        t.Errorf("Expected flag to win but listen address was %q",
+                 config.ListenAddress)
+    }
+
+    if config.MaxRooms != 20 {
+        t.Errorf("Expected environment to beat the file but max rooms was %d",
+                 config.MaxRooms)
+    }
+
+    if config.StaticDir != "/file" ||
+       time.Duration(config.ShutdownTimeout) != 3 * time.Second {
+        t.Errorf("Expected file to beat the defaults but got %+v", config)
+    }
+
+    if config.UpdateRateHz != main.DefaultConfig().UpdateRateHz {
+        t.Errorf("Expected unset settings to keep their default but update " +
+                 "rate was %v",
+                 config.UpdateRateHz)
+    }
+}
+
+func TestInvalidConfigShouldBeRejected(t *testing.T) {
+    invalid := [][]string{
+        {"-updateRateHz", "0"},
+        {"-maxRooms", "-1"},
+        {"-logLevel", "loud"},
+        {"-logFormat", "xml"},
+        {"-maxHeaderBytes", "10"},
+        {"-tlsCertFile", "cert.pem"},
+        {"-shutdownTimeout", "0s"},
+        {"-readTimeout", "soon"},
+        {"-listenAddress", ""},
+        {"unexpected"},
+    }
+
+    for _, args := range invalid {
+        _, err := main.LoadConfig(args, envOf(nil))
+
+        if err == nil {
+            t.Errorf("Expected %v to be rejected", args)
+        }
+    }
+
+    _, err := main.LoadConfig(nil, envOf(map[string]string{
+        "DNDBR_UPDATE_RATE_HZ": "fast"}))
+
+    if err == nil {
+        t.Error("Expected invalid environment variable to be rejected")
+    }
+}
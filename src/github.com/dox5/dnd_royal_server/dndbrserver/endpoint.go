@@ -1,55 +1,159 @@
 package main
 
 import (
-    "fmt"
     "net/http"
+    "sort"
+    "strings"
 
     "github.com/dox5/dnd_royal_server/api"
 )
 
-type methodPath struct {
+type EndpointHandler func(*http.Request) (interface{}, error)
+
+// Wraps a handler to do something before and/or after it
+type Middleware func(http.Handler) http.Handler
+
+type route struct {
     method string
-    path string
+    // Path split on "/", segments like {RoomId} match anything
+    pattern []string
+    handler http.Handler
 }
 
-type EndpointHandler func(*http.Request) (interface{}, error)
-
+// Routes requests by method and path. Every request goes through the
+// middleware added with Use, in the order it was added.
 type Endpoint struct {
-    handlers map[methodPath]EndpointHandler
+    routes []route
+    middleware []Middleware
 }
 
 func NewEndpoint() *Endpoint {
-    return &Endpoint{handlers: make(map[methodPath]EndpointHandler)}
+    return &Endpoint{}
+}
+
+// Apply middleware so that the first one given is outermost
+func Chain(handler http.Handler, middleware ...Middleware) http.Handler {
+    for i := len(middleware) - 1; i >= 0; i-- {
+        handler = middleware[i](handler)
+    }
+    return handler
+}
+
+func splitPath(path string) []string {
+    return strings.Split(strings.Trim(path, "/"), "/")
 }
 
+func (e *Endpoint) Use(middleware ...Middleware) {
+    e.middleware = append(e.middleware, middleware...)
+}
 
-// path is like /getMyThing
+// path is like /getMyThing or /thing/{ThingId}, see api.PathParam
+func (e *Endpoint) Handle(path string,
+                          method string,
+                          handler http.Handler,
+                          middleware ...Middleware) {
+    e.routes = append(e.routes, route{method: method,
+                                      pattern: splitPath(path),
+                                      handler: Chain(handler, middleware...)})
+}
+
+// For handlers that reply with JSON
 func (e *Endpoint) Register(path string,
                             method string,
                             handler EndpointHandler) {
-    key := methodPath{method: method,
-                      path: path}
-    e.handlers[key] = handler
+    jsonHandler := func(writer http.ResponseWriter, request *http.Request) {
+        response, err := handler(request)
+        api.FormatResponse(writer, response, err)
+    }
+
+    e.Handle(path,
+             method,
+             http.HandlerFunc(jsonHandler),
+             LimitBody(api.DefaultMaxBodyBytes))
 }
 
-// Note: by-value receiver!
-func (e Endpoint) ServeHTTP(writer http.ResponseWriter,
-                            request *http.Request) {
-    path := request.URL.Path
-    var response interface{}
-    var err error
+// Serve all of other's routes under prefix. other's middleware isn't used,
+// only this endpoint's.
+func (e *Endpoint) Mount(prefix string, other *Endpoint) {
+    prefixPattern := splitPath(prefix)
 
-    key := methodPath{method: request.Method,
-                      path: path}
-    handler, foundIt := e.handlers[key]
+    for _, r := range other.routes {
+        pattern := append(append([]string{}, prefixPattern...), r.pattern...)
+        e.routes = append(e.routes, route{method: r.method,
+                                          pattern: pattern,
+                                          handler: r.handler})
+    }
+}
 
-    if !foundIt {
-        msg := fmt.Sprintf("No handler found for %+v", key)
-        http.Error(writer, msg, http.StatusInternalServerError)
+func isParam(segment string) bool {
+    return strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}")
+}
+
+func (r route) match(segments []string) (map[string]string, bool) {
+    if len(segments) != len(r.pattern) {
+        return nil, false
+    }
+
+    var params map[string]string
+
+    for i, segment := range r.pattern {
+        if isParam(segment) {
+            if segments[i] == "" {
+                return nil, false
+            }
+
+            if params == nil {
+                params = make(map[string]string)
+            }
+            params[segment[1:len(segment) - 1]] = segments[i]
+        } else if segment != segments[i] {
+            return nil, false
+        }
+    }
+
+    return params, true
+}
+
+func (e *Endpoint) ServeHTTP(writer http.ResponseWriter,
+                             request *http.Request) {
+    Chain(http.HandlerFunc(e.route), e.middleware...).ServeHTTP(writer, request)
+}
+
+func (e *Endpoint) route(writer http.ResponseWriter, request *http.Request) {
+    segments := splitPath(request.URL.Path)
+    var allowed []string
+
+    for _, r := range e.routes {
+        params, matched := r.match(segments)
+
+        if !matched {
+            continue
+        }
+
+        if r.method != request.Method {
+            allowed = append(allowed, r.method)
+            continue
+        }
+
+        setMatchedRoute(request, r.pattern)
+        r.handler.ServeHTTP(writer, api.WithPathParams(request, params))
         return
     }
 
-    response, err = handler(request)
+    // Tell apart a path we don't have from one that is called the wrong way
+    if len(allowed) > 0 {
+        sort.Strings(allowed)
+        writer.Header().Set("Allow", strings.Join(allowed, ", "))
+        api.FormatResponse(writer,
+                           nil,
+                           api.MethodNotAllowed("%s does not accept %s requests",
+                                                request.URL.Path,
+                                                request.Method))
+        return
+    }
 
-    api.FormatResponse(writer, response, err)
+    api.FormatResponse(writer,
+                       nil,
+                       api.NotFound("No endpoint found for %s",
+                                    request.URL.Path))
 }
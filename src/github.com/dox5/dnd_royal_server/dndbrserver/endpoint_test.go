@@ -0,0 +1,396 @@
+package main_test
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+    "time"
+
+    "github.com/dox5/dnd_royal_server/api"
+    "github.com/dox5/dnd_royal_server/dndbrserver"
+    "github.com/dox5/dnd_royal_server/model"
+)
+
+func expectErrorResponse(t *testing.T,
+                         handler http.Handler,
+                         request *http.Request,
+                         status int,
+                         code string) {
+    t.Helper()
+    recorder := httptest.NewRecorder()
+    handler.ServeHTTP(recorder, request)
+
+    if recorder.Code != status {
+        t.Errorf("Expected status %d but got %d (%s)",
+                 status,
+                 recorder.Code,
+                 recorder.Body)
+    }
+
+    var response api.ErrorResponse
+    err := json.Unmarshal(recorder.Body.Bytes(), &response)
+
+    if err != nil || response.Error.Code != code {
+        t.Errorf("Expected error code %s but got %q", code, recorder.Body)
+    }
+}
+
+func TestRoomEndpointShouldReportMissingRoutes(t *testing.T) {
+    rooms := main.NewRoomManager()
+    auth, err := main.NewRandomAuthenticator(main.DefaultSessionLifetime)
+
+    if err != nil {
+        t.Fatalf("Failed to create authenticator: %s", err)
+    }
+
+    endpoint := main.MakeRoomEndpoint(rooms, discardLogger(), auth, nil)
+
+    expectErrorResponse(t,
+                        endpoint,
+                        httptest.NewRequest(http.MethodGet, "/nothing", nil),
+                        http.StatusNotFound,
+                        api.CodeNotFound)
+
+    expectErrorResponse(t,
+                        endpoint,
+                        httptest.NewRequest(http.MethodGet, "/create", nil),
+                        http.StatusMethodNotAllowed,
+                        api.CodeMethodNotAllowed)
+}
+
+func TestFogEndpointShouldReportTypedErrors(t *testing.T) {
+    rooms := main.NewRoomManager()
+    auth, err := main.NewRandomAuthenticator(main.DefaultSessionLifetime)
+
+    if err != nil {
+        t.Fatalf("Failed to create authenticator: %s", err)
+    }
+
+    endpoint := auth.Middleware(
+        main.MakeFogEndpoint(rooms, discardLogger()))
+
+    room, err := rooms.Create(model.DefaultRoomConfig())
+
+    if err != nil {
+        t.Fatalf("Failed to create room: %s", err)
+    }
+
+    post := func(roomId model.Identifier,
+                 role main.Role,
+                 body string) *http.Request {
+        request := httptest.NewRequest(http.MethodPost,
+                                       "/pause",
+                                       bytes.NewBufferString(body))
+        request.Header.Set("Content-Type", "application/json")
+
+        if role != "" {
+            token, err := auth.Issue(roomId, room.GameMaster().Id(), role)
+
+            if err != nil {
+                t.Fatalf("Failed to issue token: %s", err)
+            }
+
+            request.Header.Set("Authorization", "Bearer " + token)
+        }
+
+        return request
+    }
+
+    roomBody := fmt.Sprintf(`{"RoomId": "%d"}`, room.Id())
+
+    expectErrorResponse(t,
+                        endpoint,
+                        post(12345, main.RoleGameMaster, `{"RoomId": "12345"}`),
+                        http.StatusNotFound,
+                        api.CodeNotFound)
+
+    expectErrorResponse(t,
+                        endpoint,
+                        post(room.Id(), "", roomBody),
+                        http.StatusUnauthorized,
+                        api.CodeUnauthorised)
+
+    expectErrorResponse(t,
+                        endpoint,
+                        post(room.Id(), main.RolePlayer, roomBody),
+                        http.StatusForbidden,
+                        api.CodeForbidden)
+
+    expectErrorResponse(t,
+                        endpoint,
+                        post(room.Id(), main.RoleGameMaster, `{"RoomId": `),
+                        http.StatusBadRequest,
+                        api.CodeBadRequest)
+}
+
+func TestRouterShouldMatchPathParams(t *testing.T) {
+    router := main.NewEndpoint()
+    rooms := main.NewEndpoint()
+    rooms.Register("/room/{RoomId}/name",
+                 http.MethodGet,
+                 func(request *http.Request) (interface{}, error) {
+                     return api.PathParam(request, "RoomId"), nil
+                 })
+    router.Mount("/api", rooms)
+
+    recorder := httptest.NewRecorder()
+    router.ServeHTTP(recorder,
+                     httptest.NewRequest(http.MethodGet, "/api/room/42/name", nil))
+
+    if recorder.Code != http.StatusOK || recorder.Body.String() != `"42"` {
+        t.Errorf("Expected RoomId 42 but got %d %s", recorder.Code, recorder.Body)
+    }
+
+    recorder = httptest.NewRecorder()
+    router.ServeHTTP(recorder,
+                     httptest.NewRequest(http.MethodPost, "/api/room/42/name", nil))
+
+    if recorder.Code != http.StatusMethodNotAllowed ||
+       recorder.Header().Get("Allow") != http.MethodGet {
+        t.Errorf("Expected 405 allowing GET but got %d allowing %q",
+                 recorder.Code,
+                 recorder.Header().Get("Allow"))
+    }
+}
+
+func TestRouterMiddlewareShouldRunInOrder(t *testing.T) {
+    var order []string
+    named := func(name string) main.Middleware {
+        return func(next http.Handler) http.Handler {
+            return http.HandlerFunc(func(writer http.ResponseWriter,
+                                         request *http.Request) {
+                order = append(order, name)
+                next.ServeHTTP(writer, request)
+            })
+        }
+    }
+
+    router := main.NewEndpoint()
+    router.Use(named("first"), named("second"))
+    router.Handle("/thing",
+                  http.MethodGet,
+                  http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+                      order = append(order, "handler")
+                  }),
+                  named("route"))
+
+    router.ServeHTTP(httptest.NewRecorder(),
+                     httptest.NewRequest(http.MethodGet, "/thing", nil))
+
+    if fmt.Sprint(order) != "[first second route handler]" {
+        t.Errorf("Expected middleware then handler but got %v", order)
+    }
+}
+
+func TestFogEndpointShouldRejectInvalidFog(t *testing.T) {
+    rooms := main.NewRoomManager()
+    auth, _ := main.NewRandomAuthenticator(main.DefaultSessionLifetime)
+    endpoint := auth.Middleware(main.MakeFogEndpoint(rooms, discardLogger()))
+
+    room, err := rooms.Create(model.DefaultRoomConfig())
+
+    if err != nil {
+        t.Fatalf("Failed to create room: %s", err)
+    }
+
+    token, _ := auth.Issue(room.Id(), room.GameMaster().Id(), main.RoleGameMaster)
+
+    requests := map[string]string{
+        "/setPeriod": `{"Period": 0}`,
+        "/setTarget": `{"FogTarget": {"Radius": -5}}`,
+        "/advanceTime": `{"Amount": -1}`,
+        "/randomTarget": `{"Radius": 10, "RadiusFraction": 0.5}`,
+    }
+
+    for path, body := range requests {
+        body = fmt.Sprintf(`{"RoomId": "%d", %s`, room.Id(), body[1:])
+        request := httptest.NewRequest(http.MethodPost,
+                                       path,
+                                       bytes.NewBufferString(body))
+        request.Header.Set("Content-Type", "application/json")
+        request.Header.Set("Authorization", "Bearer " + token)
+
+        expectErrorResponse(t,
+                            endpoint,
+                            request,
+                            http.StatusBadRequest,
+                            api.CodeBadRequest)
+    }
+}
+
+func TestTimeEndpointShouldGiveServerTime(t *testing.T) {
+    clock := main.NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+    clock.Advance(1500 * time.Millisecond)
+
+    router := main.NewEndpoint()
+    router.Register("/time", http.MethodGet, main.ServerTime(clock))
+
+    recorder := httptest.NewRecorder()
+    router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/time", nil))
+
+    var response api.TimeResponse
+    err := json.Unmarshal(recorder.Body.Bytes(), &response)
+
+    if err != nil {
+        t.Fatalf("Failed to decode %q: %s", recorder.Body, err)
+    }
+
+    if !response.ServerTime.Equal(clock.Now()) {
+        t.Errorf("Expected server time %s but got %s",
+                 clock.Now(),
+                 response.ServerTime)
+    }
+}
+
+func TestFogEndpointShouldAcceptAnyShapeOfTheSameKind(t *testing.T) {
+    rooms := main.NewRoomManager()
+    auth, _ := main.NewRandomAuthenticator(main.DefaultSessionLifetime)
+    endpoint := auth.Middleware(main.MakeFogEndpoint(rooms, discardLogger()))
+
+    config := model.DefaultRoomConfig()
+    config.Fog = model.AnyShape{Shape: model.Rectangle{Width: 400, Height: 100}}
+    room, err := rooms.Create(config)
+
+    if err != nil {
+        t.Fatalf("Failed to create room: %s", err)
+    }
+
+    token, _ := auth.Issue(room.Id(), room.GameMaster().Id(), main.RoleGameMaster)
+
+    setTarget := func(target string) *httptest.ResponseRecorder {
+        body := fmt.Sprintf(`{"RoomId": "%d", "FogTarget": %s}`,
+                            room.Id(),
+                            target)
+        request := httptest.NewRequest(http.MethodPost,
+                                       "/setTarget",
+                                       bytes.NewBufferString(body))
+        request.Header.Set("Content-Type", "application/json")
+        request.Header.Set("Authorization", "Bearer " + token)
+
+        recorder := httptest.NewRecorder()
+        endpoint.ServeHTTP(recorder, request)
+        return recorder
+    }
+
+    recorder := setTarget(`{"Type": "rectangle", "Width": 100, "Height": 50}`)
+
+    if recorder.Code != http.StatusOK {
+        t.Errorf("Expected a rectangle target to be accepted but got %d (%s)",
+                 recorder.Code,
+                 recorder.Body)
+    }
+
+    // Circles can't turn into rectangles
+    recorder = setTarget(`{"Radius": 10}`)
+
+    if recorder.Code != http.StatusBadRequest {
+        t.Errorf("Expected a circle target to be rejected but got %d (%s)",
+                 recorder.Code,
+                 recorder.Body)
+    }
+
+    recorder = httptest.NewRecorder()
+    endpoint.ServeHTTP(recorder,
+                       httptest.NewRequest(http.MethodGet,
+                                           fmt.Sprintf("/location?RoomId=%d",
+                                                       room.Id()),
+                                           nil))
+
+    var location struct {
+        Target map[string]interface{}
+    }
+    err = json.Unmarshal(recorder.Body.Bytes(), &location)
+
+    if err != nil || location.Target["Type"] != model.ShapeRectangle {
+        t.Errorf("Expected a rectangle target in %s", recorder.Body)
+    }
+}
+
+func TestRefreshSessionShouldIssueTokenForSameUser(t *testing.T) {
+    rooms := main.NewRoomManager()
+    auth, _ := main.NewRandomAuthenticator(main.DefaultSessionLifetime)
+    endpoint := auth.Middleware(
+        main.MakeRoomEndpoint(rooms, discardLogger(), auth, nil))
+
+    room, err := rooms.Create(model.DefaultRoomConfig())
+
+    if err != nil {
+        t.Fatalf("Failed to create room: %s", err)
+    }
+
+    refresh := func(token string) *httptest.ResponseRecorder {
+        request := httptest.NewRequest(http.MethodPost, "/refreshSession", nil)
+        request.Header.Set("Authorization", "Bearer " + token)
+
+        recorder := httptest.NewRecorder()
+        endpoint.ServeHTTP(recorder, request)
+        return recorder
+    }
+
+    token, _ := auth.Issue(room.Id(), room.GameMaster().Id(), main.RoleGameMaster)
+    recorder := refresh(token)
+
+    var response api.SessionResponse
+    json.Unmarshal(recorder.Body.Bytes(), &response)
+    session, err := auth.Verify(response.Token)
+
+    if err != nil || session.UserId != room.GameMaster().Id() ||
+       session.Role != main.RoleGameMaster {
+        t.Errorf("Expected a new game master token but got %d %s",
+                 recorder.Code,
+                 recorder.Body)
+    }
+
+    // Nobody can refresh their way into a room they were never in
+    stranger, _ := auth.Issue(room.Id(), 12345, main.RolePlayer)
+
+    expectErrorResponse(t,
+                        endpoint,
+                        httptest.NewRequest(http.MethodPost, "/refreshSession", nil),
+                        http.StatusUnauthorized,
+                        api.CodeUnauthorised)
+
+    if recorder := refresh(stranger); recorder.Code != http.StatusUnauthorized {
+        t.Errorf("Expected a stranger's refresh to be refused but got %d",
+                 recorder.Code)
+    }
+}
+
+func TestTokenIdsShouldBeStringsBothWays(t *testing.T) {
+    rooms := main.NewRoomManager()
+    auth, _ := main.NewRandomAuthenticator(main.DefaultSessionLifetime)
+    endpoint := auth.Middleware(
+        main.MakeRoomEndpoint(rooms, discardLogger(), auth, nil))
+
+    room, err := rooms.Create(model.DefaultRoomConfig())
+
+    if err != nil {
+        t.Fatalf("Failed to create room: %s", err)
+    }
+
+    tokenId := room.GetPlayerTokens()[1].Id
+    body := fmt.Sprintf(`{"RoomId": "%d", "Name": "Ann", ` +
+                        `"Colour": "#ff0000", "TokenId": "%d"}`,
+                        room.Id(),
+                        tokenId)
+    request := httptest.NewRequest(http.MethodPost,
+                                   "/join",
+                                   bytes.NewBufferString(body))
+    request.Header.Set("Content-Type", "application/json")
+
+    recorder := httptest.NewRecorder()
+    endpoint.ServeHTTP(recorder, request)
+
+    var response map[string]interface{}
+    json.Unmarshal(recorder.Body.Bytes(), &response)
+
+    if response["TokenId"] != fmt.Sprint(tokenId) {
+        t.Errorf("Expected to join as token \"%d\" but got %d %s",
+                 tokenId,
+                 recorder.Code,
+                 recorder.Body)
+    }
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "log/slog"
+    "net/http"
+    "time"
+
+    "github.com/dox5/dnd_royal_server/api"
+)
+
+const (
+    // Stops proxies from timing out quiet streams
+    eventKeepAlivePeriod = 15 * time.Second
+)
+
+func logStreamClosed(request *http.Request,
+                     logger *slog.Logger,
+                     reason CloseReason) {
+    switch reason {
+    case ClosedTooSlow:
+        logger.WarnContext(request.Context(), "Dropped slow event subscriber")
+    case ClosedRoomDeleted:
+        logger.InfoContext(request.Context(),
+                           "Closed event stream for deleted room")
+    default:
+        logger.DebugContext(request.Context(),
+                            "Closed event stream",
+                            "reason", reason)
+    }
+}
+
+// Streams room events to the client as Server-Sent Events
+func roomEventsHandler(logger *slog.Logger, rooms *RoomManager) http.HandlerFunc {
+    return func(writer http.ResponseWriter, request *http.Request) {
+        flusher, canFlush := writer.(http.Flusher)
+
+        if !canFlush {
+            api.FormatResponse(writer,
+                               nil,
+                               fmt.Errorf("Streaming not supported"))
+            return
+        }
+
+        roomId, err := roomIdFromRequest(request)
+
+        if err != nil {
+            api.FormatResponse(writer, nil, err)
+            return
+        }
+
+        events, unsubscribe, err := rooms.Subscribe(roomId)
+
+        if err != nil {
+            api.FormatResponse(writer, nil, err)
+            return
+        }
+        defer unsubscribe()
+
+        header := writer.Header()
+        header.Set("Content-Type", "text/event-stream")
+        header.Set("Cache-Control", "no-cache")
+        header.Set("Connection", "keep-alive")
+        writer.WriteHeader(http.StatusOK)
+        flusher.Flush()
+
+        keepAlive := time.NewTicker(eventKeepAlivePeriod)
+        defer keepAlive.Stop()
+
+        for {
+            select {
+            case <- request.Context().Done():
+                return
+
+            case <- keepAlive.C:
+                _, err = fmt.Fprint(writer, ": keep-alive\n\n")
+
+            case event, open := <- events:
+                if !open {
+                    logStreamClosed(request, logger, unsubscribe())
+                    return
+                }
+
+                err = writeEvent(writer, event)
+            }
+
+            if err != nil {
+                return
+            }
+            flusher.Flush()
+        }
+    }
+}
+
+func writeEvent(writer http.ResponseWriter, event RoomEvent) error {
+    data, err := json.Marshal(event.Data)
+
+    if err != nil {
+        return err
+    }
+
+    _, err = fmt.Fprintf(writer, "event: %s\ndata: %s\n\n", event.Type, data)
+    return err
+}
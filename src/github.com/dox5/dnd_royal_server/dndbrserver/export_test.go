@@ -0,0 +1,24 @@
+package main
+
+import (
+  "sync"
+  "time"
+
+  "github.com/dox5/dnd_royal_server/model"
+)
+
+// Lets the benchmarks run the old per room advancer against the real lock
+func (rm *RoomManager) RoomLock(roomId model.Identifier) (sync.Locker, error) {
+    room, err := rm.getActiveRoom(roomId)
+
+    if err != nil {
+        return nil, err
+    }
+
+    return &room.roomLock, nil
+}
+
+// Lets the auth tests move time along
+func (a *Authenticator) SetNow(now func() time.Time) {
+    a.now = now
+}
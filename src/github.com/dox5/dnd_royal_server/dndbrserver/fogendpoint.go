@@ -1,8 +1,7 @@
 package main
 
 import (
-    "fmt"
-    "log"
+    "log/slog"
     "net/http"
 
     "github.com/dox5/dnd_royal_server/api"
@@ -11,59 +10,12 @@ import (
 
 type fogEndpoint struct {
     rooms *RoomManager
-    logger *log.Logger
-}
-
-func NewFogEndpoint(logger * log.Logger, rooms *RoomManager) fogEndpoint {
-    return fogEndpoint{logger: logger, rooms: rooms}
-}
-
-func (endpoint fogEndpoint) ServeHTTP(writer http.ResponseWriter,
-                                      request *http.Request) {
-
-    if endpoint.rooms == nil {
-        http.Error(writer,
-                   "rooms pointer should not be nil",
-                   http.StatusInternalServerError)
-        return
-    }
-
-    path := request.URL.Path
-    var response interface{}
-    var err error
-
-    switch path {
-    case "/resume":
-        response, err = endpoint.resume(request)
-    case "/pause":
-        response, err = endpoint.pause(request)
-    case "/paused":
-        response, err = endpoint.paused(request)
-    case "/setTarget":
-        response, err = endpoint.setTarget(request)
-    case "/setPeriod":
-        response, err = endpoint.period(request)
-    case "/location":
-        response, err = endpoint.location(request)
-    case "/getTarget":
-        response, err = endpoint.getTarget(request)
-    case "/advanceTime":
-        response, err = endpoint.advanceTime(request)
-    default:
-        err = fmt.Errorf("Unknown endpoint for fog: %s", path)
-    }
-
-    api.FormatResponse(writer, response, err)
+    logger *slog.Logger
 }
 
 func (endpoint fogEndpoint) resume(request *http.Request) (interface{}, error) {
-    if request.Method != http.MethodPost {
-        return nil, fmt.Errorf("resume is a POST endpoint")
-    }
-
     var resumeRequest struct {
         RoomId model.Identifier `json:",string"`
-        GameMasterId model.Identifier `json:",string"`
     }
 
     err := api.ParseJsonRequest(request, &resumeRequest)
@@ -72,13 +24,15 @@ func (endpoint fogEndpoint) resume(request *http.Request) (interface{}, error) {
         return nil, err
     }
 
+    _, err = authorise(request, resumeRequest.RoomId, RoleGameMaster)
+
+    if err != nil {
+        return nil, err
+    }
+
     err = endpoint.rooms.WithExclusiveRoom(resumeRequest.RoomId,
                                            func(room *model.Room) error {
-        if resumeRequest.GameMasterId != room.GameMaster().Id() {
-            return fmt.Errorf("Unautherised")
-        }
-
-        endpoint.logger.Printf("Resumed room %+v", room.Id())
+        endpoint.logger.InfoContext(request.Context(), "Resumed fog")
         room.Fog().Resume()
         return nil
     })
@@ -87,13 +41,8 @@ func (endpoint fogEndpoint) resume(request *http.Request) (interface{}, error) {
 }
 
 func (endpoint fogEndpoint) pause(request *http.Request) (interface{}, error) {
-    if request.Method != http.MethodPost {
-        return nil, fmt.Errorf("pause is a POST endpoint")
-    }
-
     var pauseRequest struct {
         RoomId model.Identifier `json:",string"`
-        GameMasterId model.Identifier `json:",string"`
     }
 
     err := api.ParseJsonRequest(request, &pauseRequest)
@@ -102,13 +51,15 @@ func (endpoint fogEndpoint) pause(request *http.Request) (interface{}, error) {
         return nil, err
     }
 
+    _, err = authorise(request, pauseRequest.RoomId, RoleGameMaster)
+
+    if err != nil {
+        return nil, err
+    }
+
     err = endpoint.rooms.WithExclusiveRoom(pauseRequest.RoomId,
                                            func(room *model.Room) error {
-        if pauseRequest.GameMasterId != room.GameMaster().Id() {
-            return fmt.Errorf("Unautherised")
-        }
-
-        endpoint.logger.Printf("Paused room %+v", room.Id())
+        endpoint.logger.InfoContext(request.Context(), "Paused fog")
         room.Fog().Pause()
         return nil
     })
@@ -117,11 +68,7 @@ func (endpoint fogEndpoint) pause(request *http.Request) (interface{}, error) {
 }
 
 func (endpoint fogEndpoint) paused(request *http.Request) (interface{}, error) {
-    if request.Method != http.MethodGet {
-        return nil, fmt.Errorf("paused is a GET endpoint")
-    }
-
-    roomId, err := api.RoomIdFromRequest(request)
+    roomId, err := roomIdFromRequest(request)
 
     if err != nil {
         return nil, err
@@ -137,17 +84,20 @@ func (endpoint fogEndpoint) paused(request *http.Request) (interface{}, error) {
     return response, err
 }
 
+type setPeriodRequest struct {
+    Period float32
+    RoomId model.Identifier `json:",string"`
+}
+
 func (endpoint fogEndpoint) period(request *http.Request) (interface{}, error) {
-    if request.Method != http.MethodPost {
-        return nil, fmt.Errorf("period is a POST endpoint")
-    }
+    var periodRequest setPeriodRequest
+    err := api.ParseJsonRequest(request, &periodRequest)
 
-    var periodRequest struct {
-        Period float32
-        RoomId model.Identifier `json:",string"`
-        GameMasterId model.Identifier `json:",string"`
+    if err != nil {
+        return nil, err
     }
-    err := api.ParseJsonRequest(request, &periodRequest)
+
+    _, err = authorise(request, periodRequest.RoomId, RoleGameMaster)
 
     if err != nil {
         return nil, err
@@ -155,135 +105,323 @@ func (endpoint fogEndpoint) period(request *http.Request) (interface{}, error) {
 
     err = endpoint.rooms.WithExclusiveRoom(periodRequest.RoomId,
                                            func(room *model.Room) error {
-        if periodRequest.GameMasterId != room.GameMaster().Id() {
-            return fmt.Errorf("Unautherised access")
-        }
-
-        endpoint.logger.Printf("Setting period to %v for room %v",
-                               periodRequest.Period,
-                               periodRequest.RoomId)
-        room.Fog().SetPeriod(periodRequest.Period)
-
-        return nil
+        endpoint.logger.InfoContext(request.Context(),
+                                    "Setting fog period",
+                                    "period", periodRequest.Period)
+        return room.Fog().SetPeriod(periodRequest.Period)
     })
 
     return nil, err
 }
 
 func (endpoint fogEndpoint) location(request *http.Request) (interface{}, error) {
-    if request.Method != http.MethodGet {
-        return nil, fmt.Errorf("location is a GET endpoint")
+    roomId, err := roomIdFromRequest(request)
+
+    if err != nil {
+        return nil, err
     }
 
-    roomId, err := api.RoomIdFromRequest(request)
+    return endpoint.rooms.GetFogLocation(roomId)
+}
+
+type setTargetRequest struct {
+    // Any shape, of the same kind as the fog
+    FogTarget model.AnyShape
+    // Linear if not given
+    Easing string
+    RoomId model.Identifier `json:",string"`
+}
+
+func (r *setTargetRequest) Validate() error {
+    err := r.FogTarget.Validate()
+
+    if err != nil {
+        return err
+    }
+
+    return model.ValidateEasing(r.Easing)
+}
+
+func (endpoint fogEndpoint) setTarget(request *http.Request) (interface{}, error) {
+    var targetRequest setTargetRequest
+
+    err := api.ParseJsonRequest(request, &targetRequest)
 
     if err != nil {
         return nil, err
     }
 
-    var fogState struct {
-        Current model.Circle
-        Target  model.Circle
-        Rate   model.Rate
+    _, err = authorise(request, targetRequest.RoomId, RoleGameMaster)
+
+    if err != nil {
+        return nil, err
     }
 
-    err = endpoint.rooms.WithSharedRoom(roomId, func(room *model.Room) error {
-        fog := room.Fog()
-        fogState.Current = fog.Current()
-        fogState.Target  = fog.Target()
+    err = endpoint.rooms.WithExclusiveRoom(targetRequest.RoomId,
+                                           func(room *model.Room) error {
+        err := room.CheckShapeOnMap(targetRequest.FogTarget.Shape)
 
-        if !fog.Paused() {
-            fogState.Rate = fog.Rate()
+        if err != nil {
+            return err
         }
-        return nil
+
+        endpoint.logger.InfoContext(request.Context(),
+                                    "Setting fog target",
+                                    "target", targetRequest.FogTarget,
+                                    "easing", targetRequest.Easing)
+        return room.Fog().SetTargetEased(targetRequest.FogTarget.Shape,
+                                         targetRequest.Easing)
     })
 
-    return fogState, err
+    return nil, err
 }
 
-func (endpoint fogEndpoint) setTarget(request *http.Request) (interface{}, error) {
-    if request.Method != http.MethodPost {
-        return nil, fmt.Errorf("target is a POST endpoint")
+func (endpoint fogEndpoint) randomTarget(request *http.Request) (interface{}, error) {
+    // Give either Radius or RadiusFraction (of the current radius). Leave out
+    // the Seed to have one picked, it is sent back and kept in the fog state.
+    var randomRequest struct {
+        Radius float32
+        RadiusFraction float32
+        EdgeBias float32
+        Seed *int64 `json:",string"`
+        RoomId model.Identifier `json:",string"`
     }
 
-    var targetRequest struct {
-        FogTarget model.Circle
-        RoomId model.Identifier `json:",string"`
-        GameMasterId model.Identifier `json:",string"`
+    err := api.ParseJsonRequest(request, &randomRequest)
+
+    if err != nil {
+        return nil, err
     }
 
-    err := api.ParseJsonRequest(request, &targetRequest)
+    if randomRequest.Radius != 0 && randomRequest.RadiusFraction != 0 {
+        return nil, api.BadRequest("Give either Radius or RadiusFraction, " +
+                                   "not both")
+    }
+
+    seed := int64(model.MakeId())
+    if randomRequest.Seed != nil {
+        seed = *randomRequest.Seed
+    }
+
+    var response struct {
+        Target model.Circle
+        Seed int64 `json:",string"`
+    }
+
+    _, err = authorise(request, randomRequest.RoomId, RoleGameMaster)
 
     if err != nil {
         return nil, err
     }
 
-    err = endpoint.rooms.WithExclusiveRoom(targetRequest.RoomId,
+    err = endpoint.rooms.WithExclusiveRoom(randomRequest.RoomId,
                                            func(room *model.Room) error {
-        if targetRequest.GameMasterId != room.GameMaster().Id() {
-            return fmt.Errorf("Unautherised")
+        // Only a circular fog can have a random target, RandomTarget says so
+        radius := randomRequest.Radius
+        current, circular := room.Fog().Current().(model.Circle)
+        if randomRequest.RadiusFraction != 0 && circular {
+            radius = randomRequest.RadiusFraction * current.Radius
+        }
+
+        target, err := room.RandomTarget(radius, randomRequest.EdgeBias, seed)
+
+        if err != nil {
+            return err
         }
 
-        endpoint.logger.Printf("Setting target %+v", targetRequest)
-        room.Fog().SetTarget(targetRequest.FogTarget)
+        endpoint.logger.InfoContext(request.Context(),
+                                    "Picked random fog target",
+                                    "target", target,
+                                    "seed", seed)
+        response.Target = target
+        response.Seed = seed
         return nil
     })
 
-    return nil, err
+    return response, err
 }
 
 func (endpoint fogEndpoint) getTarget(request *http.Request) (interface{}, error) {
-    if request.Method != http.MethodGet {
-        return nil, fmt.Errorf("getTarget is a GET endpoint")
-    }
-
-    roomId, err := api.RoomIdFromRequest(request)
+    roomId, err := roomIdFromRequest(request)
 
     if err != nil {
         return nil, err
     }
 
     var targetLocation struct {
-        Target model.Circle
+        Target model.AnyShape
     }
 
     err = endpoint.rooms.WithSharedRoom(roomId, func(room *model.Room) error {
-        targetLocation.Target = room.Fog().Target()
+        targetLocation.Target = model.AnyShape{Shape: room.Fog().Target()}
         return nil
     })
 
     return targetLocation, err
 }
 
+type advanceTimeRequest struct {
+    Amount float32
+    RoomId model.Identifier `json:",string"`
+}
+
 func (endpoint fogEndpoint) advanceTime(request *http.Request) (interface{}, error) {
-    if request.Method != http.MethodPost {
-        return nil, fmt.Errorf("advanceTime is a POST endpoint")
+    var advanceRequest advanceTimeRequest
+    err := api.ParseJsonRequest(request, &advanceRequest)
+
+    if err != nil {
+        return nil, err
     }
 
-    var advanceRequest struct {
-        Amount float32
+    _, err = authorise(request, advanceRequest.RoomId, RoleGameMaster)
+
+    if err != nil {
+        return nil, err
+    }
+
+    err = endpoint.rooms.WithExclusiveRoom(advanceRequest.RoomId,
+                                           func(room *model.Room) error {
+        endpoint.logger.InfoContext(request.Context(),
+                                    "Advancing game time",
+                                    "amount", advanceRequest.Amount)
+        return room.Fog().Advance(advanceRequest.Amount)
+    })
+
+    return nil, err
+}
+
+func (endpoint fogEndpoint) setSchedule(request *http.Request) (interface{}, error) {
+    var scheduleRequest struct {
+        Stages []model.FogStage
         RoomId model.Identifier `json:",string"`
-        GameMasterId model.Identifier `json:",string"`
     }
-    err := api.ParseJsonRequest(request, &advanceRequest)
+    err := api.ParseJsonRequest(request, &scheduleRequest)
 
     if err != nil {
         return nil, err
     }
 
-    err = endpoint.rooms.WithExclusiveRoom(advanceRequest.RoomId,
+    _, err = authorise(request, scheduleRequest.RoomId, RoleGameMaster)
+
+    if err != nil {
+        return nil, err
+    }
+
+    err = endpoint.rooms.WithExclusiveRoom(scheduleRequest.RoomId,
                                            func(room *model.Room) error {
-        if advanceRequest.GameMasterId != room.GameMaster().Id() {
-            return fmt.Errorf("Unautherised access")
+        for _, stage := range scheduleRequest.Stages {
+            err := stage.Target.Validate()
+
+            if err == nil {
+                err = room.CheckShapeOnMap(stage.Target.Shape)
+            }
+
+            if err != nil {
+                return err
+            }
         }
 
-        endpoint.logger.Printf("Advancing game time for room %v by %v",
-                               advanceRequest.RoomId,
-                               advanceRequest.Amount)
-        room.Fog().Advance(advanceRequest.Amount)
+        endpoint.logger.InfoContext(request.Context(),
+                                    "Setting fog schedule",
+                                    "stages", len(scheduleRequest.Stages))
+        return room.Fog().SetSchedule(scheduleRequest.Stages)
+    })
+
+    return nil, err
+}
+
+func (endpoint fogEndpoint) getSchedule(request *http.Request) (interface{}, error) {
+    roomId, err := roomIdFromRequest(request)
+
+    if err != nil {
+        return nil, err
+    }
 
+    var schedule api.FogScheduleResponse
+
+    err = endpoint.rooms.WithSharedRoom(roomId, func(room *model.Room) error {
+        fog := room.Fog()
+        schedule.Stages = fog.Schedule()
+        schedule.StageIndex = fog.StageIndex()
+        schedule.Holding = fog.Holding()
+        schedule.TimeRemaining = fog.StageTimeRemaining()
         return nil
     })
 
+    return schedule, err
+}
+
+func (endpoint fogEndpoint) skipStage(request *http.Request) (interface{}, error) {
+    var skipRequest struct {
+        RoomId model.Identifier `json:",string"`
+    }
+    err := api.ParseJsonRequest(request, &skipRequest)
+
+    if err != nil {
+        return nil, err
+    }
+
+    _, err = authorise(request, skipRequest.RoomId, RoleGameMaster)
+
+    if err != nil {
+        return nil, err
+    }
+
+    err = endpoint.rooms.WithExclusiveRoom(skipRequest.RoomId,
+                                           func(room *model.Room) error {
+        endpoint.logger.InfoContext(request.Context(),
+                                    "Skipping fog stage",
+                                    "stage", room.Fog().StageIndex())
+        return room.Fog().SkipStage()
+    })
+
     return nil, err
 }
+
+func (endpoint fogEndpoint) rewindStage(request *http.Request) (interface{}, error) {
+    var rewindRequest struct {
+        RoomId model.Identifier `json:",string"`
+    }
+    err := api.ParseJsonRequest(request, &rewindRequest)
+
+    if err != nil {
+        return nil, err
+    }
+
+    _, err = authorise(request, rewindRequest.RoomId, RoleGameMaster)
+
+    if err != nil {
+        return nil, err
+    }
+
+    err = endpoint.rooms.WithExclusiveRoom(rewindRequest.RoomId,
+                                           func(room *model.Room) error {
+        endpoint.logger.InfoContext(request.Context(),
+                                    "Rewinding fog stage",
+                                    "stage", room.Fog().StageIndex())
+        return room.Fog().RewindStage()
+    })
+
+    return nil, err
+}
+
+func MakeFogEndpoint(rooms *RoomManager, logger *slog.Logger) *Endpoint {
+    fog := fogEndpoint{logger: logger, rooms: rooms}
+    endpoint := NewEndpoint()
+
+    endpoint.Register("/resume", http.MethodPost, fog.resume)
+    endpoint.Register("/pause", http.MethodPost, fog.pause)
+    endpoint.Register("/paused", http.MethodGet, fog.paused)
+    endpoint.Register("/setTarget", http.MethodPost, fog.setTarget)
+    endpoint.Register("/setPeriod", http.MethodPost, fog.period)
+    endpoint.Register("/location", http.MethodGet, fog.location)
+    endpoint.Register("/getTarget", http.MethodGet, fog.getTarget)
+    endpoint.Register("/advanceTime", http.MethodPost, fog.advanceTime)
+    endpoint.Register("/randomTarget", http.MethodPost, fog.randomTarget)
+    endpoint.Register("/setSchedule", http.MethodPost, fog.setSchedule)
+    endpoint.Register("/getSchedule", http.MethodGet, fog.getSchedule)
+    endpoint.Register("/skipStage", http.MethodPost, fog.skipStage)
+    endpoint.Register("/rewindStage", http.MethodPost, fog.rewindStage)
+
+    return endpoint
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "io"
+    "log/slog"
+    "net/http"
+    "strings"
+
+    "github.com/dox5/dnd_royal_server/api"
+    "github.com/dox5/dnd_royal_server/model"
+)
+
+var logFormats = []string{"text", "json"}
+
+// Attribute keys whose values are never written out, whatever the case.
+// Knowing the game master's id or a session token is enough to act as them.
+var secretLogKeys = map[string]bool{"gamemasterid": true,
+                                    "token": true,
+                                    "authorization": true,
+                                    "cookie": true,
+                                    "password": true,
+                                    "key": true}
+
+const redacted = "[redacted]"
+
+func parseLogLevel(level string) (slog.Level, error) {
+    var parsed slog.Level
+    err := parsed.UnmarshalText([]byte(level))
+
+    if err != nil {
+        return parsed, fmt.Errorf("Unknown log level %q", level)
+    }
+
+    return parsed, nil
+}
+
+func redactSecrets(groups []string, attr slog.Attr) slog.Attr {
+    if secretLogKeys[strings.ToLower(attr.Key)] {
+        attr.Value = slog.StringValue(redacted)
+    }
+    return attr
+}
+
+func roomAttr(roomId model.Identifier) slog.Attr {
+    return slog.String("room", fmt.Sprint(roomId))
+}
+
+// What is known about the request being handled, filled in as it goes
+type logFields struct {
+    requestId string
+    roomId *model.Identifier
+}
+
+type logFieldsKey struct{}
+
+func withLogFields(ctx context.Context, requestId string) context.Context {
+    return context.WithValue(ctx,
+                             logFieldsKey{},
+                             &logFields{requestId: requestId})
+}
+
+// Attach the room to every later log line for the request, including the
+// access log
+func logRoom(request *http.Request, roomId model.Identifier) {
+    fields, found := request.Context().Value(logFieldsKey{}).(*logFields)
+
+    if found {
+        fields.roomId = &roomId
+    }
+}
+
+// Adds the request and room ids from the context to each record
+type contextHandler struct {
+    slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, record slog.Record) error {
+    fields, found := ctx.Value(logFieldsKey{}).(*logFields)
+
+    if found {
+        record.AddAttrs(slog.String("request_id", fields.requestId))
+
+        if fields.roomId != nil {
+            record.AddAttrs(roomAttr(*fields.roomId))
+        }
+    }
+
+    return h.Handler.Handle(ctx, record)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+    return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+    return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// A logger writing key=value ("text") or JSON lines at level and above
+func NewLogger(out io.Writer, level string, format string) (*slog.Logger, error) {
+    parsedLevel, err := parseLogLevel(level)
+
+    if err != nil {
+        return nil, err
+    }
+
+    options := &slog.HandlerOptions{Level: parsedLevel,
+                                    ReplaceAttr: redactSecrets}
+
+    var handler slog.Handler
+
+    switch format {
+    case "text":
+        handler = slog.NewTextHandler(out, options)
+    case "json":
+        handler = slog.NewJSONHandler(out, options)
+    default:
+        return nil, fmt.Errorf("Unknown log format %q", format)
+    }
+
+    return slog.New(contextHandler{handler}), nil
+}
+
+func discardLogger() *slog.Logger {
+    return slog.New(slog.DiscardHandler)
+}
+
+// api.RoomIdFromRequest that also attaches the room to the request's logs
+func roomIdFromRequest(request *http.Request) (model.Identifier, error) {
+    roomId, err := api.RoomIdFromRequest(request)
+
+    if err == nil {
+        logRoom(request, roomId)
+    }
+
+    return roomId, err
+}
@@ -0,0 +1,137 @@
+package main_test
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+
+    "github.com/dox5/dnd_royal_server/dndbrserver"
+    "github.com/dox5/dnd_royal_server/model"
+)
+
+func logLines(t *testing.T, out *bytes.Buffer) []map[string]interface{} {
+    var lines []map[string]interface{}
+
+    for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+        if line == "" {
+            continue
+        }
+
+        var parsed map[string]interface{}
+        err := json.Unmarshal([]byte(line), &parsed)
+
+        if err != nil {
+            t.Fatalf("Expected JSON log line but got %q: %s", line, err)
+        }
+
+        lines = append(lines, parsed)
+    }
+
+    return lines
+}
+
+func TestAccessLogShouldIncludeRequestAndRoom(t *testing.T) {
+    var out bytes.Buffer
+    logger, err := main.NewLogger(&out, "info", "json")
+
+    if err != nil {
+        t.Fatalf("Failed to create logger: %s", err)
+    }
+
+    rooms := main.NewRoomManager()
+    defer rooms.Shutdown()
+
+    room, err := rooms.Create(model.DefaultRoomConfig())
+
+    if err != nil {
+        t.Fatalf("Failed to create room: %s", err)
+    }
+
+    auth, err := main.NewRandomAuthenticator(main.DefaultSessionLifetime)
+
+    if err != nil {
+        t.Fatalf("Failed to create authenticator: %s", err)
+    }
+
+    handler := main.Chain(main.MakeRoomEndpoint(rooms, logger, auth, nil),
+                          main.TagRequests,
+                          main.LogRequests(logger))
+
+    request := httptest.NewRequest(http.MethodGet,
+                                   fmt.Sprintf("/players?RoomId=%d", room.Id()),
+                                   nil)
+    request.Header.Set(main.RequestIdHeader, "abc-123")
+    handler.ServeHTTP(httptest.NewRecorder(), request)
+
+    lines := logLines(t, &out)
+
+    if len(lines) != 1 {
+        t.Fatalf("Expected one access log line but got %d", len(lines))
+    }
+
+    expected := map[string]interface{}{"msg": "Handled request",
+                                       "level": "INFO",
+                                       "method": "GET",
+                                       "path": "/players",
+                                       "status": float64(200),
+                                       "request_id": "abc-123",
+                                       "room": fmt.Sprint(room.Id())}
+
+    for key, value := range expected {
+        if lines[0][key] != value {
+            t.Errorf("Expected %s to be %v but got %v",
+                     key,
+                     value,
+                     lines[0][key])
+        }
+    }
+}
+
+func TestLoggerShouldRedactSecrets(t *testing.T) {
+    var out bytes.Buffer
+    logger, err := main.NewLogger(&out, "info", "json")
+
+    if err != nil {
+        t.Fatalf("Failed to create logger: %s", err)
+    }
+
+    logger.Info("Created room", "GameMasterId", 1234, "token", "abc.def")
+
+    line := logLines(t, &out)[0]
+
+    for _, key := range []string{"GameMasterId", "token"} {
+        if line[key] != "[redacted]" {
+            t.Errorf("Expected %s to be redacted but got %v", key, line[key])
+        }
+    }
+}
+
+func TestLoggerShouldFilterByLevel(t *testing.T) {
+    var out bytes.Buffer
+    logger, err := main.NewLogger(&out, "warn", "text")
+
+    if err != nil {
+        t.Fatalf("Failed to create logger: %s", err)
+    }
+
+    logger.Info("Quiet")
+    logger.Warn("Loud", "count", 2)
+
+    if strings.Contains(out.String(), "Quiet") {
+        t.Errorf("Expected info to be dropped but got %q", out.String())
+    }
+
+    if !strings.Contains(out.String(), "msg=Loud count=2") {
+        t.Errorf("Expected key=value warning but got %q", out.String())
+    }
+
+    _, err = main.NewLogger(&out, "info", "xml")
+
+    if err == nil {
+        t.Error("Expected unknown log format to be rejected")
+    }
+}
@@ -1,145 +1,184 @@
 package main
 
 import (
-  "encoding/json"
+  "context"
+  "flag"
   "fmt"
-  "log"
+  "log/slog"
   "net/http"
   "os"
-  "strconv"
-
-  "github.com/dox5/dnd_royal_server/api"
-  "github.com/dox5/dnd_royal_server/model"
+  "os/signal"
+  "path/filepath"
+  "syscall"
+  "time"
 )
 
-type FormValueGetter interface {
-    FormValue(key string) string
+func createAPIHandler(logger *slog.Logger,
+                      rooms *RoomManager,
+                      maps *MapStore,
+                      auth *Authenticator) http.Handler {
+    router := NewEndpoint()
+    router.Use(TagRequests,
+               MeasureRequests(rooms.Metrics()),
+               LogRequests(logger),
+               RecoverPanics(logger),
+               auth.Middleware)
+
+    router.Mount("/api/v1/room", MakeRoomEndpoint(rooms, logger, auth, maps))
+    router.Mount("/api/v1/fog", MakeFogEndpoint(rooms, logger))
+    router.Mount("/api/v1/token", MakePlayerTokenEndpoint(rooms, logger))
+    router.Register("/api/v1/time", http.MethodGet, ServerTime(rooms.Clock()))
+
+    return router
 }
 
-type APIRequestHandler func(FormValueGetter) (interface{}, error)
-
-type RegisterEndpoint func(method string,
-                           uri string,
-                           callback APIRequestHandler)
-
-func handleApiRequest(handleRequest APIRequestHandler,
-                      writer http.ResponseWriter,
-                      request *http.Request,
-                      method string) {
+func main() {
+    config, err := LoadConfigFromCommandLine()
 
-    if request.Method != method {
-        msg := fmt.Sprintf("Incorrect method for endpoint. Expected %s but got %v",
-                           method,
-                           request.Method)
-        http.Error(writer, msg, http.StatusMethodNotAllowed)
+    if err == flag.ErrHelp {
         return
     }
 
-    response, err := handleRequest(request)
-
     if err != nil {
-        msg := fmt.Sprintf("Request Handling failed: %s", err)
-        http.Error(writer, msg, http.StatusInternalServerError)
-        return
+        fmt.Fprintf(os.Stderr, "Invalid config: %s\n", err)
+        os.Exit(2)
     }
 
-    if response != nil {
-        formattedResponse, err := json.Marshal(response)
+    logger, err := NewLogger(os.Stdout, config.LogLevel, config.LogFormat)
 
-        if err != nil {
-            msg := fmt.Sprintf("Failed to format JSON response: %s", err)
-            http.Error(writer, msg, http.StatusInternalServerError)
-            return
-        }
-
-        writer.Header().Add("Content-Type", "application/json")
-        writer.Write(formattedResponse)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Failed to set up logging: %s\n", err)
+        os.Exit(2)
     }
-}
 
-func mkHandlerFunc(method string, handler APIRequestHandler) http.HandlerFunc {
-    return func(writer http.ResponseWriter, request *http.Request) {
-        handleApiRequest(handler, writer, request, method)
+    fatal := func(message string, err error) {
+        logger.Error(message, "error", err)
+        os.Exit(1)
     }
-}
 
-func roomIdentifierFromForm(parameters FormValueGetter) (model.Identifier, error) {
-    roomIdString := parameters.FormValue("RoomId")
+    logger.Info("~~ Starting DND Battle Royal Server ~~")
+    config.Print(logger)
+    mux := http.NewServeMux()
 
-    if len(roomIdString) == 0 {
-      return 0, fmt.Errorf("Must provide RoomId URL paramter")
-    }
+    var store RoomStore
 
-    roomId, err := strconv.ParseUint(roomIdString, 10, 64)
+    if config.RoomStore != "" {
+        store, err = NewFileRoomStore(config.RoomStore, logger)
 
-    if err != nil {
-      return 0, fmt.Errorf("Room ID must be uint64: %s", err)
+        if err != nil {
+            fatal("Failed to open room store", err)
+        }
     }
 
-    return model.Identifier(roomId), nil
-}
-
-func createUserAPIHandler(logger *log.Logger, rooms *RoomManager) http.Handler {
-    apiMux := http.NewServeMux()
-
-    endponts := []struct {method string;
-                          url string;
-                          callback APIRequestHandler} {
+    rooms, err := NewConfiguredRoomManager(store, logger, config.RoomSettings())
 
-        {http.MethodPost,
-         "/create",
-         func(FormValueGetter) (interface{}, error) {
-             room := rooms.Create()
-             response := api.RoomCreateResponse{
-                 RoomId: room.Id(),
-                 GameMasterId: room.GameMaster().Id() }
+    if err != nil {
+        fatal("Failed to restore rooms", err)
+    }
 
-             logger.Printf("Created room: %+v\n", response)
+    if store != nil {
+        logger.Info("Persisting rooms",
+                    "directory", config.RoomStore,
+                    "restored", rooms.Count())
+    }
 
-             return response, nil
-        }},
+    if idleTimeout := time.Duration(config.RoomIdleTimeout); idleTimeout > 0 {
+        rooms.StartReaper(idleTimeout, idleTimeout / 10)
+    }
 
-        {http.MethodGet,
-         "/map",
-         func(parameters FormValueGetter) (interface{}, error) {
-            _, err := roomIdentifierFromForm(parameters)
+    maps, err := NewMapStore(config.MapDir)
 
-            if err != nil {
-                return nil, err
-            }
+    if err != nil {
+        fatal("Failed to open map store", err)
+    }
 
-            return nil, fmt.Errorf("Not implemented")
-        }}}
+    var auth *Authenticator
+    sessionLifetime := time.Duration(config.SessionLifetime)
+
+    // Restored rooms are no use without sessions that still work, so keep a
+    // key alongside them
+    switch {
+    case config.AuthKeyFile != "":
+        auth, err = NewAuthenticatorFromFile(config.AuthKeyFile, sessionLifetime)
+    case config.RoomStore != "":
+        auth, err = NewPersistentAuthenticator(
+            filepath.Join(config.RoomStore, StoredAuthKeyFile),
+            sessionLifetime)
+    default:
+        logger.Warn("Rooms aren't persisted, sessions will not survive " +
+                    "a restart either")
+        auth, err = NewRandomAuthenticator(sessionLifetime)
+    }
 
-    for _, endpoint := range endponts {
-        apiMux.HandleFunc(endpoint.url,
-                          mkHandlerFunc(endpoint.method, endpoint.callback))
+    if err != nil {
+        fatal("Failed to set up authentication", err)
     }
 
-    return apiMux
-}
+    mux.Handle("/api/", createAPIHandler(logger, rooms, maps, auth))
+    mux.Handle("/metrics", rooms.Metrics())
+    mux.Handle("/", http.FileServer(http.Dir(config.StaticDir)))
 
-func main() {
-    logger := log.New(os.Stdout, "", log.LUTC | log.Ldate | log.Ltime)
-    logger.Println("~~ Starting DND Battle Royal Server ~~")
-    mux := http.NewServeMux()
+    server, certs, err := NewServer(config, mux, logger)
 
-    rooms := NewRoomManager()
-    fogController := NewFogEndpoint(logger, rooms)
+    if err != nil {
+        fatal("Failed to set up TLS", err)
+    }
 
+    // Event streams never finish by themselves
+    server.RegisterOnShutdown(rooms.CloseSubscriptions)
 
+    serverDone := make(chan error, 1)
+    go func() {
+        if config.TLSEnabled() {
+            serverDone <- server.ListenAndServeTLS("", "")
+        } else {
+            serverDone <- server.ListenAndServe()
+        }
+    }()
+
+    signals := make(chan os.Signal, 1)
+    signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+    // Supervisors need to see that the server failed rather than stopped
+    exitCode := 0
+
+    select {
+    case err := <- serverDone:
+        logger.Error("Server stopped", "error", err)
+        exitCode = 1
+    case sig := <- signals:
+        logger.Info("Shutting down", "signal", sig.String())
+
+        ctx, cancel := context.WithTimeout(context.Background(),
+                                           time.Duration(config.ShutdownTimeout))
+        defer cancel()
+
+        // Don't make anyone wait out the timeout if they really want us gone
+        go func() {
+            select {
+            case sig := <- signals:
+                logger.Warn("Signalled again, not waiting for requests",
+                            "signal", sig.String())
+                cancel()
+            case <- ctx.Done():
+            }
+        }()
 
-    mux.Handle("/api/v1/room/",
-               http.StripPrefix("/api/v1/room",
-                                createUserAPIHandler(logger, rooms)))
+        // Stops accepting connections then waits for requests to finish
+        err := server.Shutdown(ctx)
 
-    mux.Handle("/api/v1/fog/", http.StripPrefix("/api/v1/fog", fogController))
+        if err != nil {
+            logger.Error("Failed to drain connections", "error", err)
+        }
+    }
 
-    mux.Handle("/api/v1/token/",
-               http.StripPrefix("/api/v1/token",
-                                MakePlayerTokenEndpoint(rooms, logger)))
+    rooms.Shutdown()
+    logger.Info("~~ DND Battle Royal Server stopped ~~")
 
-    mux.Handle("/", http.FileServer(http.Dir("/web_static")))
+    // Exiting skips deferred calls, so stop watching the certificates here
+    if certs != nil {
+        certs.Stop()
+    }
 
-    http.ListenAndServe(":8000", mux)
+    os.Exit(exitCode)
 }
@@ -0,0 +1,125 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "io/ioutil"
+    "log/slog"
+    "net/http"
+
+    "github.com/dox5/dnd_royal_server/api"
+    "github.com/dox5/dnd_royal_server/model"
+)
+
+// The body is the raw PNG or JPEG image, RoomId is passed as a URL parameter
+func uploadMapHandler(logger *slog.Logger,
+                      rooms *RoomManager,
+                      maps *MapStore) http.HandlerFunc {
+    return func(writer http.ResponseWriter, request *http.Request) {
+        roomId, err := roomIdFromRequest(request)
+
+        if err != nil {
+            api.FormatResponse(writer, nil, err)
+            return
+        }
+
+        // Don't bother storing anything for someone who can't use it
+        _, err = authorise(request, roomId, RoleGameMaster)
+
+        if err != nil {
+            api.FormatResponse(writer, nil, err)
+            return
+        }
+
+        data, err := ioutil.ReadAll(request.Body)
+
+        var tooLarge *http.MaxBytesError
+        if errors.As(err, &tooLarge) {
+            err = api.TooLarge("Map must be at most %d bytes", tooLarge.Limit)
+        } else if err != nil {
+            err = api.BadRequest("Failed to read map: %s", err)
+        }
+
+        if err != nil {
+            api.FormatResponse(writer, nil, err)
+            return
+        }
+
+        stored, err := maps.Save(data)
+
+        if err != nil {
+            api.FormatResponse(writer, nil, err)
+            return
+        }
+
+        err = rooms.WithExclusiveRoom(roomId, func(room *model.Room) error {
+            return room.SetMap(stored.Asset, stored.Size)
+        })
+
+        if err == nil {
+            logger.InfoContext(request.Context(),
+                               "Set room map",
+                               "asset", stored.Asset,
+                               "width", stored.Size.Width,
+                               "height", stored.Size.Height)
+        }
+
+        response := api.MapResponse{Asset: stored.Asset,
+                                    Width: stored.Size.Width,
+                                    Height: stored.Size.Height}
+        api.FormatResponse(writer, response, err)
+    }
+}
+
+func serveMapHandler(rooms *RoomManager, maps *MapStore) http.HandlerFunc {
+    return func(writer http.ResponseWriter, request *http.Request) {
+        roomId, err := roomIdFromRequest(request)
+
+        if err != nil {
+            api.FormatResponse(writer, nil, err)
+            return
+        }
+
+        var asset string
+        err = rooms.WithSharedRoom(roomId, func(room *model.Room) error {
+            asset = room.MapAsset()
+            return nil
+        })
+
+        if err != nil {
+            api.FormatResponse(writer, nil, err)
+            return
+        }
+
+        if asset == "" {
+            api.FormatResponse(writer,
+                               nil,
+                               api.NotFound("No map uploaded for room"))
+            return
+        }
+
+        file, contentType, err := maps.Open(asset)
+
+        if err != nil {
+            api.FormatResponse(writer, nil, err)
+            return
+        }
+        defer file.Close()
+
+        info, err := file.Stat()
+
+        if err != nil {
+            api.FormatResponse(writer, nil, err)
+            return
+        }
+
+        // The URL stays the same when a new map is uploaded, so clients have
+        // to check back but get a 304 while the asset is unchanged
+        header := writer.Header()
+        header.Set("Content-Type", contentType)
+        header.Set("Cache-Control", "no-cache")
+        header.Set("ETag", fmt.Sprintf("%q", asset))
+
+        http.ServeContent(writer, request, asset, info.ModTime(), file)
+    }
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+    "bytes"
+    "crypto/sha256"
+    "encoding/hex"
+    "fmt"
+    "image"
+    _ "image/jpeg"
+    _ "image/png"
+    "net/http"
+    "os"
+    "path/filepath"
+    "regexp"
+
+    "github.com/dox5/dnd_royal_server/api"
+    "github.com/dox5/dnd_royal_server/model"
+)
+
+const (
+    MaxMapBytes = 20 << 20
+    // Keep the decoded image to a sensible size as well
+    MaxMapDimension = 16384
+)
+
+// Extension for each content type we accept
+var mapTypes = map[string]string{
+    "image/png": ".png",
+    "image/jpeg": ".jpg",
+}
+
+var mapAssetPattern = regexp.MustCompile(`^[0-9a-f]{64}\.(png|jpg)$`)
+
+// Map images on disk. Assets are named after a hash of their contents so the
+// same image is only stored once, and the asset name makes a good ETag.
+type MapStore struct {
+    directory string
+}
+
+type StoredMap struct {
+    Asset string
+    ContentType string
+    Size model.MapSize
+}
+
+func NewMapStore(directory string) (*MapStore, error) {
+    err := os.MkdirAll(directory, 0755)
+
+    if err != nil {
+        return nil, fmt.Errorf("Failed to create map directory: %s", err)
+    }
+
+    return &MapStore{directory: directory}, nil
+}
+
+// Check the image is a PNG or JPEG of a sensible size and store it
+func (store *MapStore) Save(data []byte) (StoredMap, error) {
+    if len(data) > MaxMapBytes {
+        return StoredMap{}, api.TooLarge("Map must be at most %d bytes",
+                                         MaxMapBytes)
+    }
+
+    contentType := http.DetectContentType(data)
+    extension, supported := mapTypes[contentType]
+
+    if !supported {
+        return StoredMap{}, api.BadRequest("Map must be a PNG or JPEG image, got %s",
+                                           contentType)
+    }
+
+    config, _, err := image.DecodeConfig(bytes.NewReader(data))
+
+    if err != nil {
+        return StoredMap{}, api.BadRequest("Map is not a valid image: %s", err)
+    }
+
+    if config.Width > MaxMapDimension || config.Height > MaxMapDimension {
+        return StoredMap{}, api.TooLarge("Map must be at most %dx%d pixels, got %dx%d",
+                                         MaxMapDimension,
+                                         MaxMapDimension,
+                                         config.Width,
+                                         config.Height)
+    }
+
+    hash := sha256.Sum256(data)
+    asset := hex.EncodeToString(hash[:]) + extension
+
+    stored := StoredMap{Asset: asset,
+                        ContentType: contentType,
+                        Size: model.MapSize{Width: config.Width,
+                                            Height: config.Height}}
+
+    path := filepath.Join(store.directory, asset)
+
+    if _, err := os.Stat(path); err == nil {
+        // Same image already uploaded
+        return stored, nil
+    }
+
+    err = writeFileAtomic(path, data)
+
+    if err != nil {
+        return StoredMap{}, fmt.Errorf("Failed to save map: %s", err)
+    }
+
+    return stored, nil
+}
+
+// Open a stored map, the caller must close it
+func (store *MapStore) Open(asset string) (*os.File, string, error) {
+    if !mapAssetPattern.MatchString(asset) {
+        return nil, "", api.NotFound("No map found called %q", asset)
+    }
+
+    contentType := "image/png"
+    if filepath.Ext(asset) == ".jpg" {
+        contentType = "image/jpeg"
+    }
+
+    file, err := os.Open(filepath.Join(store.directory, asset))
+
+    if err != nil {
+        return nil, "", api.NotFound("No map found called %q", asset)
+    }
+
+    return file, contentType, nil
+}
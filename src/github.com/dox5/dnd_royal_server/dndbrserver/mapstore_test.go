@@ -0,0 +1,128 @@
+package main_test
+
+import (
+  "bytes"
+  "image"
+  "image/png"
+  "net/http"
+  "testing"
+
+  "github.com/dox5/dnd_royal_server/api"
+  "github.com/dox5/dnd_royal_server/dndbrserver"
+)
+
+func encodePng(t *testing.T, width int, height int) []byte {
+    t.Helper()
+
+    var buf bytes.Buffer
+    err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, width, height)))
+
+    if err != nil {
+        t.Fatalf("Failed to encode test image: %s", err)
+    }
+
+    return buf.Bytes()
+}
+
+func TestSavedMapShouldRecordSize(t *testing.T) {
+    maps, err := main.NewMapStore(t.TempDir())
+
+    if err != nil {
+        t.Fatalf("Failed to create map store: %s", err)
+    }
+
+    stored, err := maps.Save(encodePng(t, 64, 32))
+
+    if err != nil {
+        t.Fatalf("Failed to save map: %s", err)
+    }
+
+    if stored.Size.Width != 64 || stored.Size.Height != 32 {
+        t.Errorf("Expected map to be 64x32 but it was %+v", stored.Size)
+    }
+
+    if stored.ContentType != "image/png" {
+        t.Errorf("Expected content type image/png but it was %s",
+                 stored.ContentType)
+    }
+
+    file, contentType, err := maps.Open(stored.Asset)
+
+    if err != nil {
+        t.Fatalf("Failed to open saved map: %s", err)
+    }
+    file.Close()
+
+    if contentType != "image/png" {
+        t.Errorf("Expected content type image/png but it was %s", contentType)
+    }
+}
+
+func TestSameMapShouldGiveSameAsset(t *testing.T) {
+    maps, err := main.NewMapStore(t.TempDir())
+
+    if err != nil {
+        t.Fatalf("Failed to create map store: %s", err)
+    }
+
+    data := encodePng(t, 8, 8)
+    first, _ := maps.Save(data)
+    second, err := maps.Save(data)
+
+    if err != nil {
+        t.Fatalf("Failed to save map again: %s", err)
+    }
+
+    if first.Asset != second.Asset {
+        t.Errorf("Expected the same asset but got %s and %s",
+                 first.Asset,
+                 second.Asset)
+    }
+}
+
+func TestNonImageMapShouldBeRejected(t *testing.T) {
+    maps, err := main.NewMapStore(t.TempDir())
+
+    if err != nil {
+        t.Fatalf("Failed to create map store: %s", err)
+    }
+
+    if _, err := maps.Save([]byte("<svg>not allowed</svg>")); err == nil {
+        t.Error("Expected a non PNG/JPEG map to be rejected")
+    }
+
+    // Looks like a PNG but isn't one
+    broken := encodePng(t, 8, 8)[:20]
+    if _, err := maps.Save(broken); err == nil {
+        t.Error("Expected a truncated image to be rejected")
+    }
+}
+
+func TestOversizedMapShouldBeTooLarge(t *testing.T) {
+    maps, err := main.NewMapStore(t.TempDir())
+
+    if err != nil {
+        t.Fatalf("Failed to create map store: %s", err)
+    }
+
+    _, err = maps.Save(encodePng(t, main.MaxMapDimension + 1, 1))
+
+    if api.ErrorOf(err).Status != http.StatusRequestEntityTooLarge {
+        t.Errorf("Expected a map that is too wide to be too large but got %v",
+                 err)
+    }
+}
+
+func TestOpenShouldOnlyServeStoredAssets(t *testing.T) {
+    maps, err := main.NewMapStore(t.TempDir())
+
+    if err != nil {
+        t.Fatalf("Failed to create map store: %s", err)
+    }
+
+    for _, asset := range []string{"../../etc/passwd", "CoolJenniMap", ""} {
+        if _, _, err := maps.Open(asset); err == nil {
+            t.Errorf("Expected opening %q to fail", asset)
+        }
+    }
+}
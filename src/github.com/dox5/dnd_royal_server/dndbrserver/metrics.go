@@ -0,0 +1,146 @@
+package main
+
+import (
+    "context"
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/dox5/dnd_royal_server/metrics"
+)
+
+// Metrics kept by the RoomManager, see RoomManager.Metrics
+type roomMetrics struct {
+    created *metrics.Counter
+    // By why the room went, "request" or "idle"
+    deleted *metrics.CounterVec
+    ticks *metrics.Counter
+    tickLag *metrics.Histogram
+    // By who was waiting, "advancer", "exclusive", "shared" or "persister"
+    lockWait *metrics.HistogramVec
+}
+
+func newRoomMetrics(registry *metrics.Registry, rm *RoomManager) roomMetrics {
+    registry.NewGaugeFunc("dndbr_rooms",
+                          "Rooms that exist, by whether the fog is moving",
+                          []string{"state"},
+                          func(emit func(float64, ...string)) {
+        active, paused := 0, 0
+
+        rm.forEachRoom(func(room *activeRoom) {
+            if room.room.Fog().Paused() {
+                paused += 1
+            } else {
+                active += 1
+            }
+        })
+
+        emit(float64(active), "active")
+        emit(float64(paused), "paused")
+    })
+
+    // Only totals, room ids are all anyone needs to get into a room so they
+    // mustn't end up in labels
+    registry.NewGaugeFunc("dndbr_player_tokens",
+                          "Player tokens across all rooms",
+                          nil,
+                          func(emit func(float64, ...string)) {
+        tokens := 0
+
+        rm.forEachRoom(func(room *activeRoom) {
+            tokens += len(room.room.GetPlayerTokens())
+        })
+
+        emit(float64(tokens))
+    })
+
+    registry.NewGaugeFunc("dndbr_scheduled_rooms",
+                          "Rooms the scheduler is updating",
+                          nil,
+                          func(emit func(float64, ...string)) {
+        emit(float64(rm.scheduler.count()))
+    })
+
+    return roomMetrics{
+        created: registry.NewCounter("dndbr_rooms_created_total",
+                                     "Rooms created").With(),
+        deleted: registry.NewCounter("dndbr_rooms_deleted_total",
+                                     "Rooms deleted, by reason",
+                                     "reason"),
+        ticks: registry.NewCounter("dndbr_advancer_ticks_total",
+                                   "Room updates run by the scheduler").With(),
+        tickLag: registry.NewHistogram("dndbr_advancer_tick_lag_seconds",
+                                       "How late room updates run",
+                                       nil).With(),
+        lockWait: registry.NewHistogram("dndbr_room_lock_wait_seconds",
+                                        "Time spent waiting for a room lock",
+                                        nil,
+                                        "holder")}
+}
+
+// Set by the router once it knows which route a request is for
+type matchedRoute struct {
+    pattern string
+}
+
+type matchedRouteKey struct{}
+
+func setMatchedRoute(request *http.Request, pattern []string) {
+    matched, found := request.Context().Value(matchedRouteKey{}).(*matchedRoute)
+
+    if found {
+        matched.pattern = "/" + strings.Join(pattern, "/")
+    }
+}
+
+// Anyone can send any method, don't let them make up new metrics
+func methodLabel(method string) string {
+    switch method {
+    case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut,
+         http.MethodPatch, http.MethodDelete, http.MethodOptions:
+        return method
+    }
+
+    return "other"
+}
+
+// Count and time requests by route pattern rather than path, so room ids
+// don't each get their own metric
+func MeasureRequests(registry *metrics.Registry) Middleware {
+    requests := registry.NewCounter("dndbr_http_requests_total",
+                                    "HTTP requests handled",
+                                    "route",
+                                    "method",
+                                    "status")
+    durations := registry.NewHistogram("dndbr_http_request_duration_seconds",
+                                       "Time taken to handle HTTP requests",
+                                       nil,
+                                       "route",
+                                       "method",
+                                       "status")
+
+    return func(next http.Handler) http.Handler {
+        return http.HandlerFunc(func(writer http.ResponseWriter,
+                                     request *http.Request) {
+            start := time.Now()
+            recorder := &responseRecorder{ResponseWriter: writer}
+            matched := &matchedRoute{pattern: "unmatched"}
+            ctx := context.WithValue(request.Context(),
+                                     matchedRouteKey{},
+                                     matched)
+
+            next.ServeHTTP(recorder, request.WithContext(ctx))
+
+            if recorder.status == 0 {
+                recorder.status = http.StatusOK
+            }
+
+            labels := []string{matched.pattern,
+                               methodLabel(request.Method),
+                               strconv.Itoa(recorder.status)}
+            requests.With(labels...).Inc()
+            durations.With(labels...).Observe(time.Since(start).Seconds())
+        })
+    }
+}
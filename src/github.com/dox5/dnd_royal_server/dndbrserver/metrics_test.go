@@ -0,0 +1,111 @@
+package main_test
+
+import (
+    "fmt"
+    "io/ioutil"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+
+    "github.com/dox5/dnd_royal_server/dndbrserver"
+    "github.com/dox5/dnd_royal_server/model"
+)
+
+func scrapeMetrics(t *testing.T, rooms *main.RoomManager) string {
+    server := httptest.NewServer(rooms.Metrics())
+    defer server.Close()
+
+    response, err := http.Get(server.URL)
+
+    if err != nil {
+        t.Fatalf("Failed to scrape metrics: %s", err)
+    }
+    defer response.Body.Close()
+
+    body, err := ioutil.ReadAll(response.Body)
+
+    if err != nil {
+        t.Fatalf("Failed to read metrics: %s", err)
+    }
+
+    return string(body)
+}
+
+func expectMetric(t *testing.T, scraped string, line string) {
+    t.Helper()
+
+    if !strings.Contains(scraped, line + "\n") {
+        t.Errorf("Expected metric %q in:\n%s", line, scraped)
+    }
+}
+
+func TestRoomMetricsShouldTrackRooms(t *testing.T) {
+    rooms := main.NewRoomManager()
+    defer rooms.Shutdown()
+
+    kept, err := rooms.Create(model.DefaultRoomConfig())
+
+    if err != nil {
+        t.Fatalf("Failed to create room: %s", err)
+    }
+
+    deleted, err := rooms.Create(model.DefaultRoomConfig())
+
+    if err != nil {
+        t.Fatalf("Failed to create room: %s", err)
+    }
+
+    err = rooms.Delete(deleted.Id())
+
+    if err != nil {
+        t.Fatalf("Failed to delete room: %s", err)
+    }
+
+    scraped := scrapeMetrics(t, rooms)
+    expectMetric(t, scraped, "dndbr_rooms_created_total 2")
+    expectMetric(t, scraped, `dndbr_rooms_deleted_total{reason="request"} 1`)
+    expectMetric(t, scraped, `dndbr_rooms{state="paused"} 1`)
+    expectMetric(t, scraped, `dndbr_rooms{state="active"} 0`)
+    expectMetric(t,
+                 scraped,
+                 fmt.Sprintf("dndbr_player_tokens %d",
+                             len(kept.GetPlayerTokens())))
+
+    if strings.Contains(scraped, fmt.Sprint(kept.Id())) {
+        t.Errorf("Expected room ids to be kept out of metrics:\n%s", scraped)
+    }
+}
+
+func TestRequestMetricsShouldUseRoutePatterns(t *testing.T) {
+    rooms := main.NewRoomManager()
+    defer rooms.Shutdown()
+
+    router := main.NewEndpoint()
+    router.Use(main.MeasureRequests(rooms.Metrics()))
+    router.Handle("/room/{RoomId}",
+                  http.MethodGet,
+                  http.HandlerFunc(func(writer http.ResponseWriter,
+                                        request *http.Request) {
+                      writer.WriteHeader(http.StatusTeapot)
+                  }))
+
+    for _, path := range []string{"/room/1", "/room/2", "/nowhere"} {
+        router.ServeHTTP(httptest.NewRecorder(),
+                         httptest.NewRequest(http.MethodGet, path, nil))
+    }
+
+    scraped := scrapeMetrics(t, rooms)
+    expectMetric(t,
+                 scraped,
+                 `dndbr_http_requests_total{route="/room/{RoomId}",` +
+                 `method="GET",status="418"} 2`)
+    expectMetric(t,
+                 scraped,
+                 `dndbr_http_requests_total{route="unmatched",` +
+                 `method="GET",status="404"} 1`)
+    expectMetric(t,
+                 scraped,
+                 `dndbr_http_request_duration_seconds_count{` +
+                 `route="/room/{RoomId}",method="GET",status="418"} 2`)
+}
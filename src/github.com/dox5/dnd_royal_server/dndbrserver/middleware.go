@@ -0,0 +1,151 @@
+package main
+
+import (
+    "context"
+    "crypto/rand"
+    "encoding/hex"
+    "fmt"
+    "log/slog"
+    "net/http"
+    "regexp"
+    "runtime/debug"
+    "time"
+
+    "github.com/dox5/dnd_royal_server/api"
+)
+
+const RequestIdHeader = "X-Request-Id"
+
+// Ids passed in by a proxy are only kept if they look harmless to log
+var requestIdPattern = regexp.MustCompile("^[A-Za-z0-9._-]{1,64}$")
+
+type requestIdKey struct{}
+
+func RequestIdFrom(ctx context.Context) string {
+    id, _ := ctx.Value(requestIdKey{}).(string)
+    return id
+}
+
+func newRequestId() string {
+    id := make([]byte, 8)
+    rand.Read(id)
+    return hex.EncodeToString(id)
+}
+
+// Give every request an id, echoed back in the response so problems can be
+// matched up with the logs
+func TagRequests(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(writer http.ResponseWriter,
+                                 request *http.Request) {
+        id := request.Header.Get(RequestIdHeader)
+
+        if !requestIdPattern.MatchString(id) {
+            id = newRequestId()
+        }
+
+        writer.Header().Set(RequestIdHeader, id)
+        ctx := context.WithValue(request.Context(), requestIdKey{}, id)
+        ctx = withLogFields(ctx, id)
+        next.ServeHTTP(writer, request.WithContext(ctx))
+    })
+}
+
+// Keeps hold of what was sent so it can be logged
+type responseRecorder struct {
+    http.ResponseWriter
+    status int
+    bytes int
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+    if r.status == 0 {
+        r.status = status
+    }
+    r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(data []byte) (int, error) {
+    if r.status == 0 {
+        r.status = http.StatusOK
+    }
+
+    n, err := r.ResponseWriter.Write(data)
+    r.bytes += n
+    return n, err
+}
+
+// Event streams need to flush through the recorder
+func (r *responseRecorder) Flush() {
+    if flusher, canFlush := r.ResponseWriter.(http.Flusher); canFlush {
+        flusher.Flush()
+    }
+}
+
+// One access log line per request
+func LogRequests(logger *slog.Logger) Middleware {
+    return func(next http.Handler) http.Handler {
+        return http.HandlerFunc(func(writer http.ResponseWriter,
+                                     request *http.Request) {
+            start := time.Now()
+            recorder := &responseRecorder{ResponseWriter: writer}
+
+            next.ServeHTTP(recorder, request)
+
+            if recorder.status == 0 {
+                recorder.status = http.StatusOK
+            }
+
+            // Only the path, query strings aren't trusted to be secret free
+            logger.InfoContext(request.Context(),
+                               "Handled request",
+                               "method", request.Method,
+                               "path", request.URL.Path,
+                               "status", recorder.status,
+                               "bytes", recorder.bytes,
+                               "duration", time.Since(start))
+        })
+    }
+}
+
+// A bug in one handler shouldn't take the whole server down
+func RecoverPanics(logger *slog.Logger) Middleware {
+    return func(next http.Handler) http.Handler {
+        return http.HandlerFunc(func(writer http.ResponseWriter,
+                                     request *http.Request) {
+            defer func() {
+                recovered := recover()
+
+                if recovered == nil {
+                    return
+                }
+
+                // The server uses this to abort a response on purpose
+                if recovered == http.ErrAbortHandler {
+                    panic(recovered)
+                }
+
+                logger.ErrorContext(request.Context(),
+                                    "Panic handling request",
+                                    "method", request.Method,
+                                    "path", request.URL.Path,
+                                    "panic", fmt.Sprint(recovered),
+                                    "stack", string(debug.Stack()))
+                api.FormatResponse(writer,
+                                   nil,
+                                   fmt.Errorf("Internal server error"))
+            }()
+
+            next.ServeHTTP(writer, request)
+        })
+    }
+}
+
+func LimitBody(maxBytes int64) Middleware {
+    return func(next http.Handler) http.Handler {
+        return http.HandlerFunc(func(writer http.ResponseWriter,
+                                     request *http.Request) {
+            request.Body = http.MaxBytesReader(writer, request.Body, maxBytes)
+            next.ServeHTTP(writer, request)
+        })
+    }
+}
@@ -0,0 +1,61 @@
+package main_test
+
+import (
+    "net/http"
+    "net/http/httptest"
+    "testing"
+
+    "github.com/dox5/dnd_royal_server/dndbrserver"
+)
+
+func TestPanickingHandlerShouldGiveServerError(t *testing.T) {
+    router := main.NewEndpoint()
+    router.Handle("/boom",
+                  http.MethodGet,
+                  http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+                      panic("boom")
+                  }))
+    handler := main.Chain(router, main.RecoverPanics(discardLogger()))
+
+    recorder := httptest.NewRecorder()
+    handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/boom", nil))
+
+    if recorder.Code != http.StatusInternalServerError {
+        t.Errorf("Expected status %d but got %d",
+                 http.StatusInternalServerError,
+                 recorder.Code)
+    }
+}
+
+func TestRequestsShouldBeGivenIds(t *testing.T) {
+    var seen string
+    handler := main.TagRequests(
+        http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+            seen = main.RequestIdFrom(request.Context())
+        }))
+
+    recorder := httptest.NewRecorder()
+    handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+    if seen == "" || recorder.Header().Get(main.RequestIdHeader) != seen {
+        t.Errorf("Expected request id %q to be echoed but got %q",
+                 seen,
+                 recorder.Header().Get(main.RequestIdHeader))
+    }
+
+    request := httptest.NewRequest(http.MethodGet, "/", nil)
+    request.Header.Set(main.RequestIdHeader, "proxy-1234")
+    handler.ServeHTTP(httptest.NewRecorder(), request)
+
+    if seen != "proxy-1234" {
+        t.Errorf("Expected incoming request id to be kept but got %q", seen)
+    }
+
+    request = httptest.NewRequest(http.MethodGet, "/", nil)
+    request.Header.Set(main.RequestIdHeader, "bad\nid")
+    handler.ServeHTTP(httptest.NewRecorder(), request)
+
+    if seen == "bad\nid" {
+        t.Error("Expected unsafe request id to be replaced")
+    }
+}
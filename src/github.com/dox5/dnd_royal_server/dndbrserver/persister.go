@@ -0,0 +1,162 @@
+package main
+
+import (
+    "log/slog"
+    "sync"
+
+    "github.com/dox5/dnd_royal_server/model"
+)
+
+// Writes changed rooms to the store from a single goroutine so nothing waits
+// on the disk while holding a room lock. Like the scheduler, the goroutine
+// only runs while there are rooms waiting to be written.
+//
+// Locking: markDirty is safe to call with a room lock held. The writer takes
+// saveLock before a room's lock, never the other way round.
+type roomPersister struct {
+    store RoomStore
+    logger *slog.Logger
+    // Copies the room out under its read lock, false if it has been deleted
+    snapshot func(room *activeRoom) (model.RoomSnapshot, bool)
+
+    lock sync.Mutex
+    dirty map[*activeRoom]bool
+    running bool
+    stopped bool
+    done sync.WaitGroup
+
+    // Held across taking a snapshot and saving it, so a save can't overtake
+    // a later one or bring a deleted room back
+    saveLock sync.Mutex
+}
+
+func newRoomPersister(store RoomStore,
+                      logger *slog.Logger,
+                      snapshot func(*activeRoom) (model.RoomSnapshot,
+                                                  bool)) *roomPersister {
+    return &roomPersister{store: store,
+                          logger: logger,
+                          snapshot: snapshot,
+                          dirty: make(map[*activeRoom]bool)}
+}
+
+// Have the room written out soon
+func (p *roomPersister) markDirty(room *activeRoom) {
+    if p.store == nil {
+        return
+    }
+
+    p.lock.Lock()
+    defer p.lock.Unlock()
+
+    if p.stopped {
+        return
+    }
+
+    p.dirty[room] = true
+
+    if !p.running {
+        p.running = true
+        p.done.Add(1)
+        go p.run()
+    }
+}
+
+// Take every room waiting to be written, the writer stops once there are none
+func (p *roomPersister) takeDirty() []*activeRoom {
+    p.lock.Lock()
+    defer p.lock.Unlock()
+
+    if len(p.dirty) == 0 {
+        p.running = false
+        return nil
+    }
+
+    rooms := make([]*activeRoom, 0, len(p.dirty))
+    for room := range p.dirty {
+        rooms = append(rooms, room)
+        delete(p.dirty, room)
+    }
+
+    return rooms
+}
+
+func (p *roomPersister) run() {
+    defer p.done.Done()
+
+    for {
+        rooms := p.takeDirty()
+
+        if rooms == nil {
+            return
+        }
+
+        for _, room := range rooms {
+            p.save(room)
+        }
+    }
+}
+
+// Failures are only logged, the change they were saving has already happened
+func (p *roomPersister) save(room *activeRoom) {
+    p.saveLock.Lock()
+    defer p.saveLock.Unlock()
+
+    snapshot, ok := p.snapshot(room)
+
+    if ok {
+        p.write(snapshot)
+    }
+}
+
+// Save a snapshot taken by the caller, the caller must hold saveLock
+func (p *roomPersister) write(snapshot model.RoomSnapshot) {
+    err := p.store.Save(snapshot)
+
+    if err != nil {
+        p.logger.Error("Failed to persist room",
+                       roomAttr(snapshot.Id),
+                       "error", err)
+    }
+}
+
+// Write every room waiting to be written, from the calling goroutine
+func (p *roomPersister) flush() {
+    p.lock.Lock()
+    rooms := make([]*activeRoom, 0, len(p.dirty))
+    for room := range p.dirty {
+        rooms = append(rooms, room)
+        delete(p.dirty, room)
+    }
+    p.lock.Unlock()
+
+    for _, room := range rooms {
+        p.save(room)
+    }
+}
+
+// Remove the room from the store, it must already be marked deleted. Must not
+// be called with a room lock held.
+func (p *roomPersister) delete(room *activeRoom,
+                               roomId model.Identifier) error {
+    if p.store == nil {
+        return nil
+    }
+
+    p.lock.Lock()
+    delete(p.dirty, room)
+    p.lock.Unlock()
+
+    p.saveLock.Lock()
+    defer p.saveLock.Unlock()
+    return p.store.Delete(roomId)
+}
+
+// Stop the writer, waiting for it to write out the rooms already marked
+func (p *roomPersister) shutdown() {
+    p.lock.Lock()
+    p.stopped = true
+    p.lock.Unlock()
+
+    p.done.Wait()
+}
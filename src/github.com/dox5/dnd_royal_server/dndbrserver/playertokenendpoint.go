@@ -1,19 +1,19 @@
 package main
 
 import (
-    "fmt"
-    "log"
+    "log/slog"
     "net/http"
+    "strconv"
 
     "github.com/dox5/dnd_royal_server/api"
     "github.com/dox5/dnd_royal_server/model"
 )
 
 func getTokens(rooms *RoomManager,
-               logger *log.Logger,
+               logger *slog.Logger,
                request *http.Request) (interface{}, error) {
 
-    roomId, err := api.RoomIdFromRequest(request)
+    roomId, err := roomIdFromRequest(request)
 
     if err != nil {
         return nil, err
@@ -30,14 +30,13 @@ func getTokens(rooms *RoomManager,
 }
 
 func setPosition(rooms *RoomManager,
-                 logger *log.Logger,
+                 logger *slog.Logger,
                  request *http.Request) (interface{}, error) {
 
     var tokenPosition struct {
         TokenId model.Identifier `json:",string"`
         Position model.Vector
         RoomId model.Identifier `json:",string"`
-        GameMasterId model.Identifier `json:",string"`
     }
     err := api.ParseJsonRequest(request, &tokenPosition)
 
@@ -45,34 +44,241 @@ func setPosition(rooms *RoomManager,
         return nil, err
     }
 
+    // Either the game master (who can move any token) or the player that
+    // owns the token
+    session, err := authorise(request, tokenPosition.RoomId, RolePlayer)
+
+    if err != nil {
+        return nil, err
+    }
+
     err = rooms.WithExclusiveRoom(tokenPosition.RoomId,
                                   func(room *model.Room) error {
-        if tokenPosition.GameMasterId != room.GameMaster().Id() {
-            return fmt.Errorf("Unautherised access")
+        if !room.CanMoveToken(session.UserId, tokenPosition.TokenId) {
+            return api.Forbidden("Token %v belongs to another player",
+                                 tokenPosition.TokenId)
         }
 
-        token, foundIt := room.GetPlayerToken(tokenPosition.TokenId)
+        logger.InfoContext(request.Context(),
+                           "Moving token",
+                           "tokenId", tokenPosition.TokenId,
+                           "position", tokenPosition.Position)
+
+        return room.MovePlayerToken(tokenPosition.TokenId,
+                                    tokenPosition.Position)
+    })
+
+    return nil, err
+}
+
+func addToken(rooms *RoomManager,
+              logger *slog.Logger,
+              request *http.Request) (interface{}, error) {
+
+    var addRequest struct {
+        Position model.Vector
+        Name string
+        RoomId model.Identifier `json:",string"`
+    }
+    err := api.ParseJsonRequest(request, &addRequest)
+
+    if err != nil {
+        return nil, err
+    }
+
+    var response struct {
+        TokenId model.Identifier `json:",string"`
+    }
 
-        if(foundIt) {
-            logger.Printf("Setting token position for token %+v to %+v for room %+v",
-                          tokenPosition.TokenId,
-                          tokenPosition.Position,
-                          tokenPosition.RoomId)
+    _, err = authorise(request, addRequest.RoomId, RoleGameMaster)
+
+    if err != nil {
+        return nil, err
+    }
 
-            token.Position.X = tokenPosition.Position.X
-            token.Position.Y = tokenPosition.Position.Y
+    err = rooms.WithExclusiveRoom(addRequest.RoomId,
+                                  func(room *model.Room) error {
+        id, err := room.AddNamedPlayerToken(addRequest.Position, addRequest.Name)
 
-            return nil
+        if err != nil {
+            return err
         }
 
-        return fmt.Errorf("No token found with ID %+v", tokenPosition.TokenId)
+        logger.InfoContext(request.Context(),
+                           "Added token",
+                           "tokenId", id)
+        response.TokenId = id
+        return nil
+    })
+
+    return response, err
+}
+
+func removeToken(rooms *RoomManager,
+                 logger *slog.Logger,
+                 request *http.Request) (interface{}, error) {
+
+    var removeRequest struct {
+        TokenId model.Identifier `json:",string"`
+        RoomId model.Identifier `json:",string"`
+    }
+    err := api.ParseJsonRequest(request, &removeRequest)
+
+    if err != nil {
+        return nil, err
+    }
+
+    _, err = authorise(request, removeRequest.RoomId, RoleGameMaster)
+
+    if err != nil {
+        return nil, err
+    }
+
+    err = rooms.WithExclusiveRoom(removeRequest.RoomId,
+                                  func(room *model.Room) error {
+        logger.InfoContext(request.Context(),
+                           "Removing token",
+                           "tokenId", removeRequest.TokenId)
+        return room.RemovePlayerToken(removeRequest.TokenId)
+    })
+
+    return nil, err
+}
+
+func renameToken(rooms *RoomManager,
+                 logger *slog.Logger,
+                 request *http.Request) (interface{}, error) {
+
+    var renameRequest struct {
+        TokenId model.Identifier `json:",string"`
+        Name string
+        RoomId model.Identifier `json:",string"`
+    }
+    err := api.ParseJsonRequest(request, &renameRequest)
+
+    if err != nil {
+        return nil, err
+    }
+
+    _, err = authorise(request, renameRequest.RoomId, RoleGameMaster)
+
+    if err != nil {
+        return nil, err
+    }
+
+    err = rooms.WithExclusiveRoom(renameRequest.RoomId,
+                                  func(room *model.Room) error {
+        logger.InfoContext(request.Context(),
+                           "Renaming token",
+                           "tokenId", renameRequest.TokenId,
+                           "name", renameRequest.Name)
+        return room.RenamePlayerToken(renameRequest.TokenId, renameRequest.Name)
+    })
+
+    return nil, err
+}
+
+func damageLog(rooms *RoomManager,
+               logger *slog.Logger,
+               request *http.Request) (interface{}, error) {
+
+    roomId, err := roomIdFromRequest(request)
+
+    if err != nil {
+        return nil, err
+    }
+
+    // Only damage after this many seconds of room time
+    since := float64(0)
+    if sinceString := request.FormValue("Since"); len(sinceString) > 0 {
+        since, err = strconv.ParseFloat(sinceString, 32)
+
+        if err != nil {
+            return nil, api.BadRequest("Since must be a number: %s", err)
+        }
+    }
+
+    var response struct {
+        Time float32
+        Events []model.DamageEvent
+    }
+
+    err = rooms.WithSharedRoom(roomId, func(room *model.Room) error {
+        response.Time = room.Time()
+        response.Events = room.DamageLog(float32(since))
+        return nil
+    })
+
+    return response, err
+}
+
+func setDamageRate(rooms *RoomManager,
+                   logger *slog.Logger,
+                   request *http.Request) (interface{}, error) {
+
+    var damageRequest struct {
+        DamagePerSecond float32
+        RoomId model.Identifier `json:",string"`
+    }
+    err := api.ParseJsonRequest(request, &damageRequest)
+
+    if err != nil {
+        return nil, err
+    }
+
+    _, err = authorise(request, damageRequest.RoomId, RoleGameMaster)
+
+    if err != nil {
+        return nil, err
+    }
+
+    err = rooms.WithExclusiveRoom(damageRequest.RoomId,
+                                  func(room *model.Room) error {
+        logger.InfoContext(request.Context(),
+                           "Setting zone damage",
+                           "damagePerSecond", damageRequest.DamagePerSecond)
+        return room.SetDamagePerSecond(damageRequest.DamagePerSecond)
+    })
+
+    return nil, err
+}
+
+func setHitPoints(rooms *RoomManager,
+                  logger *slog.Logger,
+                  request *http.Request) (interface{}, error) {
+
+    var hitPointsRequest struct {
+        TokenId model.Identifier `json:",string"`
+        HitPoints float32
+        RoomId model.Identifier `json:",string"`
+    }
+    err := api.ParseJsonRequest(request, &hitPointsRequest)
+
+    if err != nil {
+        return nil, err
+    }
+
+    _, err = authorise(request, hitPointsRequest.RoomId, RoleGameMaster)
+
+    if err != nil {
+        return nil, err
+    }
+
+    err = rooms.WithExclusiveRoom(hitPointsRequest.RoomId,
+                                  func(room *model.Room) error {
+        logger.InfoContext(request.Context(),
+                           "Setting token hit points",
+                           "tokenId", hitPointsRequest.TokenId,
+                           "hitPoints", hitPointsRequest.HitPoints)
+        return room.SetTokenHitPoints(hitPointsRequest.TokenId,
+                                      hitPointsRequest.HitPoints)
     })
 
     return nil, err
 }
 
 func MakePlayerTokenEndpoint(rooms *RoomManager,
-                             logger *log.Logger) *Endpoint {
+                             logger *slog.Logger) *Endpoint {
     endpoint := NewEndpoint()
 
     endpoint.Register("/getTokens",
@@ -87,5 +293,41 @@ func MakePlayerTokenEndpoint(rooms *RoomManager,
                           return setPosition(rooms, logger, request)
                       })
 
+    endpoint.Register("/addToken",
+                      http.MethodPost,
+                      func(request *http.Request) (interface{}, error) {
+                          return addToken(rooms, logger, request)
+                      })
+
+    endpoint.Register("/removeToken",
+                      http.MethodPost,
+                      func(request *http.Request) (interface{}, error) {
+                          return removeToken(rooms, logger, request)
+                      })
+
+    endpoint.Register("/renameToken",
+                      http.MethodPost,
+                      func(request *http.Request) (interface{}, error) {
+                          return renameToken(rooms, logger, request)
+                      })
+
+    endpoint.Register("/damageLog",
+                      http.MethodGet,
+                      func(request *http.Request) (interface{}, error) {
+                          return damageLog(rooms, logger, request)
+                      })
+
+    endpoint.Register("/setDamageRate",
+                      http.MethodPost,
+                      func(request *http.Request) (interface{}, error) {
+                          return setDamageRate(rooms, logger, request)
+                      })
+
+    endpoint.Register("/setHitPoints",
+                      http.MethodPost,
+                      func(request *http.Request) (interface{}, error) {
+                          return setHitPoints(rooms, logger, request)
+                      })
+
     return endpoint
 }
@@ -0,0 +1,299 @@
+package main
+
+import (
+    "log/slog"
+    "net/http"
+
+    "github.com/dox5/dnd_royal_server/api"
+    "github.com/dox5/dnd_royal_server/model"
+)
+
+// The body is an optional model.RoomConfig, without one the room gets the
+// default layout
+func createRoom(rooms *RoomManager,
+                logger *slog.Logger,
+                auth *Authenticator,
+                request *http.Request) (interface{}, error) {
+
+    config := model.DefaultRoomConfig()
+
+    if request.ContentLength != 0 {
+        config = model.RoomConfig{}
+        err := api.ParseJsonRequest(request, &config)
+
+        if err != nil {
+            return nil, err
+        }
+    }
+
+    room, err := rooms.Create(config)
+
+    if err != nil {
+        return nil, err
+    }
+
+    token, err := auth.Issue(room.Id(), room.GameMaster().Id(), RoleGameMaster)
+
+    if err != nil {
+        return nil, err
+    }
+
+    response := api.RoomCreateResponse{
+        RoomId: room.Id(),
+        GameMasterId: room.GameMaster().Id(),
+        Token: token }
+
+    logRoom(request, room.Id())
+    logger.InfoContext(request.Context(), "Created room")
+
+    return response, nil
+}
+
+func deleteRoom(rooms *RoomManager,
+                logger *slog.Logger,
+                request *http.Request) (interface{}, error) {
+
+    var deleteRequest struct {
+        RoomId model.Identifier `json:",string"`
+    }
+    err := api.ParseJsonRequest(request, &deleteRequest)
+
+    if err != nil {
+        return nil, err
+    }
+
+    _, err = authorise(request, deleteRequest.RoomId, RoleGameMaster)
+
+    if err != nil {
+        return nil, err
+    }
+
+    err = rooms.Delete(deleteRequest.RoomId)
+
+    if err == nil {
+        logger.InfoContext(request.Context(), "Deleted room")
+    }
+
+    return nil, err
+}
+
+func joinRoom(rooms *RoomManager,
+              logger *slog.Logger,
+              auth *Authenticator,
+              request *http.Request) (interface{}, error) {
+
+    var joinRequest struct {
+        Name string
+        Colour string
+        // Optional, otherwise a free token is picked
+        TokenId *model.Identifier `json:",string"`
+        RoomId model.Identifier `json:",string"`
+    }
+    err := api.ParseJsonRequest(request, &joinRequest)
+
+    if err != nil {
+        return nil, err
+    }
+
+    joining, err := model.NewNamedPlayer(joinRequest.Name, joinRequest.Colour)
+
+    if err != nil {
+        return nil, err
+    }
+
+    var response api.RoomJoinResponse
+
+    err = rooms.WithExclusiveRoom(joinRequest.RoomId,
+                                  func(room *model.Room) error {
+        token, err := room.Join(joining, joinRequest.TokenId)
+
+        if err != nil {
+            return err
+        }
+
+        logRoom(request, joinRequest.RoomId)
+        logger.InfoContext(request.Context(),
+                           "Player joined room",
+                           "name", joining.Name(),
+                           "tokenId", token.Id)
+
+        response.PlayerId = joining.Id()
+        response.TokenId = token.Id
+        return nil
+    })
+
+    if err != nil {
+        return nil, err
+    }
+
+    response.Token, err = auth.Issue(joinRequest.RoomId,
+                                     response.PlayerId,
+                                     RolePlayer)
+
+    return response, err
+}
+
+// Swap the caller's token for a new one, even if it has recently expired, as
+// long as they are still in the room and the session isn't past MaxSessionAge
+func refreshSession(rooms *RoomManager,
+                    auth *Authenticator,
+                    request *http.Request) (interface{}, error) {
+
+    token := tokenFromRequest(request)
+
+    if token == "" {
+        return nil, api.Unauthorised("Missing session token")
+    }
+
+    session, err := auth.VerifyRefreshable(token)
+
+    if err != nil {
+        return nil, err
+    }
+
+    logRoom(request, session.RoomId)
+
+    err = rooms.WithSharedRoom(session.RoomId, func(room *model.Room) error {
+        if session.Role == RoleGameMaster &&
+           room.GameMaster().Id() == session.UserId {
+            return nil
+        }
+
+        if _, found := room.Player(session.UserId); found &&
+           session.Role == RolePlayer {
+            return nil
+        }
+
+        return api.Unauthorised("No longer in the room")
+    })
+
+    if err != nil {
+        return nil, err
+    }
+
+    var response api.SessionResponse
+    response.Token, err = auth.Refresh(session)
+
+    return response, err
+}
+
+// Player ids are public, only a signed session says who is calling
+func getPlayers(rooms *RoomManager,
+                logger *slog.Logger,
+                request *http.Request) (interface{}, error) {
+
+    roomId, err := roomIdFromRequest(request)
+
+    if err != nil {
+        return nil, err
+    }
+
+    players := make([]api.PlayerResponse, 0)
+
+    err = rooms.WithSharedRoom(roomId, func(room *model.Room) error {
+        for _, p := range room.Players() {
+            players = append(players, api.PlayerResponse{PlayerId: p.Id(),
+                                                         Name: p.Name(),
+                                                         Colour: p.Colour()})
+        }
+        return nil
+    })
+
+    return players, err
+}
+
+func getMapInfo(rooms *RoomManager,
+                logger *slog.Logger,
+                request *http.Request) (interface{}, error) {
+
+    roomId, err := roomIdFromRequest(request)
+
+    if err != nil {
+        return nil, err
+    }
+
+    var response api.MapResponse
+
+    err = rooms.WithSharedRoom(roomId, func(room *model.Room) error {
+        response.Asset = room.MapAsset()
+        response.Width = room.MapSize().Width
+        response.Height = room.MapSize().Height
+        return nil
+    })
+
+    return response, err
+}
+
+func getRoomState(rooms *RoomManager,
+                  request *http.Request) (interface{}, error) {
+    roomId, err := roomIdFromRequest(request)
+
+    if err != nil {
+        return nil, err
+    }
+
+    return rooms.GetRoomState(roomId)
+}
+
+func MakeRoomEndpoint(rooms *RoomManager,
+                      logger *slog.Logger,
+                      auth *Authenticator,
+                      maps *MapStore) *Endpoint {
+    endpoint := NewEndpoint()
+
+    // The map image can also be fetched as /map/<RoomId> so each room's map
+    // has its own URL
+    endpoint.Handle("/map", http.MethodGet, serveMapHandler(rooms, maps))
+    endpoint.Handle("/map/{RoomId}",
+                    http.MethodGet,
+                    serveMapHandler(rooms, maps))
+    endpoint.Handle("/uploadMap",
+                    http.MethodPost,
+                    uploadMapHandler(logger, rooms, maps),
+                    LimitBody(MaxMapBytes))
+    endpoint.Handle("/events", http.MethodGet, roomEventsHandler(logger, rooms))
+
+    endpoint.Register("/create",
+                      http.MethodPost,
+                      func(request *http.Request) (interface{}, error) {
+                          return createRoom(rooms, logger, auth, request)
+                      })
+
+    endpoint.Register("/delete",
+                      http.MethodPost,
+                      func(request *http.Request) (interface{}, error) {
+                          return deleteRoom(rooms, logger, request)
+                      })
+
+    endpoint.Register("/join",
+                      http.MethodPost,
+                      func(request *http.Request) (interface{}, error) {
+                          return joinRoom(rooms, logger, auth, request)
+                      })
+
+    endpoint.Register("/refreshSession",
+                      http.MethodPost,
+                      func(request *http.Request) (interface{}, error) {
+                          return refreshSession(rooms, auth, request)
+                      })
+
+    endpoint.Register("/players",
+                      http.MethodGet,
+                      func(request *http.Request) (interface{}, error) {
+                          return getPlayers(rooms, logger, request)
+                      })
+
+    endpoint.Register("/mapInfo",
+                      http.MethodGet,
+                      func(request *http.Request) (interface{}, error) {
+                          return getMapInfo(rooms, logger, request)
+                      })
+
+    endpoint.Register("/state",
+                      http.MethodGet,
+                      func(request *http.Request) (interface{}, error) {
+                          return getRoomState(rooms, request)
+                      })
+
+    return endpoint
+}
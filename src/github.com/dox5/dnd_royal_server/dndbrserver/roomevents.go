@@ -0,0 +1,176 @@
+package main
+
+import (
+    "reflect"
+    "sync"
+
+    "github.com/dox5/dnd_royal_server/api"
+    "github.com/dox5/dnd_royal_server/model"
+)
+
+const (
+    FogEvent     = "fog"
+    PausedEvent  = "paused"
+    ResumedEvent = "resumed"
+    TokenEvent   = "token"
+    TokenRemovedEvent = "tokenRemoved"
+
+    // Events a subscriber can fall behind by before it is dropped
+    subscriberBacklog = 32
+)
+
+// Why a subscriber's channel was closed
+type CloseReason string
+
+const (
+    // Closed by the subscriber itself, or not closed yet
+    ClosedByUnsubscribe CloseReason = ""
+    ClosedTooSlow CloseReason = "tooSlow"
+    ClosedRoomDeleted CloseReason = "roomDeleted"
+    ClosedShutdown CloseReason = "shutdown"
+)
+
+type RoomEvent struct {
+    Type string
+    Data interface{}
+}
+
+// Fans room events out to subscribers. Publishing never blocks: a subscriber
+// that can't keep up has its channel closed and is expected to re-subscribe
+// (getting the full room state again).
+type roomEvents struct {
+    lock sync.Mutex
+    subscribers map[chan RoomEvent]bool
+    // Why channels were closed, until their subscriber unsubscribes
+    closed map[chan RoomEvent]CloseReason
+
+    // What subscribers were last told about, used to work out what changed
+    lastFog api.FogState
+    lastTokens map[model.Identifier]model.Token
+}
+
+func newRoomEvents(room *model.Room) *roomEvents {
+    events := &roomEvents{subscribers: make(map[chan RoomEvent]bool),
+                          closed: make(map[chan RoomEvent]CloseReason)}
+    events.lastFog = api.FogStateOf(room)
+    events.lastTokens = tokensById(room.GetPlayerTokens())
+    return events
+}
+
+func tokensById(tokens []model.Token) map[model.Identifier]model.Token {
+    byId := make(map[model.Identifier]model.Token, len(tokens))
+
+    for _, token := range tokens {
+        byId[token.Id] = token
+    }
+
+    return byId
+}
+
+// The returned channel starts off with the current state of the room. The
+// caller must hold (at least) the shared room lock so nothing is published
+// in between.
+func (events *roomEvents) subscribe(room *model.Room) chan RoomEvent {
+    tokens := room.GetPlayerTokens()
+    subscriber := make(chan RoomEvent, subscriberBacklog + 1 + len(tokens))
+
+    subscriber <- RoomEvent{Type: FogEvent, Data: api.FogStateOf(room)}
+    for _, token := range tokens {
+        subscriber <- RoomEvent{Type: TokenEvent, Data: token}
+    }
+
+    events.lock.Lock()
+    defer events.lock.Unlock()
+    events.subscribers[subscriber] = true
+
+    return subscriber
+}
+
+// Says why the channel was closed if that happened first
+func (events *roomEvents) unsubscribe(subscriber chan RoomEvent) CloseReason {
+    events.lock.Lock()
+    defer events.lock.Unlock()
+
+    if events.subscribers[subscriber] {
+        delete(events.subscribers, subscriber)
+        close(subscriber)
+        return ClosedByUnsubscribe
+    }
+
+    reason := events.closed[subscriber]
+    delete(events.closed, subscriber)
+    return reason
+}
+
+// Close the subscriber's channel, the lock must be held
+func (events *roomEvents) drop(subscriber chan RoomEvent, reason CloseReason) {
+    delete(events.subscribers, subscriber)
+    events.closed[subscriber] = reason
+    close(subscriber)
+}
+
+func (events *roomEvents) publish(event RoomEvent) {
+    events.lock.Lock()
+    defer events.lock.Unlock()
+
+    for subscriber := range events.subscribers {
+        select {
+        case subscriber <- event:
+        default:
+            // Too slow, cut it loose rather than hold up the room
+            events.drop(subscriber, ClosedTooSlow)
+        }
+    }
+}
+
+// Publish whatever has changed in the room since the last call. The caller
+// must hold the exclusive room lock.
+func (events *roomEvents) publishChanges(room *model.Room) {
+    fog := api.FogStateOf(room)
+
+    // Shapes can hold slices so can't be compared with !=
+    if !reflect.DeepEqual(fog, events.lastFog) {
+        eventType := FogEvent
+
+        if fog.Paused && !events.lastFog.Paused {
+            eventType = PausedEvent
+        } else if !fog.Paused && events.lastFog.Paused {
+            eventType = ResumedEvent
+        }
+
+        events.publish(RoomEvent{Type: eventType, Data: fog})
+        events.lastFog = fog
+    }
+
+    tokens := tokensById(room.GetPlayerTokens())
+    for id, token := range tokens {
+        if last, found := events.lastTokens[id]; !found || last != token {
+            events.publish(RoomEvent{Type: TokenEvent, Data: token})
+        }
+    }
+
+    for id := range events.lastTokens {
+        if _, found := tokens[id]; !found {
+            removed := struct {
+                Id model.Identifier `json:",string"`
+            }{Id: id}
+            events.publish(RoomEvent{Type: TokenRemovedEvent, Data: removed})
+        }
+    }
+    events.lastTokens = tokens
+}
+
+func (events *roomEvents) unsubscribeAll(reason CloseReason) {
+    events.lock.Lock()
+    defer events.lock.Unlock()
+
+    for subscriber := range events.subscribers {
+        events.drop(subscriber, reason)
+    }
+}
+
+func (events *roomEvents) hasSubscribers() bool {
+    events.lock.Lock()
+    defer events.lock.Unlock()
+    return len(events.subscribers) > 0
+}
@@ -0,0 +1,230 @@
+package main_test
+
+import (
+  "testing"
+  "time"
+
+  "github.com/dox5/dnd_royal_server/dndbrserver"
+  "github.com/dox5/dnd_royal_server/model"
+)
+
+func nextEvent(t *testing.T, events <-chan main.RoomEvent) main.RoomEvent {
+    t.Helper()
+
+    select {
+    case event, open := <- events:
+        if !open {
+            t.Fatal("Event channel closed unexpectedly")
+        }
+        return event
+    case <- time.After(time.Second):
+        t.Fatal("Timed out waiting for event")
+    }
+
+    return main.RoomEvent{}
+}
+
+func TestSubscribeShouldStartWithRoomState(t *testing.T) {
+    rooms := main.NewRoomManager()
+    room, err := rooms.Create(model.DefaultRoomConfig())
+
+    if err != nil {
+        t.Fatalf("Failed to create room: %s", err)
+    }
+
+    events, unsubscribe, err := rooms.Subscribe(room.Id())
+
+    if err != nil {
+        t.Fatalf("Failed to subscribe: %s", err)
+    }
+    defer unsubscribe()
+
+    if event := nextEvent(t, events); event.Type != main.FogEvent {
+        t.Errorf("Expected first event to be %s but it was %s",
+                 main.FogEvent,
+                 event.Type)
+    }
+
+    for range room.GetPlayerTokens() {
+        if event := nextEvent(t, events); event.Type != main.TokenEvent {
+            t.Errorf("Expected a %s event but got %s",
+                     main.TokenEvent,
+                     event.Type)
+        }
+    }
+}
+
+func TestSubscribeToMissingRoomShouldFail(t *testing.T) {
+    rooms := main.NewRoomManager()
+
+    _, _, err := rooms.Subscribe(15)
+
+    if err == nil {
+        t.Error("No room exists, should get an error")
+    }
+}
+
+func TestRoomChangesShouldBePublished(t *testing.T) {
+    rooms := main.NewRoomManager()
+    room, err := rooms.Create(model.DefaultRoomConfig())
+
+    if err != nil {
+        t.Fatalf("Failed to create room: %s", err)
+    }
+
+    events, unsubscribe, err := rooms.Subscribe(room.Id())
+
+    if err != nil {
+        t.Fatalf("Failed to subscribe: %s", err)
+    }
+    defer unsubscribe()
+
+    // Skip over the initial state
+    for i := 0; i < 1 + len(room.GetPlayerTokens()); i += 1 {
+        nextEvent(t, events)
+    }
+
+    tokenId := room.GetPlayerTokens()[0].Id
+    position := model.Vector{X: 100, Y: 200}
+
+    err = rooms.WithExclusiveRoom(room.Id(), func(room *model.Room) error {
+        token, _ := room.GetPlayerToken(tokenId)
+        token.Position = position
+        return nil
+    })
+
+    if err != nil {
+        t.Fatalf("Failed to update room: %s", err)
+    }
+
+    event := nextEvent(t, events)
+    token, isToken := event.Data.(model.Token)
+
+    if event.Type != main.TokenEvent || !isToken {
+        t.Fatalf("Expected a %s event but got %+v", main.TokenEvent, event)
+    }
+
+    if token.Id != tokenId || token.Position != position {
+        t.Errorf("Expected token %v to move to %+v but got %+v",
+                 tokenId,
+                 position,
+                 token)
+    }
+
+    err = rooms.WithExclusiveRoom(room.Id(), func(room *model.Room) error {
+        room.Fog().Resume()
+        return nil
+    })
+
+    if err != nil {
+        t.Fatalf("Failed to update room: %s", err)
+    }
+
+    if event := nextEvent(t, events); event.Type != main.ResumedEvent {
+        t.Errorf("Expected a %s event but got %s",
+                 main.ResumedEvent,
+                 event.Type)
+    }
+}
+
+func TestRemovedTokensShouldBePublished(t *testing.T) {
+    rooms := main.NewRoomManager()
+    defer rooms.Shutdown()
+
+    room, err := rooms.Create(model.DefaultRoomConfig())
+
+    if err != nil {
+        t.Fatalf("Failed to create room: %s", err)
+    }
+
+    events, unsubscribe, err := rooms.Subscribe(room.Id())
+
+    if err != nil {
+        t.Fatalf("Failed to subscribe: %s", err)
+    }
+    defer unsubscribe()
+
+    for i := 0; i < 1 + len(room.GetPlayerTokens()); i += 1 {
+        nextEvent(t, events)
+    }
+
+    tokenId := room.GetPlayerTokens()[0].Id
+    err = rooms.WithExclusiveRoom(room.Id(), func(room *model.Room) error {
+        return room.RemovePlayerToken(tokenId)
+    })
+
+    if err != nil {
+        t.Fatalf("Failed to remove token: %s", err)
+    }
+
+    if event := nextEvent(t, events); event.Type != main.TokenRemovedEvent {
+        t.Errorf("Expected a %s event but got %s",
+                 main.TokenRemovedEvent,
+                 event.Type)
+    }
+}
+
+// Read until the channel closes, failing if it doesn't
+func drainEvents(t *testing.T, events <-chan main.RoomEvent) {
+    t.Helper()
+
+    timeout := time.After(time.Second)
+    for {
+        select {
+        case _, open := <- events:
+            if !open {
+                return
+            }
+        case <- timeout:
+            t.Fatal("Timed out waiting for the event channel to close")
+        }
+    }
+}
+
+func TestClosedSubscriptionsShouldSayWhy(t *testing.T) {
+    rooms := main.NewRoomManager()
+    defer rooms.Shutdown()
+
+    room, err := rooms.Create(model.DefaultRoomConfig())
+
+    if err != nil {
+        t.Fatalf("Failed to create room: %s", err)
+    }
+
+    slow, unsubscribeSlow, err := rooms.Subscribe(room.Id())
+
+    if err != nil {
+        t.Fatalf("Failed to subscribe: %s", err)
+    }
+
+    // Never read, so the backlog fills up
+    tokenId := room.GetPlayerTokens()[0].Id
+    for i := 0; i < 100; i += 1 {
+        rooms.WithExclusiveRoom(room.Id(), func(room *model.Room) error {
+            return room.MovePlayerToken(tokenId,
+                                        model.Vector{X: float32(i), Y: 0})
+        })
+    }
+
+    drainEvents(t, slow)
+
+    if reason := unsubscribeSlow(); reason != main.ClosedTooSlow {
+        t.Errorf("Expected %q but got %q", main.ClosedTooSlow, reason)
+    }
+
+    events, unsubscribe, err := rooms.Subscribe(room.Id())
+
+    if err != nil {
+        t.Fatalf("Failed to subscribe: %s", err)
+    }
+
+    if err := rooms.Delete(room.Id()); err != nil {
+        t.Fatalf("Failed to delete room: %s", err)
+    }
+
+    drainEvents(t, events)
+
+    if reason := unsubscribe(); reason != main.ClosedRoomDeleted {
+        t.Errorf("Expected %q but got %q", main.ClosedRoomDeleted, reason)
+    }
+}
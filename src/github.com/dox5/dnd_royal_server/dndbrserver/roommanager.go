@@ -2,83 +2,263 @@ package main
 
 import (
   "fmt"
+  "log/slog"
   "sync"
+  "sync/atomic"
   "time"
 
+  "github.com/dox5/dnd_royal_server/api"
+  "github.com/dox5/dnd_royal_server/metrics"
   "github.com/dox5/dnd_royal_server/model"
 )
 
 const (
-    UpdateRateHz float32 = 2
+    DefaultUpdateRateHz float32 = 2
+    // How often a moving fog is written to the room store
+    PersistPeriod = 5 * time.Second
 )
 
 type activeRoom struct {
     room *model.Room
     roomLock sync.RWMutex
-    shutdown chan bool
+    events *roomEvents
+    // Set (under roomLock) once the room has been removed from the manager
+    deleted bool
+    // UnixNano of the last time anyone looked at the room
+    lastTouched int64
+    // The clock time the fog's own time counts from, kept up to date while
+    // the room is scheduled
+    fogEpoch time.Time
 }
 
 type RoomManager struct {
     rooms map[model.Identifier]*activeRoom
     managerLock sync.RWMutex
+    store RoomStore
+    logger *slog.Logger
+    settings RoomSettings
+    clock Clock
+    registry *metrics.Registry
+    metrics roomMetrics
+
+    scheduler *roomScheduler
+    persister *roomPersister
+    stopReaper chan bool
+    shutdownOnce sync.Once
 }
 
-func roomAdvancer(room *activeRoom, updateRate float32) {
-    accumulator := float64(0)
-    periodSeconds := float64(float32(1) / updateRate)
+func (room *activeRoom) touch(now time.Time) {
+    atomic.StoreInt64(&room.lastTouched, now.UnixNano())
+}
 
-    for {
-        updateStart := time.Now()
+func (room *activeRoom) idleFor(now time.Time) time.Duration {
+    lastTouched := atomic.LoadInt64(&room.lastTouched)
+    return now.Sub(time.Unix(0, lastTouched))
+}
 
-        select {
-        case <- room.shutdown:
-            return
-        default:
-        }
+// Line the fog's time up with the clock, its current time being at. Needs
+// the exclusive room lock.
+func (room *activeRoom) syncFog(at time.Time) {
+    fogTime := room.room.Fog().Time()
+    room.fogEpoch = at.Add(-time.Duration(fogTime * float64(time.Second)))
+}
+
+// Called by the scheduler when the room is due an update. Updates are always
+// a whole period so a room's fog moves the same however late it runs.
+func (rm *RoomManager) updateRoom(entry *scheduledRoom, now time.Time) {
+    room := entry.room
+    rm.lockRoom(room, "advancer")
+    defer room.roomLock.Unlock()
+
+    if room.deleted {
+        rm.scheduler.finish(entry, false, now)
+        return
+    }
+
+    rm.metrics.tickLag.Observe(now.Sub(entry.due).Seconds())
+
+    period := rm.scheduler.period
+    entry.accumulator += now.Sub(entry.lastUpdate)
+    entry.lastUpdate = now
+
+    wasPaused := room.room.Fog().Paused()
+    updated := false
 
-        room.roomLock.Lock()
-        for ; accumulator > periodSeconds ; accumulator -= periodSeconds {
-            room.room.Update(float32(periodSeconds))
+    for ; entry.accumulator >= period ; entry.accumulator -= period {
+        rm.metrics.ticks.Inc()
+        err := room.room.Update(float32(period.Seconds()))
+
+        if err != nil {
+            rm.logger.Error("Failed to update room",
+                            roomAttr(room.room.Id()),
+                            "error", err)
+            entry.accumulator = 0
+            break
         }
-        room.roomLock.Unlock()
+        updated = true
+    }
 
-        sleepFor := (periodSeconds / 10.0) * float64(time.Second)
-        time.Sleep(time.Duration(sleepFor))
+    // Time still in the accumulator hasn't reached the fog yet
+    room.syncFog(now.Add(-entry.accumulator))
 
-        updateEnd := time.Now()
+    if updated {
+        room.events.publishChanges(room.room)
+    }
 
-        updateDuration := updateEnd.Sub(updateStart)
-        accumulator += updateDuration.Seconds()
+    // Only write out moving fog every so often, but always catch it
+    // arriving at the target
+    arrived := !wasPaused && room.room.Fog().Paused()
+    if updated && !wasPaused &&
+       (arrived || now.Sub(entry.lastPersisted) >= PersistPeriod) {
+        rm.persister.markDirty(room)
+        entry.lastPersisted = now
     }
+
+    rm.scheduler.finish(entry, room.room.Active(), now)
+}
+
+type RoomSettings struct {
+    // How many times a second rooms are advanced
+    UpdateRateHz float32
+    // Creating more rooms than this fails, 0 for no limit
+    MaxRooms int
+    // Where room timing comes from, the system clock if nil
+    Clock Clock
+}
+
+func DefaultRoomSettings() RoomSettings {
+    return RoomSettings{UpdateRateHz: DefaultUpdateRateHz,
+                        Clock: SystemClock()}
 }
 
 func NewRoomManager() *RoomManager {
     rm := &RoomManager{}
     rm.rooms = make(map[model.Identifier]*activeRoom)
+    rm.logger = discardLogger()
+    rm.settings = DefaultRoomSettings()
+    rm.clock = rm.settings.Clock
+    rm.stopReaper = make(chan bool)
+    rm.scheduler = newRoomScheduler(rm.clock,
+                                    rm.updatePeriod(),
+                                    rm.updateRoom)
+    rm.persister = newRoomPersister(nil, rm.logger, rm.snapshot)
+    rm.registry = metrics.NewRegistry()
+    rm.metrics = newRoomMetrics(rm.registry, rm)
     return rm
 }
 
-func (rm *RoomManager) Create() *model.Room {
-    gm := model.NewPlayer()
-    r := model.NewRoom(gm)
+// A RoomManager that saves rooms to store as they change. Any rooms already in
+// the store are brought back to life.
+func NewPersistentRoomManager(store RoomStore,
+                              logger *slog.Logger) (*RoomManager, error) {
+    return NewConfiguredRoomManager(store, logger, DefaultRoomSettings())
+}
 
+// store may be nil to only keep rooms in memory
+func NewConfiguredRoomManager(store RoomStore,
+                              logger *slog.Logger,
+                              settings RoomSettings) (*RoomManager, error) {
+    if settings.UpdateRateHz <= 0 {
+        return nil, fmt.Errorf("Update rate must be positive, got %v",
+                               settings.UpdateRateHz)
+    }
+
+    rm := NewRoomManager()
+    rm.store = store
+    rm.logger = logger
+    rm.settings = settings
+
+    if settings.Clock != nil {
+        rm.clock = settings.Clock
+    }
+
+    rm.scheduler = newRoomScheduler(rm.clock,
+                                    rm.updatePeriod(),
+                                    rm.updateRoom)
+    rm.persister = newRoomPersister(store, logger, rm.snapshot)
+
+    if store == nil {
+        return rm, nil
+    }
+
+    snapshots, err := store.LoadAll()
+
+    if err != nil {
+        return nil, err
+    }
+
+    // Rooms from before a limit was lowered are kept
+    for _, snapshot := range snapshots {
+        rm.add(model.RestoreRoom(snapshot), 0)
+        logger.Info("Restored room", roomAttr(snapshot.Id))
+    }
+
+    return rm, nil
+}
+
+func (rm *RoomManager) updatePeriod() time.Duration {
+    return time.Duration(float64(time.Second) /
+                         float64(rm.settings.UpdateRateHz))
+}
+
+// Copy the room out for the persister, unless it has been deleted
+func (rm *RoomManager) snapshot(room *activeRoom) (model.RoomSnapshot, bool) {
+    rm.readLockRoom(room, "persister")
+    defer room.roomLock.RUnlock()
+
+    if room.deleted {
+        return model.RoomSnapshot{}, false
+    }
+
+    return room.room.Snapshot(), true
+}
+
+// Start managing r, unless there are already limit rooms (0 for no limit)
+func (rm *RoomManager) add(r *model.Room, limit int) (*activeRoom, error) {
     rm.managerLock.Lock()
     defer rm.managerLock.Unlock()
-    active := &activeRoom{room: r, shutdown: make(chan bool)}
 
-    // TODO: this should be controlable!
-    numPlayers := 3
-    for i := 0; i < numPlayers; i += 1 {
-        pos := model.Vector{X: -50,
-                            Y: float32(50 * i)}
-        active.room.AddPlayerToken(pos)
+    if limit > 0 && len(rm.rooms) >= limit {
+        return nil, api.Conflict("The server already has the maximum of %d rooms",
+                                 limit)
     }
 
+    // So the first event subscribers get is already up to date
+    r.RefreshZone()
+
+    active := &activeRoom{room: r,
+                          events: newRoomEvents(r)}
+    active.touch(rm.clock.Now())
+    active.syncFog(rm.clock.Now())
+
     rm.rooms[r.Id()] = active
 
-    go roomAdvancer(active, UpdateRateHz)
+    // Restored rooms can be mid game
+    if r.Active() {
+        rm.scheduler.schedule(active)
+    }
+
+    return active, nil
+}
+
+func (rm *RoomManager) Create(config model.RoomConfig) (*model.Room, error) {
+    gm := model.NewPlayer()
+    r, err := model.NewRoomFromConfig(gm, config)
+
+    if err != nil {
+        return nil, err
+    }
+
+    active, err := rm.add(r, rm.settings.MaxRooms)
+
+    if err != nil {
+        return nil, err
+    }
+
+    rm.metrics.created.Inc()
+    rm.persister.markDirty(active)
 
-    return r
+    return r, nil
 }
 
 func (rm *RoomManager) Count() int {
@@ -87,30 +267,240 @@ func (rm *RoomManager) Count() int {
     return len(rm.rooms)
 }
 
+// Room and request metrics, for serving to a scraper
+func (rm *RoomManager) Metrics() *metrics.Registry {
+    return rm.registry
+}
+
+// Call f with each room read locked
+func (rm *RoomManager) forEachRoom(f func(room *activeRoom)) {
+    rm.managerLock.RLock()
+    defer rm.managerLock.RUnlock()
+
+    for _, room := range rm.rooms {
+        room.roomLock.RLock()
+        f(room)
+        room.roomLock.RUnlock()
+    }
+}
+
+// Lock the room, recording how long that took against holder
+func (rm *RoomManager) lockRoom(room *activeRoom, holder string) {
+    start := time.Now()
+    room.roomLock.Lock()
+    rm.metrics.lockWait.With(holder).Observe(time.Since(start).Seconds())
+}
+
+func (rm *RoomManager) readLockRoom(room *activeRoom, holder string) {
+    start := time.Now()
+    room.roomLock.RLock()
+    rm.metrics.lockWait.With(holder).Observe(time.Since(start).Seconds())
+}
+
+// Remove the room, stop it advancing and drop anyone listening to it
+func (rm *RoomManager) Delete(roomId model.Identifier) error {
+    return rm.remove(roomId, "request")
+}
+
+func (rm *RoomManager) remove(roomId model.Identifier, reason string) error {
+    rm.managerLock.Lock()
+    room, found := rm.rooms[roomId]
+    delete(rm.rooms, roomId)
+    rm.managerLock.Unlock()
+
+    if !found {
+        return api.NotFound("No room found with id %+v", roomId)
+    }
+
+    room.roomLock.Lock()
+    room.deleted = true
+    rm.scheduler.remove(room)
+    room.events.unsubscribeAll(ClosedRoomDeleted)
+    rm.metrics.deleted.With(reason).Inc()
+    room.roomLock.Unlock()
+
+    return rm.persister.delete(room, roomId)
+}
+
+// Delete every room that hasn't been touched for idleTimeout, returning how
+// many were removed
+func (rm *RoomManager) ReapIdle(idleTimeout time.Duration) int {
+    idle := make([]model.Identifier, 0)
+    now := rm.clock.Now()
+
+    rm.managerLock.RLock()
+    for roomId, room := range rm.rooms {
+        // Someone still watching the room counts as using it
+        if room.idleFor(now) >= idleTimeout && !room.events.hasSubscribers() {
+            idle = append(idle, roomId)
+        }
+    }
+    rm.managerLock.RUnlock()
+
+    reaped := 0
+    for _, roomId := range idle {
+        err := rm.remove(roomId, "idle")
+
+        if err != nil {
+            rm.logger.Error("Failed to delete idle room",
+                            roomAttr(roomId),
+                            "error", err)
+            continue
+        }
+
+        rm.logger.Info("Deleted idle room",
+                       roomAttr(roomId),
+                       "idleTimeout", idleTimeout)
+        reaped += 1
+    }
+
+    return reaped
+}
+
+// Periodically delete idle rooms until the manager is shut down
+func (rm *RoomManager) StartReaper(idleTimeout time.Duration,
+                                   checkPeriod time.Duration) {
+    go func() {
+        for {
+            select {
+            case <- rm.stopReaper:
+                return
+            case <- rm.clock.After(checkPeriod):
+                rm.ReapIdle(idleTimeout)
+            }
+        }
+    }()
+}
+
+// Disconnect everyone listening for room events, long lived streams would
+// otherwise hold up a graceful HTTP shutdown
+func (rm *RoomManager) CloseSubscriptions() {
+    rm.managerLock.RLock()
+    defer rm.managerLock.RUnlock()
+
+    for _, room := range rm.rooms {
+        room.events.unsubscribeAll(ClosedShutdown)
+    }
+}
+
+// Write every room changed since it was last saved, waiting until they are
+func (rm *RoomManager) Flush() {
+    rm.persister.flush()
+}
+
+// Stop updating rooms (and the reaper), saving every room on the way out.
+// Rooms are left in the store so they come back on the next start.
+func (rm *RoomManager) Shutdown() {
+    rm.shutdownOnce.Do(func() {
+        close(rm.stopReaper)
+        rm.scheduler.shutdown()
+        rm.persister.shutdown()
+
+        snapshots := make([]model.RoomSnapshot, 0)
+
+        rm.managerLock.Lock()
+        for _, room := range rm.rooms {
+            room.roomLock.Lock()
+            room.events.unsubscribeAll(ClosedShutdown)
+            snapshots = append(snapshots, room.room.Snapshot())
+            room.deleted = true
+            room.roomLock.Unlock()
+        }
+        rm.rooms = make(map[model.Identifier]*activeRoom)
+        rm.managerLock.Unlock()
+
+        if rm.store == nil {
+            return
+        }
+
+        rm.persister.saveLock.Lock()
+        defer rm.persister.saveLock.Unlock()
+
+        for _, snapshot := range snapshots {
+            rm.persister.write(snapshot)
+        }
+    })
+}
+
 func (rm *RoomManager) getActiveRoom(roomId model.Identifier) (*activeRoom, error) {
     rm.managerLock.RLock()
     defer rm.managerLock.RUnlock()
 
     if activeRoom, ok := rm.rooms[roomId]; ok {
+        activeRoom.touch(rm.clock.Now())
         return activeRoom, nil
     } else {
-        return nil, fmt.Errorf("No room found with id %+v", roomId)
+        return nil, api.NotFound("No room found with id %+v", roomId)
     }
 }
 
-func (rm *RoomManager) GetCurrentFog(roomId model.Identifier) (model.Circle, error) {
+func (rm *RoomManager) GetCurrentFog(roomId model.Identifier) (model.Shape, error) {
     activeRoom, err := rm.getActiveRoom(roomId)
 
     if err != nil {
-        return model.Circle{}, err
+        return nil, err
     }
 
-    activeRoom.roomLock.RLock()
+    rm.readLockRoom(activeRoom, "shared")
     defer activeRoom.roomLock.RUnlock()
 
+    if activeRoom.deleted {
+        return nil, api.NotFound("No room found with id %+v", roomId)
+    }
+
     return activeRoom.room.Fog().Current(), nil
 }
 
+// The fog along with when it moves, by the room manager's clock
+func (rm *RoomManager) GetFogLocation(roomId model.Identifier) (api.FogLocation,
+                                                               error) {
+    activeRoom, err := rm.getActiveRoom(roomId)
+
+    if err != nil {
+        return api.FogLocation{}, err
+    }
+
+    rm.readLockRoom(activeRoom, "shared")
+    defer activeRoom.roomLock.RUnlock()
+
+    if activeRoom.deleted {
+        return api.FogLocation{},
+               api.NotFound("No room found with id %+v", roomId)
+    }
+
+    return api.FogLocationOf(activeRoom.room,
+                             rm.clock.Now(),
+                             activeRoom.fogEpoch), nil
+}
+
+func (rm *RoomManager) GetRoomState(roomId model.Identifier) (api.RoomStateResponse,
+                                                              error) {
+    activeRoom, err := rm.getActiveRoom(roomId)
+
+    if err != nil {
+        return api.RoomStateResponse{}, err
+    }
+
+    rm.readLockRoom(activeRoom, "shared")
+    defer activeRoom.roomLock.RUnlock()
+
+    if activeRoom.deleted {
+        return api.RoomStateResponse{},
+               api.NotFound("No room found with id %+v", roomId)
+    }
+
+    fog := api.FogLocationOf(activeRoom.room,
+                             rm.clock.Now(),
+                             activeRoom.fogEpoch)
+
+    return api.RoomStateResponse{Fog: fog,
+                                 Tokens: activeRoom.room.GetPlayerTokens()}, nil
+}
+
+func (rm *RoomManager) Clock() Clock {
+    return rm.clock
+}
+
 type RoomUpdateCallback func (* model.Room) error
 func (rm *RoomManager) WithExclusiveRoom(roomId model.Identifier,
                                          callback RoomUpdateCallback) error {
@@ -120,11 +510,38 @@ func (rm *RoomManager) WithExclusiveRoom(roomId model.Identifier,
         return err
     }
 
-    room.roomLock.Lock()
+    rm.lockRoom(room, "exclusive")
     defer room.roomLock.Unlock()
+
+    if room.deleted {
+        return api.NotFound("No room found with id %+v", roomId)
+    }
+
+    fogTime := room.room.Fog().Time()
     err = callback(room.room)
 
-    return err
+    if err != nil {
+        return err
+    }
+
+    // Rooms that aren't being updated won't notice tokens crossing the fog
+    room.room.RefreshZone()
+    room.events.publishChanges(room.room)
+
+    // Resuming the fog or putting someone in harm's way starts the clock,
+    // skipping the fog on means it is further along as of now
+    if room.room.Active() {
+        scheduled := rm.scheduler.schedule(room)
+
+        if scheduled || room.room.Fog().Time() != fogTime {
+            room.syncFog(rm.clock.Now())
+        }
+    }
+
+    // Saved outside the lock, a failed save doesn't undo the change
+    rm.persister.markDirty(room)
+
+    return nil
 }
 
 func (rm *RoomManager) WithSharedRoom(roomId model.Identifier,
@@ -135,9 +552,42 @@ func (rm *RoomManager) WithSharedRoom(roomId model.Identifier,
         return err
     }
 
-    room.roomLock.RLock()
+    rm.readLockRoom(room, "shared")
     defer room.roomLock.RUnlock()
+
+    if room.deleted {
+        return api.NotFound("No room found with id %+v", roomId)
+    }
+
     err = callback(room.room)
 
     return err
 }
+
+// Listen for changes to a room. The channel is closed if the subscriber falls
+// too far behind or the room goes away, otherwise call the returned function
+// to stop listening. Either way the function says why the channel closed.
+func (rm *RoomManager) Subscribe(roomId model.Identifier) (<-chan RoomEvent,
+                                                          func() CloseReason,
+                                                          error) {
+    room, err := rm.getActiveRoom(roomId)
+
+    if err != nil {
+        return nil, nil, err
+    }
+
+    rm.readLockRoom(room, "shared")
+    defer room.roomLock.RUnlock()
+
+    if room.deleted {
+        return nil, nil, api.NotFound("No room found with id %+v", roomId)
+    }
+
+    subscriber := room.events.subscribe(room.room)
+
+    unsubscribe := func() CloseReason {
+        return room.events.unsubscribe(subscriber)
+    }
+
+    return subscriber, unsubscribe, nil
+}
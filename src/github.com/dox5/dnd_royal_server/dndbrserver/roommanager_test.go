@@ -1,14 +1,21 @@
 package main_test
 
 import (
+  "runtime"
   "testing"
+  "time"
 
   "github.com/dox5/dnd_royal_server/dndbrserver"
+  "github.com/dox5/dnd_royal_server/model"
 )
 
 func TestCreateRoomShouldStoreRoom(t *testing.T) {
     rooms := main.NewRoomManager()
-    createdRoom := rooms.Create()
+    createdRoom, err := rooms.Create(model.DefaultRoomConfig())
+
+    if err != nil {
+        t.Fatalf("Failed to create room: %s", err)
+    }
 
     if createdRoom == nil {
         t.Error("Created room should not be nil")
@@ -21,7 +28,11 @@ func TestCreateRoomShouldStoreRoom(t *testing.T) {
 
 func TestCreateRoomShouldAttachGameMaster(t *testing.T) {
     rooms := main.NewRoomManager()
-    createdRoom := rooms.Create()
+    createdRoom, err := rooms.Create(model.DefaultRoomConfig())
+
+    if err != nil {
+        t.Fatalf("Failed to create room: %s", err)
+    }
 
     if createdRoom == nil {
         t.Fatal("Created room should not be nil")
@@ -45,11 +56,512 @@ func TestGetFogForRoomThatDoesNotExistShouldReturnError(t *testing.T) {
 func TestGetFogForRoomShouldReturnFog(t *testing.T) {
     rooms := main.NewRoomManager()
 
-    room := rooms.Create()
+    room, err := rooms.Create(model.DefaultRoomConfig())
+
+    if err != nil {
+        t.Fatalf("Failed to create room: %s", err)
+    }
 
-    _, err := rooms.GetCurrentFog(room.Id())
+    _, err = rooms.GetCurrentFog(room.Id())
 
     if err != nil {
         t.Errorf("Room exists, should be able to get room. Got error %s", err)
     }
 }
+
+// Goroutines take a moment to wind down after being told to stop
+func expectGoroutineCount(t *testing.T, expected int) {
+    t.Helper()
+
+    deadline := time.Now().Add(2 * time.Second)
+    for runtime.NumGoroutine() > expected && time.Now().Before(deadline) {
+        time.Sleep(10 * time.Millisecond)
+    }
+
+    if runtime.NumGoroutine() > expected {
+        t.Errorf("Expected goroutines to return to %v but there are %v",
+                 expected,
+                 runtime.NumGoroutine())
+    }
+}
+
+func TestDeleteRoomShouldRemoveRoom(t *testing.T) {
+    rooms := main.NewRoomManager()
+    defer rooms.Shutdown()
+
+    room, err := rooms.Create(model.DefaultRoomConfig())
+
+    if err != nil {
+        t.Fatalf("Failed to create room: %s", err)
+    }
+
+    err = rooms.Delete(room.Id())
+
+    if err != nil {
+        t.Fatalf("Failed to delete room: %s", err)
+    }
+
+    if rooms.Count() != 0 {
+        t.Errorf("Expected room count to be 0 but it was %v", rooms.Count())
+    }
+
+    _, err = rooms.GetCurrentFog(room.Id())
+
+    if err == nil {
+        t.Error("Room was deleted, should get an error")
+    }
+}
+
+func TestDeleteRoomThatDoesNotExistShouldReturnError(t *testing.T) {
+    rooms := main.NewRoomManager()
+    defer rooms.Shutdown()
+
+    err := rooms.Delete(15)
+
+    if err == nil {
+        t.Error("No room exists, should get an error")
+    }
+}
+
+func TestDeleteRoomShouldStopItsGoroutine(t *testing.T) {
+    baseline := runtime.NumGoroutine()
+
+    rooms := main.NewRoomManager()
+    ids := make([]model.Identifier, 0)
+
+    for i := 0; i < 10; i += 1 {
+        room, err := rooms.Create(model.DefaultRoomConfig())
+
+        if err != nil {
+            t.Fatalf("Failed to create room: %s", err)
+        }
+
+        ids = append(ids, room.Id())
+    }
+
+    for _, roomId := range ids {
+        err := rooms.Delete(roomId)
+
+        if err != nil {
+            t.Fatalf("Failed to delete room: %s", err)
+        }
+    }
+
+    expectGoroutineCount(t, baseline)
+}
+
+func TestDeleteRoomShouldCloseSubscriptions(t *testing.T) {
+    rooms := main.NewRoomManager()
+    defer rooms.Shutdown()
+
+    room, err := rooms.Create(model.DefaultRoomConfig())
+
+    if err != nil {
+        t.Fatalf("Failed to create room: %s", err)
+    }
+
+    events, unsubscribe, err := rooms.Subscribe(room.Id())
+
+    if err != nil {
+        t.Fatalf("Failed to subscribe: %s", err)
+    }
+    defer unsubscribe()
+
+    err = rooms.Delete(room.Id())
+
+    if err != nil {
+        t.Fatalf("Failed to delete room: %s", err)
+    }
+
+    timeout := time.After(time.Second)
+    for {
+        select {
+        case _, open := <- events:
+            if !open {
+                return
+            }
+        case <- timeout:
+            t.Fatal("Expected event channel to be closed")
+        }
+    }
+}
+
+func TestShutdownShouldStopAllGoroutines(t *testing.T) {
+    baseline := runtime.NumGoroutine()
+
+    rooms := main.NewRoomManager()
+    rooms.StartReaper(time.Hour, time.Minute)
+
+    for i := 0; i < 10; i += 1 {
+        _, err := rooms.Create(model.DefaultRoomConfig())
+
+        if err != nil {
+            t.Fatalf("Failed to create room: %s", err)
+        }
+    }
+
+    rooms.Shutdown()
+
+    if rooms.Count() != 0 {
+        t.Errorf("Expected room count to be 0 but it was %v", rooms.Count())
+    }
+
+    expectGoroutineCount(t, baseline)
+}
+
+func TestReapIdleShouldOnlyDeleteIdleRooms(t *testing.T) {
+    rooms := main.NewRoomManager()
+    defer rooms.Shutdown()
+
+    for i := 0; i < 3; i += 1 {
+        _, err := rooms.Create(model.DefaultRoomConfig())
+
+        if err != nil {
+            t.Fatalf("Failed to create room: %s", err)
+        }
+    }
+
+    if reaped := rooms.ReapIdle(time.Hour); reaped != 0 {
+        t.Errorf("Rooms were just created, expected none to be reaped but %v were",
+                 reaped)
+    }
+
+    time.Sleep(20 * time.Millisecond)
+
+    if reaped := rooms.ReapIdle(10 * time.Millisecond); reaped != 3 {
+        t.Errorf("Expected all 3 rooms to be reaped but %v were", reaped)
+    }
+
+    if rooms.Count() != 0 {
+        t.Errorf("Expected room count to be 0 but it was %v", rooms.Count())
+    }
+}
+
+func TestReapIdleShouldKeepWatchedRooms(t *testing.T) {
+    rooms := main.NewRoomManager()
+    defer rooms.Shutdown()
+
+    room, err := rooms.Create(model.DefaultRoomConfig())
+
+    if err != nil {
+        t.Fatalf("Failed to create room: %s", err)
+    }
+
+    _, unsubscribe, err := rooms.Subscribe(room.Id())
+
+    if err != nil {
+        t.Fatalf("Failed to subscribe: %s", err)
+    }
+    defer unsubscribe()
+
+    time.Sleep(20 * time.Millisecond)
+
+    if reaped := rooms.ReapIdle(10 * time.Millisecond); reaped != 0 {
+        t.Errorf("Room is being watched, expected it to be kept but %v reaped",
+                 reaped)
+    }
+}
+
+func TestCreateRoomWithInvalidConfigShouldFail(t *testing.T) {
+    rooms := main.NewRoomManager()
+    defer rooms.Shutdown()
+
+    config := model.RoomConfig{Tokens: 2, Placement: "spiral"}
+    room, err := rooms.Create(config)
+
+    if err == nil || room != nil {
+        t.Error("Expected an invalid config to be rejected")
+    }
+
+    if rooms.Count() != 0 {
+        t.Errorf("Expected room count to be 0 but it was %v", rooms.Count())
+    }
+}
+
+func TestCreateShouldRespectMaxRooms(t *testing.T) {
+    settings := main.DefaultRoomSettings()
+    settings.MaxRooms = 1
+    rooms, err := main.NewConfiguredRoomManager(nil, discardLogger(), settings)
+
+    if err != nil {
+        t.Fatalf("Failed to create room manager: %s", err)
+    }
+    defer rooms.Shutdown()
+
+    _, err = rooms.Create(model.DefaultRoomConfig())
+
+    if err != nil {
+        t.Fatalf("Failed to create first room: %s", err)
+    }
+
+    _, err = rooms.Create(model.DefaultRoomConfig())
+
+    if err == nil || rooms.Count() != 1 {
+        t.Errorf("Expected second room to be refused but there are %d rooms",
+                 rooms.Count())
+    }
+}
+
+func fakeClockRoomManager(t *testing.T) (*main.RoomManager, *main.FakeClock) {
+    clock := main.NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+    settings := main.DefaultRoomSettings()
+    settings.Clock = clock
+    rooms, err := main.NewConfiguredRoomManager(nil, discardLogger(), settings)
+
+    if err != nil {
+        t.Fatalf("Failed to create room manager: %s", err)
+    }
+
+    return rooms, clock
+}
+
+// Let the scheduler update the only room count times, waiting for each update
+// to be published
+func stepRoom(t *testing.T,
+              clock *main.FakeClock,
+              events <-chan main.RoomEvent,
+              count int) {
+    period := time.Duration(float32(time.Second) / main.DefaultUpdateRateHz)
+
+    for i := 0; i < count; i += 1 {
+        clock.BlockUntil(1)
+        clock.Advance(period)
+
+        // Tokens crossing the fog come after the fog itself
+        event := nextEvent(t, events)
+        for event.Type == main.TokenEvent {
+            event = nextEvent(t, events)
+        }
+    }
+}
+
+func TestSchedulerShouldMoveFogWithTheClock(t *testing.T) {
+    rooms, clock := fakeClockRoomManager(t)
+    defer rooms.Shutdown()
+
+    config := model.DefaultRoomConfig()
+    config.Fog = model.AnyShape{Shape: model.Circle{Radius: 100}}
+    room, err := rooms.Create(config)
+
+    if err != nil {
+        t.Fatalf("Failed to create room: %s", err)
+    }
+
+    events, unsubscribe, err := rooms.Subscribe(room.Id())
+
+    if err != nil {
+        t.Fatalf("Failed to subscribe: %s", err)
+    }
+    defer unsubscribe()
+
+    err = rooms.WithExclusiveRoom(room.Id(), func(room *model.Room) error {
+        err := room.Fog().SetTarget(model.Circle{Centre: model.Vector{X: 20,
+                                                                      Y: 0},
+                                                 Radius: 50})
+
+        if err != nil {
+            return err
+        }
+
+        room.Fog().Resume()
+        return room.Fog().SetPeriod(10)
+    })
+
+    if err != nil {
+        t.Fatalf("Failed to start fog: %s", err)
+    }
+
+    // Skip the initial state and the fog starting
+    for event := nextEvent(t, events); event.Type != main.ResumedEvent; {
+        event = nextEvent(t, events)
+    }
+
+    // 4 simulated seconds of 10
+    stepRoom(t, clock, events, 8)
+    fog, err := rooms.GetCurrentFog(room.Id())
+
+    if err != nil {
+        t.Fatalf("Failed to get fog: %s", err)
+    }
+
+    expected := model.Circle{Centre: model.Vector{X: 8, Y: 0}, Radius: 80}
+
+    if fog != expected {
+        t.Errorf("Expected fog to be %+v after 4s but was %+v", expected, fog)
+    }
+
+    stepRoom(t, clock, events, 12)
+    fog, err = rooms.GetCurrentFog(room.Id())
+
+    if err != nil {
+        t.Fatalf("Failed to get fog: %s", err)
+    }
+
+    expected = model.Circle{Centre: model.Vector{X: 20, Y: 0}, Radius: 50}
+
+    if !fog.Equal(expected) {
+        t.Errorf("Expected fog to reach %+v but was %+v", expected, fog)
+    }
+
+    // Once the fog has stopped nothing should be waiting on the clock
+    clock.Advance(time.Hour)
+    scraped := scrapeMetrics(t, rooms)
+    expectMetric(t, scraped, "dndbr_scheduled_rooms 0")
+    expectMetric(t, scraped, "dndbr_advancer_ticks_total 20")
+}
+
+func TestFogLocationShouldSayWhenTheFogMoves(t *testing.T) {
+    rooms, clock := fakeClockRoomManager(t)
+    defer rooms.Shutdown()
+
+    start := clock.Now()
+    config := model.DefaultRoomConfig()
+    config.Fog = model.AnyShape{Shape: model.Circle{Radius: 100}}
+    room, err := rooms.Create(config)
+
+    if err != nil {
+        t.Fatalf("Failed to create room: %s", err)
+    }
+
+    events, unsubscribe, err := rooms.Subscribe(room.Id())
+
+    if err != nil {
+        t.Fatalf("Failed to subscribe: %s", err)
+    }
+    defer unsubscribe()
+
+    err = rooms.WithExclusiveRoom(room.Id(), func(room *model.Room) error {
+        target := model.Circle{Centre: model.Vector{X: 0, Y: 0}, Radius: 50}
+
+        if err := room.Fog().SetTarget(target); err != nil {
+            return err
+        }
+
+        room.Fog().Resume()
+        return room.Fog().SetPeriod(10)
+    })
+
+    if err != nil {
+        t.Fatalf("Failed to start fog: %s", err)
+    }
+
+    for event := nextEvent(t, events); event.Type != main.ResumedEvent; {
+        event = nextEvent(t, events)
+    }
+
+    // Part way through the move should still say when it started
+    stepRoom(t, clock, events, 4)
+    location, err := rooms.GetFogLocation(room.Id())
+
+    if err != nil {
+        t.Fatalf("Failed to get fog location: %s", err)
+    }
+
+    if !location.ServerTime.Equal(clock.Now()) {
+        t.Errorf("Expected server time %s but it was %s",
+                 clock.Now(),
+                 location.ServerTime)
+    }
+
+    if location.MoveStart == nil || !location.MoveStart.Equal(start) {
+        t.Errorf("Expected the move to start at %s but it was %v",
+                 start,
+                 location.MoveStart)
+    }
+
+    end := start.Add(10 * time.Second)
+    if location.MoveEnd == nil || !location.MoveEnd.Equal(end) {
+        t.Errorf("Expected the move to end at %s but it was %v",
+                 end,
+                 location.MoveEnd)
+    }
+
+    err = rooms.WithExclusiveRoom(room.Id(), func(room *model.Room) error {
+        room.Fog().Pause()
+        return nil
+    })
+
+    if err != nil {
+        t.Fatalf("Failed to pause fog: %s", err)
+    }
+
+    state, err := rooms.GetRoomState(room.Id())
+
+    if err != nil {
+        t.Fatalf("Failed to get room state: %s", err)
+    }
+
+    if !state.Fog.Paused || state.Fog.MoveStart != nil {
+        t.Errorf("Expected a paused fog without move times but got %+v",
+                 state.Fog)
+    }
+}
+
+func TestOnlyRoomsWithMovingFogShouldBeScheduled(t *testing.T) {
+    rooms, _ := fakeClockRoomManager(t)
+    defer rooms.Shutdown()
+
+    room, err := rooms.Create(model.DefaultRoomConfig())
+
+    if err != nil {
+        t.Fatalf("Failed to create room: %s", err)
+    }
+
+    expectMetric(t, scrapeMetrics(t, rooms), "dndbr_scheduled_rooms 0")
+
+    err = rooms.WithExclusiveRoom(room.Id(), func(room *model.Room) error {
+        room.Fog().Resume()
+        return nil
+    })
+
+    if err != nil {
+        t.Fatalf("Failed to resume fog: %s", err)
+    }
+
+    expectMetric(t, scrapeMetrics(t, rooms), "dndbr_scheduled_rooms 1")
+
+    err = rooms.Delete(room.Id())
+
+    if err != nil {
+        t.Fatalf("Failed to delete room: %s", err)
+    }
+
+    expectMetric(t, scrapeMetrics(t, rooms), "dndbr_scheduled_rooms 0")
+}
+
+func TestReapIdleShouldUseTheClock(t *testing.T) {
+    rooms, clock := fakeClockRoomManager(t)
+    defer rooms.Shutdown()
+
+    room, err := rooms.Create(model.DefaultRoomConfig())
+
+    if err != nil {
+        t.Fatalf("Failed to create room: %s", err)
+    }
+
+    clock.Advance(59 * time.Minute)
+
+    if reaped := rooms.ReapIdle(time.Hour); reaped != 0 {
+        t.Errorf("Expected room to be kept before the timeout but %v reaped",
+                 reaped)
+    }
+
+    // Looking at the room resets its idle time
+    _, err = rooms.GetCurrentFog(room.Id())
+
+    if err != nil {
+        t.Fatalf("Failed to get fog: %s", err)
+    }
+
+    clock.Advance(59 * time.Minute)
+
+    if reaped := rooms.ReapIdle(time.Hour); reaped != 0 {
+        t.Errorf("Expected touched room to be kept but %v reaped", reaped)
+    }
+
+    clock.Advance(time.Minute)
+
+    if reaped := rooms.ReapIdle(time.Hour); reaped != 1 {
+        t.Errorf("Expected idle room to be reaped but %v were", reaped)
+    }
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "io/ioutil"
+    "log/slog"
+    "os"
+    "path/filepath"
+    "strings"
+
+    "github.com/dox5/dnd_royal_server/model"
+)
+
+// Somewhere to keep rooms so they survive a server restart
+type RoomStore interface {
+    Save(snapshot model.RoomSnapshot) error
+    Delete(roomId model.Identifier) error
+    LoadAll() ([]model.RoomSnapshot, error)
+}
+
+// Stores each room as a JSON file named after the room id
+type FileRoomStore struct {
+    directory string
+    logger *slog.Logger
+}
+
+const (
+    roomFileExtension = ".json"
+    // Added to room files that can't be loaded, so they are kept to look at
+    // but not tried again
+    corruptRoomExtension = ".corrupt"
+)
+
+func NewFileRoomStore(directory string,
+                      logger *slog.Logger) (*FileRoomStore, error) {
+    err := os.MkdirAll(directory, 0755)
+
+    if err != nil {
+        return nil, fmt.Errorf("Failed to create room store directory: %s", err)
+    }
+
+    return &FileRoomStore{directory: directory, logger: logger}, nil
+}
+
+func (store *FileRoomStore) roomPath(roomId model.Identifier) string {
+    return filepath.Join(store.directory,
+                         fmt.Sprintf("%d%s", roomId, roomFileExtension))
+}
+
+func (store *FileRoomStore) Save(snapshot model.RoomSnapshot) error {
+    encoded, err := json.Marshal(snapshot)
+
+    if err != nil {
+        return fmt.Errorf("Failed to encode room %v: %s", snapshot.Id, err)
+    }
+
+    err = writeFileAtomic(store.roomPath(snapshot.Id), encoded)
+
+    if err != nil {
+        return fmt.Errorf("Failed to save room %v: %s", snapshot.Id, err)
+    }
+
+    return nil
+}
+
+func (store *FileRoomStore) Delete(roomId model.Identifier) error {
+    err := os.Remove(store.roomPath(roomId))
+
+    if err != nil && !os.IsNotExist(err) {
+        return fmt.Errorf("Failed to delete room %v: %s", roomId, err)
+    }
+
+    return nil
+}
+
+func (store *FileRoomStore) LoadAll() ([]model.RoomSnapshot, error) {
+    entries, err := ioutil.ReadDir(store.directory)
+
+    if err != nil {
+        return nil, fmt.Errorf("Failed to list room store: %s", err)
+    }
+
+    snapshots := make([]model.RoomSnapshot, 0, len(entries))
+
+    for _, entry := range entries {
+        name := entry.Name()
+
+        if entry.IsDir() ||
+           strings.HasPrefix(name, ".") ||
+           !strings.HasSuffix(name, roomFileExtension) {
+            continue
+        }
+
+        snapshot, err := store.load(name)
+
+        // One bad room shouldn't keep all the others down
+        if err != nil {
+            store.setAside(name, err)
+            continue
+        }
+
+        snapshots = append(snapshots, snapshot)
+    }
+
+    return snapshots, nil
+}
+
+func (store *FileRoomStore) load(name string) (model.RoomSnapshot, error) {
+    var snapshot model.RoomSnapshot
+    encoded, err := ioutil.ReadFile(filepath.Join(store.directory, name))
+
+    if err != nil {
+        return snapshot, fmt.Errorf("Failed to read room file: %s", err)
+    }
+
+    err = json.Unmarshal(encoded, &snapshot)
+
+    if err != nil {
+        return snapshot, fmt.Errorf("Failed to decode room file: %s", err)
+    }
+
+    return snapshot, nil
+}
+
+func (store *FileRoomStore) setAside(name string, loadErr error) {
+    path := filepath.Join(store.directory, name)
+    err := os.Rename(path, path + corruptRoomExtension)
+
+    if err != nil {
+        store.logger.Error("Failed to set aside room file",
+                           "file", name,
+                           "error", err)
+    }
+
+    store.logger.Error("Skipped room that couldn't be loaded",
+                       "file", name,
+                       "error", loadErr)
+}
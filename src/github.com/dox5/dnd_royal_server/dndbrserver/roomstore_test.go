@@ -0,0 +1,221 @@
+package main_test
+
+import (
+  "errors"
+  "io/ioutil"
+  "log/slog"
+  "os"
+  "path/filepath"
+  "testing"
+
+  "github.com/dox5/dnd_royal_server/dndbrserver"
+  "github.com/dox5/dnd_royal_server/model"
+)
+
+func discardLogger() *slog.Logger {
+    return slog.New(slog.DiscardHandler)
+}
+
+func TestRoomsShouldSurviveRestart(t *testing.T) {
+    store, err := main.NewFileRoomStore(t.TempDir(), discardLogger())
+
+    if err != nil {
+        t.Fatalf("Failed to create store: %s", err)
+    }
+
+    before, err := main.NewPersistentRoomManager(store, discardLogger())
+
+    if err != nil {
+        t.Fatalf("Failed to create room manager: %s", err)
+    }
+
+    room, err := before.Create(model.DefaultRoomConfig())
+
+    if err != nil {
+        t.Fatalf("Failed to create room: %s", err)
+    }
+
+    target := model.Circle{Centre: model.Vector{X: 5, Y: 5}, Radius: 20}
+    err = before.WithExclusiveRoom(room.Id(), func(room *model.Room) error {
+        room.Fog().SetTarget(target)
+        return nil
+    })
+
+    if err != nil {
+        t.Fatalf("Failed to update room: %s", err)
+    }
+    before.Flush()
+
+    after, err := main.NewPersistentRoomManager(store, discardLogger())
+
+    if err != nil {
+        t.Fatalf("Failed to restore room manager: %s", err)
+    }
+
+    if after.Count() != 1 {
+        t.Fatalf("Expected 1 room to be restored but there were %v",
+                 after.Count())
+    }
+
+    err = after.WithSharedRoom(room.Id(), func(restored *model.Room) error {
+        if restored.GameMaster().Id() != room.GameMaster().Id() {
+            t.Errorf("Expected game master %v but got %v",
+                     room.GameMaster().Id(),
+                     restored.GameMaster().Id())
+        }
+
+        if restored.Fog().Target() != target {
+            t.Errorf("Expected fog target %+v but got %+v",
+                     target,
+                     restored.Fog().Target())
+        }
+        return nil
+    })
+
+    if err != nil {
+        t.Errorf("Restored room should be found by its old id: %s", err)
+    }
+}
+
+func TestEmptyStoreShouldRestoreNoRooms(t *testing.T) {
+    store, err := main.NewFileRoomStore(t.TempDir(), discardLogger())
+
+    if err != nil {
+        t.Fatalf("Failed to create store: %s", err)
+    }
+
+    rooms, err := main.NewPersistentRoomManager(store, discardLogger())
+
+    if err != nil {
+        t.Fatalf("Failed to create room manager: %s", err)
+    }
+
+    if rooms.Count() != 0 {
+        t.Errorf("Expected no rooms but there were %v", rooms.Count())
+    }
+}
+
+func TestDeletedRoomsShouldNotBeRestored(t *testing.T) {
+    store, err := main.NewFileRoomStore(t.TempDir(), discardLogger())
+
+    if err != nil {
+        t.Fatalf("Failed to create store: %s", err)
+    }
+
+    before, err := main.NewPersistentRoomManager(store, discardLogger())
+
+    if err != nil {
+        t.Fatalf("Failed to create room manager: %s", err)
+    }
+    defer before.Shutdown()
+
+    room, err := before.Create(model.DefaultRoomConfig())
+
+    if err != nil {
+        t.Fatalf("Failed to create room: %s", err)
+    }
+
+    err = before.Delete(room.Id())
+
+    if err != nil {
+        t.Fatalf("Failed to delete room: %s", err)
+    }
+
+    after, err := main.NewPersistentRoomManager(store, discardLogger())
+
+    if err != nil {
+        t.Fatalf("Failed to restore room manager: %s", err)
+    }
+    defer after.Shutdown()
+
+    if after.Count() != 0 {
+        t.Errorf("Expected no rooms to be restored but there were %v",
+                 after.Count())
+    }
+}
+
+func TestCorruptRoomFileShouldNotStopOtherRooms(t *testing.T) {
+    directory := t.TempDir()
+    store, err := main.NewFileRoomStore(directory, discardLogger())
+
+    if err != nil {
+        t.Fatalf("Failed to create store: %s", err)
+    }
+
+    before, err := main.NewPersistentRoomManager(store, discardLogger())
+
+    if err != nil {
+        t.Fatalf("Failed to create room manager: %s", err)
+    }
+    defer before.Shutdown()
+
+    room, err := before.Create(model.DefaultRoomConfig())
+
+    if err != nil {
+        t.Fatalf("Failed to create room: %s", err)
+    }
+    before.Flush()
+
+    corrupt := filepath.Join(directory, "12345.json")
+    err = ioutil.WriteFile(corrupt, []byte(`{"Id": "12`), 0644)
+
+    if err != nil {
+        t.Fatalf("Failed to write corrupt room: %s", err)
+    }
+
+    after, err := main.NewPersistentRoomManager(store, discardLogger())
+
+    if err != nil {
+        t.Fatalf("Expected the corrupt room to be skipped but got %s", err)
+    }
+    defer after.Shutdown()
+
+    if _, err := after.GetCurrentFog(room.Id()); err != nil {
+        t.Errorf("Expected the good room to be restored: %s", err)
+    }
+
+    if _, err := os.Stat(corrupt + ".corrupt"); err != nil {
+        t.Errorf("Expected the corrupt room to be set aside: %s", err)
+    }
+}
+
+// Refuses to save anything
+type failingStore struct {}
+
+func (failingStore) Save(snapshot model.RoomSnapshot) error {
+    return errors.New("disk full")
+}
+
+func (failingStore) Delete(roomId model.Identifier) error {
+    return nil
+}
+
+func (failingStore) LoadAll() ([]model.RoomSnapshot, error) {
+    return nil, nil
+}
+
+func TestFailedSaveShouldNotFailTheChange(t *testing.T) {
+    rooms, err := main.NewPersistentRoomManager(failingStore{}, discardLogger())
+
+    if err != nil {
+        t.Fatalf("Failed to create room manager: %s", err)
+    }
+    defer rooms.Shutdown()
+
+    room, err := rooms.Create(model.DefaultRoomConfig())
+
+    if err != nil {
+        t.Fatalf("Expected the room to be created but got %s", err)
+    }
+
+    target := model.Circle{Centre: model.Vector{X: 5, Y: 5}, Radius: 20}
+    err = rooms.WithExclusiveRoom(room.Id(), func(room *model.Room) error {
+        room.Fog().SetTarget(target)
+        return nil
+    })
+
+    if err != nil {
+        t.Errorf("Expected the change to stand but got %s", err)
+    }
+    rooms.Flush()
+}
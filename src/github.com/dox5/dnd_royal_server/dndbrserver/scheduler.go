@@ -0,0 +1,225 @@
+package main
+
+import (
+    "container/heap"
+    "sync"
+    "time"
+)
+
+// A room waiting for its next update
+type scheduledRoom struct {
+    room *activeRoom
+    due time.Time
+    // Position in the queue, -1 while being updated
+    index int
+
+    lastUpdate time.Time
+    lastPersisted time.Time
+    // Time since lastUpdate not yet given to the room, updates are always a
+    // whole period
+    accumulator time.Duration
+}
+
+// Soonest due first
+type dueQueue []*scheduledRoom
+
+func (q dueQueue) Len() int {
+    return len(q)
+}
+
+func (q dueQueue) Less(i, j int) bool {
+    return q[i].due.Before(q[j].due)
+}
+
+func (q dueQueue) Swap(i, j int) {
+    q[i], q[j] = q[j], q[i]
+    q[i].index = i
+    q[j].index = j
+}
+
+func (q *dueQueue) Push(x interface{}) {
+    entry := x.(*scheduledRoom)
+    entry.index = len(*q)
+    *q = append(*q, entry)
+}
+
+func (q *dueQueue) Pop() interface{} {
+    old := *q
+    entry := old[len(old) - 1]
+    old[len(old) - 1] = nil
+    entry.index = -1
+    *q = old[:len(old) - 1]
+    return entry
+}
+
+// Updates every active room once a period from a single goroutine, which
+// sleeps until the next room is due and only runs while there is a room to
+// update. Rooms drop out once they go quiet and have to be scheduled again.
+//
+// Locking: schedule, finish and remove are called with the room's lock held,
+// the scheduler never takes a room lock while holding its own.
+type roomScheduler struct {
+    clock Clock
+    period time.Duration
+    // Called for each room when it is due, must call finish
+    update func(entry *scheduledRoom, now time.Time)
+
+    lock sync.Mutex
+    queue dueQueue
+    // Every room that is queued or being updated
+    entries map[*activeRoom]*scheduledRoom
+    running bool
+    stopped bool
+    stop chan struct{}
+    done sync.WaitGroup
+}
+
+func newRoomScheduler(clock Clock,
+                      period time.Duration,
+                      update func(*scheduledRoom, time.Time)) *roomScheduler {
+    return &roomScheduler{clock: clock,
+                          period: period,
+                          update: update,
+                          entries: make(map[*activeRoom]*scheduledRoom),
+                          stop: make(chan struct{})}
+}
+
+// Start updating room, if it isn't already. Says whether it wasn't.
+func (s *roomScheduler) schedule(room *activeRoom) bool {
+    s.lock.Lock()
+    defer s.lock.Unlock()
+
+    if s.stopped || s.entries[room] != nil {
+        return false
+    }
+
+    now := s.clock.Now()
+    entry := &scheduledRoom{room: room,
+                            due: now.Add(s.period),
+                            lastUpdate: now,
+                            lastPersisted: now}
+    s.entries[room] = entry
+    heap.Push(&s.queue, entry)
+
+    // Every period is the same so a new room is never due before the rooms
+    // already queued, no need to wake the loop if it's running
+    if !s.running {
+        s.running = true
+        s.done.Add(1)
+        go s.run()
+    }
+
+    return true
+}
+
+// Put the room back in the queue if it is still active, otherwise drop it
+func (s *roomScheduler) finish(entry *scheduledRoom,
+                               active bool,
+                               now time.Time) {
+    s.lock.Lock()
+    defer s.lock.Unlock()
+
+    if s.entries[entry.room] != entry {
+        return
+    }
+
+    if !active || s.stopped {
+        delete(s.entries, entry.room)
+        return
+    }
+
+    entry.due = entry.due.Add(s.period)
+
+    // Don't try and catch up on missed updates one after another, the
+    // accumulator makes up the time in the next update
+    if !entry.due.After(now) {
+        entry.due = now.Add(s.period)
+    }
+
+    heap.Push(&s.queue, entry)
+}
+
+func (s *roomScheduler) remove(room *activeRoom) {
+    s.lock.Lock()
+    defer s.lock.Unlock()
+
+    entry := s.entries[room]
+
+    if entry == nil {
+        return
+    }
+
+    if entry.index >= 0 {
+        heap.Remove(&s.queue, entry.index)
+    }
+    delete(s.entries, room)
+}
+
+// How many rooms are being updated
+func (s *roomScheduler) count() int {
+    s.lock.Lock()
+    defer s.lock.Unlock()
+    return len(s.entries)
+}
+
+// Take every room that is due off the queue, or say how long until one is
+func (s *roomScheduler) takeDue(now time.Time) ([]*scheduledRoom,
+                                                 time.Duration,
+                                                 bool) {
+    s.lock.Lock()
+    defer s.lock.Unlock()
+
+    if s.stopped || len(s.queue) == 0 {
+        s.running = false
+        return nil, 0, false
+    }
+
+    if wait := s.queue[0].due.Sub(now); wait > 0 {
+        return nil, wait, true
+    }
+
+    due := make([]*scheduledRoom, 0)
+    for len(s.queue) > 0 && !s.queue[0].due.After(now) {
+        due = append(due, heap.Pop(&s.queue).(*scheduledRoom))
+    }
+
+    return due, 0, true
+}
+
+func (s *roomScheduler) run() {
+    defer s.done.Done()
+
+    for {
+        now := s.clock.Now()
+        due, wait, running := s.takeDue(now)
+
+        if !running {
+            return
+        }
+
+        if wait > 0 {
+            select {
+            case <- s.stop:
+            case <- s.clock.After(wait):
+            }
+            continue
+        }
+
+        for _, entry := range due {
+            s.update(entry, now)
+        }
+    }
+}
+
+// Stop updating rooms, waiting for any update in progress. Must not be called
+// with a room lock held.
+func (s *roomScheduler) shutdown() {
+    s.lock.Lock()
+    if !s.stopped {
+        s.stopped = true
+        close(s.stop)
+    }
+    s.lock.Unlock()
+
+    s.done.Wait()
+}
@@ -0,0 +1,182 @@
+//go:build unix
+
+package main_test
+
+import (
+  "sync"
+  "syscall"
+  "testing"
+  "time"
+
+  "github.com/dox5/dnd_royal_server/dndbrserver"
+  "github.com/dox5/dnd_royal_server/model"
+)
+
+const (
+    benchRooms = 1000
+    // Wall time each benchmark op covers
+    benchWindow = 10 * time.Millisecond
+)
+
+func cpuTime(b *testing.B) time.Duration {
+    var usage syscall.Rusage
+
+    if err := syscall.Getrusage(syscall.RUSAGE_SELF, &usage); err != nil {
+        b.Fatalf("Failed to get CPU usage: %s", err)
+    }
+
+    return time.Duration(usage.Utime.Nano() + usage.Stime.Nano())
+}
+
+// Let the rooms run for b.N windows and report the CPU used per second
+func measureCPU(b *testing.B) {
+    b.ResetTimer()
+    startCPU := cpuTime(b)
+    start := time.Now()
+
+    for i := 0; i < b.N; i += 1 {
+        time.Sleep(benchWindow)
+    }
+
+    used := float64(cpuTime(b) - startCPU) / float64(time.Millisecond)
+    b.ReportMetric(used / time.Since(start).Seconds(), "cpu-ms/s")
+}
+
+// Give the fog somewhere to go that it won't reach during the benchmark
+func startFog(b *testing.B, room *model.Room) {
+    err := room.Fog().SetTarget(model.Circle{Centre: room.Fog().Current().Centroid(),
+                                             Radius: 1})
+
+    if err == nil {
+        err = room.Fog().SetPeriod(3600)
+    }
+
+    if err != nil {
+        b.Fatalf("Failed to start fog: %s", err)
+    }
+
+    room.Fog().Resume()
+}
+
+func createBenchRooms(b *testing.B, rooms *main.RoomManager) []*model.Room {
+    created := make([]*model.Room, 0, benchRooms)
+
+    for i := 0; i < benchRooms; i += 1 {
+        room, err := rooms.Create(model.DefaultRoomConfig())
+
+        if err != nil {
+            b.Fatalf("Failed to create room: %s", err)
+        }
+        created = append(created, room)
+    }
+
+    return created
+}
+
+func benchmarkScheduler(b *testing.B, moving int) {
+    rooms := main.NewRoomManager()
+    defer rooms.Shutdown()
+
+    for i, room := range createBenchRooms(b, rooms) {
+        if i >= moving {
+            break
+        }
+
+        err := rooms.WithExclusiveRoom(room.Id(), func(room *model.Room) error {
+            startFog(b, room)
+            return nil
+        })
+
+        if err != nil {
+            b.Fatalf("Failed to start fog: %s", err)
+        }
+    }
+
+    measureCPU(b)
+}
+
+// How rooms were updated before the scheduler, a goroutine per room waking
+// ten times a period whether or not the fog was moving. The loop is the old
+// roomAdvancer as it was, taking the room's own lock.
+func benchmarkPolling(b *testing.B, moving int) {
+    rooms := main.NewRoomManager()
+    defer rooms.Shutdown()
+
+    updateRate := main.DefaultUpdateRateHz
+    stop := make(chan struct{})
+    var done sync.WaitGroup
+
+    for i, room := range createBenchRooms(b, rooms) {
+        // Started behind the manager's back so only the replica updates it
+        if i < moving {
+            startFog(b, room)
+        }
+
+        roomLock, err := rooms.RoomLock(room.Id())
+
+        if err != nil {
+            b.Fatalf("Failed to get room lock: %s", err)
+        }
+
+        done.Add(1)
+
+        go func(room *model.Room) {
+            defer done.Done()
+
+            accumulator := float64(0)
+            periodSeconds := float64(float32(1) / updateRate)
+
+            for {
+                updateStart := time.Now()
+
+                select {
+                case <- stop:
+                    return
+                default:
+                }
+
+                roomLock.Lock()
+                for ; accumulator > periodSeconds ; accumulator -= periodSeconds {
+                    room.Update(float32(periodSeconds))
+                }
+                roomLock.Unlock()
+
+                sleepFor := (periodSeconds / 10.0) * float64(time.Second)
+                time.Sleep(time.Duration(sleepFor))
+
+                updateEnd := time.Now()
+
+                updateDuration := updateEnd.Sub(updateStart)
+                accumulator += updateDuration.Seconds()
+            }
+        }(room)
+    }
+
+    measureCPU(b)
+    close(stop)
+    done.Wait()
+}
+
+func BenchmarkIdleRoomsScheduler(b *testing.B) {
+    benchmarkScheduler(b, 0)
+}
+
+func BenchmarkIdleRoomsPolling(b *testing.B) {
+    benchmarkPolling(b, 0)
+}
+
+func BenchmarkFewMovingRoomsScheduler(b *testing.B) {
+    benchmarkScheduler(b, benchRooms / 20)
+}
+
+func BenchmarkFewMovingRoomsPolling(b *testing.B) {
+    benchmarkPolling(b, benchRooms / 20)
+}
+
+func BenchmarkAllMovingRoomsScheduler(b *testing.B) {
+    benchmarkScheduler(b, benchRooms)
+}
+
+func BenchmarkAllMovingRoomsPolling(b *testing.B) {
+    benchmarkPolling(b, benchRooms)
+}
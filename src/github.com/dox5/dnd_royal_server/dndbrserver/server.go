@@ -0,0 +1,44 @@
+package main
+
+import (
+    "crypto/tls"
+    "log/slog"
+    "net/http"
+    "time"
+)
+
+// An http.Server with the configured limits. With TLS enabled the
+// certificate comes from the returned reloader, which the caller must Stop;
+// serve with ListenAndServeTLS("", "").
+func NewServer(config Config,
+               handler http.Handler,
+               logger *slog.Logger) (*http.Server, *CertReloader, error) {
+    server := &http.Server{
+        Addr: config.ListenAddress,
+        Handler: handler,
+        ReadHeaderTimeout: time.Duration(config.ReadHeaderTimeout),
+        ReadTimeout: time.Duration(config.ReadTimeout),
+        WriteTimeout: time.Duration(config.WriteTimeout),
+        IdleTimeout: time.Duration(config.IdleTimeout),
+        MaxHeaderBytes: config.MaxHeaderBytes,
+        ErrorLog: slog.NewLogLogger(logger.Handler(), slog.LevelWarn)}
+
+    if !config.TLSEnabled() {
+        return server, nil, nil
+    }
+
+    certs, err := NewCertReloader(config.TLSCertFile, config.TLSKeyFile, logger)
+
+    if err != nil {
+        return nil, nil, err
+    }
+
+    if period := time.Duration(config.CertCheckPeriod); period > 0 {
+        certs.Watch(period)
+    }
+
+    server.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12,
+                                   GetCertificate: certs.GetCertificate}
+
+    return server, certs, nil
+}
@@ -0,0 +1,15 @@
+package main
+
+import (
+    "net/http"
+
+    "github.com/dox5/dnd_royal_server/api"
+)
+
+// Lets clients work out how far their clock is from the server's, which the
+// times in fog locations are given by
+func ServerTime(clock Clock) EndpointHandler {
+    return func(request *http.Request) (interface{}, error) {
+        return api.TimeResponse{ServerTime: clock.Now().UTC()}, nil
+    }
+}
@@ -0,0 +1,165 @@
+// Counters, gauges and histograms that can be scraped in the Prometheus text
+// format. Only what the server needs, not a full client library.
+package metrics
+
+import (
+    "math"
+    "sort"
+    "sync"
+    "sync/atomic"
+)
+
+// Upper bounds in seconds, good for anything from a lock wait to a slow
+// request
+var DefaultBuckets = []float64{0.0001, 0.0005, 0.001, 0.005, 0.01, 0.025,
+                               0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// A float64 that can be updated from many goroutines
+type atomicFloat struct {
+    bits uint64
+}
+
+func (f *atomicFloat) load() float64 {
+    return math.Float64frombits(atomic.LoadUint64(&f.bits))
+}
+
+func (f *atomicFloat) store(value float64) {
+    atomic.StoreUint64(&f.bits, math.Float64bits(value))
+}
+
+func (f *atomicFloat) add(delta float64) {
+    for {
+        old := atomic.LoadUint64(&f.bits)
+        updated := math.Float64bits(math.Float64frombits(old) + delta)
+
+        if atomic.CompareAndSwapUint64(&f.bits, old, updated) {
+            return
+        }
+    }
+}
+
+// Only ever goes up
+type Counter struct {
+    value atomicFloat
+}
+
+func (c *Counter) Inc() {
+    c.value.add(1)
+}
+
+// Negative amounts are ignored, counters can't go down
+func (c *Counter) Add(amount float64) {
+    if amount > 0 {
+        c.value.add(amount)
+    }
+}
+
+func (c *Counter) Value() float64 {
+    return c.value.load()
+}
+
+type Gauge struct {
+    value atomicFloat
+}
+
+func (g *Gauge) Set(value float64) {
+    g.value.store(value)
+}
+
+func (g *Gauge) Add(delta float64) {
+    g.value.add(delta)
+}
+
+func (g *Gauge) Value() float64 {
+    return g.value.load()
+}
+
+// Counts observations into buckets by upper bound
+type Histogram struct {
+    lock sync.Mutex
+    bounds []float64
+    // counts[i] is observations in (bounds[i-1], bounds[i]], the last is
+    // everything above the largest bound
+    counts []uint64
+    sum float64
+    count uint64
+}
+
+func newHistogram(bounds []float64) *Histogram {
+    return &Histogram{bounds: bounds, counts: make([]uint64, len(bounds) + 1)}
+}
+
+func (h *Histogram) Observe(value float64) {
+    bucket := sort.SearchFloat64s(h.bounds, value)
+
+    h.lock.Lock()
+    defer h.lock.Unlock()
+
+    h.counts[bucket] += 1
+    h.sum += value
+    h.count += 1
+}
+
+type histogramSnapshot struct {
+    bounds []float64
+    // Cumulative, the last is the +Inf bucket and equal to count
+    cumulative []uint64
+    sum float64
+    count uint64
+}
+
+func (h *Histogram) snapshot() histogramSnapshot {
+    h.lock.Lock()
+    defer h.lock.Unlock()
+
+    snapshot := histogramSnapshot{bounds: h.bounds,
+                                  cumulative: make([]uint64, len(h.counts)),
+                                  sum: h.sum,
+                                  count: h.count}
+
+    total := uint64(0)
+    for i, count := range h.counts {
+        total += count
+        snapshot.cumulative[i] = total
+    }
+
+    return snapshot
+}
+
+func (h *Histogram) Count() uint64 {
+    h.lock.Lock()
+    defer h.lock.Unlock()
+    return h.count
+}
+
+// Metrics of one name, one per combination of label values
+type CounterVec struct {
+    family *family
+}
+
+func (v *CounterVec) With(labelValues ...string) *Counter {
+    return v.family.child(labelValues, func() interface{} {
+        return &Counter{}
+    }).(*Counter)
+}
+
+type GaugeVec struct {
+    family *family
+}
+
+func (v *GaugeVec) With(labelValues ...string) *Gauge {
+    return v.family.child(labelValues, func() interface{} {
+        return &Gauge{}
+    }).(*Gauge)
+}
+
+type HistogramVec struct {
+    family *family
+    bounds []float64
+}
+
+func (v *HistogramVec) With(labelValues ...string) *Histogram {
+    return v.family.child(labelValues, func() interface{} {
+        return newHistogram(v.bounds)
+    }).(*Histogram)
+}
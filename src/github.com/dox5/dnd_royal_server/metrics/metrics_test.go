@@ -0,0 +1,132 @@
+package metrics_test
+
+import (
+    "bytes"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+
+    "github.com/dox5/dnd_royal_server/metrics"
+)
+
+func scrape(t *testing.T, registry *metrics.Registry) string {
+    recorder := httptest.NewRecorder()
+    registry.ServeHTTP(recorder,
+                       httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+    if recorder.Code != http.StatusOK {
+        t.Fatalf("Expected status %d but got %d", http.StatusOK, recorder.Code)
+    }
+
+    if recorder.Header().Get("Content-Type") != metrics.ContentType {
+        t.Errorf("Expected content type %q but got %q",
+                 metrics.ContentType,
+                 recorder.Header().Get("Content-Type"))
+    }
+
+    return recorder.Body.String()
+}
+
+func expectLines(t *testing.T, text string, lines ...string) {
+    for _, line := range lines {
+        if !strings.Contains(text, line + "\n") {
+            t.Errorf("Expected line %q in:\n%s", line, text)
+        }
+    }
+}
+
+func TestCountersAndGaugesShouldBeWrittenWithLabels(t *testing.T) {
+    registry := metrics.NewRegistry()
+    requests := registry.NewCounter("requests_total", "Requests", "path")
+    temperature := registry.NewGauge("temperature", "How hot")
+
+    requests.With("/a").Inc()
+    requests.With("/a").Add(2)
+    requests.With("/a").Add(-5)
+    requests.With("say \"hi\"\n").Inc()
+    temperature.With().Set(-1.5)
+
+    expectLines(t,
+                scrape(t, registry),
+                "# HELP requests_total Requests",
+                "# TYPE requests_total counter",
+                `requests_total{path="/a"} 3`,
+                `requests_total{path="say \"hi\"\n"} 1`,
+                "# TYPE temperature gauge",
+                "temperature -1.5")
+}
+
+func TestHistogramBucketsShouldBeCumulative(t *testing.T) {
+    registry := metrics.NewRegistry()
+    latency := registry.NewHistogram("latency_seconds",
+                                     "Latency",
+                                     []float64{0.1, 1},
+                                     "route").With("/x")
+
+    for _, value := range []float64{0.05, 0.1, 0.5, 3} {
+        latency.Observe(value)
+    }
+
+    expectLines(t,
+                scrape(t, registry),
+                "# TYPE latency_seconds histogram",
+                `latency_seconds_bucket{route="/x",le="0.1"} 2`,
+                `latency_seconds_bucket{route="/x",le="1"} 3`,
+                `latency_seconds_bucket{route="/x",le="+Inf"} 4`,
+                `latency_seconds_sum{route="/x"} 3.65`,
+                `latency_seconds_count{route="/x"} 4`)
+}
+
+func TestGaugeFuncsShouldBeCollectedWhenScraped(t *testing.T) {
+    registry := metrics.NewRegistry()
+    value := 1.0
+
+    registry.NewGaugeFunc("things",
+                          "Things",
+                          []string{"kind"},
+                          func(emit func(float64, ...string)) {
+        emit(value, "b")
+        emit(2 * value, "a")
+    })
+
+    value = 4
+    text := scrape(t, registry)
+    expectLines(t, text, `things{kind="a"} 8`, `things{kind="b"} 4`)
+
+    if strings.Index(text, `kind="a"`) > strings.Index(text, `kind="b"`) {
+        t.Errorf("Expected samples sorted by label but got:\n%s", text)
+    }
+}
+
+func TestDuplicateMetricsShouldPanic(t *testing.T) {
+    registry := metrics.NewRegistry()
+    registry.NewCounter("dupe", "First")
+
+    defer func() {
+        if recover() == nil {
+            t.Error("Expected registering a metric twice to panic")
+        }
+    }()
+
+    registry.NewGauge("dupe", "Second")
+}
+
+func TestWriteTextShouldSortByName(t *testing.T) {
+    registry := metrics.NewRegistry()
+    registry.NewCounter("b_total", "B").With().Inc()
+    registry.NewCounter("a_total", "A").With().Inc()
+
+    var out bytes.Buffer
+    err := registry.WriteText(&out)
+
+    if err != nil {
+        t.Fatalf("Failed to write metrics: %s", err)
+    }
+
+    text := out.String()
+
+    if strings.Index(text, "a_total") > strings.Index(text, "b_total") {
+        t.Errorf("Expected metrics sorted by name but got:\n%s", text)
+    }
+}
@@ -0,0 +1,286 @@
+package metrics
+
+import (
+    "bufio"
+    "fmt"
+    "io"
+    "math"
+    "net/http"
+    "regexp"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+)
+
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+var namePattern = regexp.MustCompile("^[a-zA-Z_:][a-zA-Z0-9_:]*$")
+var labelPattern = regexp.MustCompile("^[a-zA-Z_][a-zA-Z0-9_]*$")
+
+// Called at scrape time to report the current values, once per set of labels
+type CollectFunc func(emit func(value float64, labelValues ...string))
+
+type family struct {
+    name string
+    help string
+    kind string
+    labelNames []string
+
+    lock sync.Mutex
+    children map[string]interface{}
+    labelValues map[string][]string
+
+    collect CollectFunc
+}
+
+// Label values can't contain this so it is safe to join them with
+const labelSeparator = "\xff"
+
+func (f *family) child(labelValues []string,
+                       create func() interface{}) interface{} {
+    if len(labelValues) != len(f.labelNames) {
+        panic(fmt.Sprintf("%s has labels %v but was given %d values",
+                          f.name,
+                          f.labelNames,
+                          len(labelValues)))
+    }
+
+    key := strings.Join(labelValues, labelSeparator)
+
+    f.lock.Lock()
+    defer f.lock.Unlock()
+
+    child, found := f.children[key]
+
+    if !found {
+        child = create()
+        f.children[key] = child
+        f.labelValues[key] = append([]string{}, labelValues...)
+    }
+
+    return child
+}
+
+// Holds a set of metrics and writes them out for scraping. Creating two
+// metrics with the same name is a programming error and panics.
+type Registry struct {
+    lock sync.Mutex
+    families map[string]*family
+}
+
+func NewRegistry() *Registry {
+    return &Registry{families: make(map[string]*family)}
+}
+
+func (r *Registry) register(name string,
+                            help string,
+                            kind string,
+                            labelNames []string) *family {
+    if !namePattern.MatchString(name) {
+        panic(fmt.Sprintf("Invalid metric name %q", name))
+    }
+
+    for _, label := range labelNames {
+        if !labelPattern.MatchString(label) || label == "le" {
+            panic(fmt.Sprintf("Invalid label name %q for %s", label, name))
+        }
+    }
+
+    r.lock.Lock()
+    defer r.lock.Unlock()
+
+    if _, exists := r.families[name]; exists {
+        panic(fmt.Sprintf("Metric %s is already registered", name))
+    }
+
+    f := &family{name: name,
+                 help: help,
+                 kind: kind,
+                 labelNames: append([]string{}, labelNames...),
+                 children: make(map[string]interface{}),
+                 labelValues: make(map[string][]string)}
+    r.families[name] = f
+    return f
+}
+
+func (r *Registry) NewCounter(name string,
+                              help string,
+                              labelNames ...string) *CounterVec {
+    return &CounterVec{family: r.register(name, help, "counter", labelNames)}
+}
+
+func (r *Registry) NewGauge(name string,
+                            help string,
+                            labelNames ...string) *GaugeVec {
+    return &GaugeVec{family: r.register(name, help, "gauge", labelNames)}
+}
+
+// Buckets are upper bounds and must be increasing, nil for DefaultBuckets
+func (r *Registry) NewHistogram(name string,
+                                help string,
+                                buckets []float64,
+                                labelNames ...string) *HistogramVec {
+    if buckets == nil {
+        buckets = DefaultBuckets
+    }
+
+    if !sort.Float64sAreSorted(buckets) {
+        panic(fmt.Sprintf("Buckets for %s must be increasing", name))
+    }
+
+    f := r.register(name, help, "histogram", labelNames)
+    return &HistogramVec{family: f, bounds: append([]float64{}, buckets...)}
+}
+
+// A gauge worked out when scraped, for values that live elsewhere
+func (r *Registry) NewGaugeFunc(name string,
+                                help string,
+                                labelNames []string,
+                                collect CollectFunc) {
+    r.register(name, help, "gauge", labelNames).collect = collect
+}
+
+func formatValue(value float64) string {
+    switch {
+    case math.IsInf(value, 1):
+        return "+Inf"
+    case math.IsInf(value, -1):
+        return "-Inf"
+    case math.IsNaN(value):
+        return "NaN"
+    }
+
+    return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+var helpEscaper = strings.NewReplacer("\\", "\\\\", "\n", "\\n")
+var labelEscaper = strings.NewReplacer("\\", "\\\\", "\n", "\\n", "\"", "\\\"")
+
+// {a="1",b="2"}, or nothing without labels
+func formatLabels(names []string, values []string) string {
+    if len(names) == 0 {
+        return ""
+    }
+
+    pairs := make([]string, len(names))
+    for i, name := range names {
+        pairs[i] = name + "=\"" + labelEscaper.Replace(values[i]) + "\""
+    }
+
+    return "{" + strings.Join(pairs, ",") + "}"
+}
+
+type sample struct {
+    labelValues []string
+    value interface{}
+}
+
+func (f *family) samples() []sample {
+    var samples []sample
+
+    if f.collect != nil {
+        f.collect(func(value float64, labelValues ...string) {
+            if len(labelValues) == len(f.labelNames) {
+                samples = append(samples,
+                                 sample{labelValues: labelValues, value: value})
+            }
+        })
+    } else {
+        f.lock.Lock()
+        for key, child := range f.children {
+            samples = append(samples, sample{labelValues: f.labelValues[key],
+                                             value: child})
+        }
+        f.lock.Unlock()
+    }
+
+    sort.Slice(samples, func(i, j int) bool {
+        return strings.Join(samples[i].labelValues, labelSeparator) <
+               strings.Join(samples[j].labelValues, labelSeparator)
+    })
+
+    return samples
+}
+
+func (f *family) write(out *bufio.Writer) {
+    fmt.Fprintf(out, "# HELP %s %s\n", f.name, helpEscaper.Replace(f.help))
+    fmt.Fprintf(out, "# TYPE %s %s\n", f.name, f.kind)
+
+    for _, s := range f.samples() {
+        labels := formatLabels(f.labelNames, s.labelValues)
+
+        var value float64
+
+        switch metric := s.value.(type) {
+        case float64:
+            value = metric
+        case *Counter:
+            value = metric.Value()
+        case *Gauge:
+            value = metric.Value()
+        case *Histogram:
+            f.writeHistogram(out, s.labelValues, metric.snapshot())
+            continue
+        }
+
+        fmt.Fprintf(out, "%s%s %s\n", f.name, labels, formatValue(value))
+    }
+}
+
+func (f *family) writeHistogram(out *bufio.Writer,
+                                labelValues []string,
+                                snapshot histogramSnapshot) {
+    names := append(append([]string{}, f.labelNames...), "le")
+
+    for i, count := range snapshot.cumulative {
+        bound := math.Inf(1)
+        if i < len(snapshot.bounds) {
+            bound = snapshot.bounds[i]
+        }
+
+        values := append(append([]string{}, labelValues...), formatValue(bound))
+        fmt.Fprintf(out,
+                    "%s_bucket%s %d\n",
+                    f.name,
+                    formatLabels(names, values),
+                    count)
+    }
+
+    labels := formatLabels(f.labelNames, labelValues)
+    fmt.Fprintf(out, "%s_sum%s %s\n", f.name, labels, formatValue(snapshot.sum))
+    fmt.Fprintf(out, "%s_count%s %d\n", f.name, labels, snapshot.count)
+}
+
+// Write every metric in the Prometheus text format, sorted by name
+func (r *Registry) WriteText(writer io.Writer) error {
+    r.lock.Lock()
+    families := make([]*family, 0, len(r.families))
+    for _, f := range r.families {
+        families = append(families, f)
+    }
+    r.lock.Unlock()
+
+    sort.Slice(families, func(i, j int) bool {
+        return families[i].name < families[j].name
+    })
+
+    out := bufio.NewWriter(writer)
+    for _, f := range families {
+        f.write(out)
+    }
+
+    return out.Flush()
+}
+
+func (r *Registry) ServeHTTP(writer http.ResponseWriter,
+                             request *http.Request) {
+    if request.Method != http.MethodGet && request.Method != http.MethodHead {
+        writer.Header().Set("Allow", "GET, HEAD")
+        http.Error(writer, "Method not allowed", http.StatusMethodNotAllowed)
+        return
+    }
+
+    writer.Header().Set("Content-Type", ContentType)
+    r.WriteText(writer)
+}
@@ -74,6 +74,27 @@ func (c1 Circle) DistanceTo(c2 Circle) Vector {
     return c2.Centre.Sub(c1.Centre)
 }
 
-func (c1 Circle) Equal(c2 Circle) bool {
-    return c1.Centre.Equal(c2.Centre) && float32Equal(c1.Radius, c2.Radius)
+func (c1 Circle) Equal(other Shape) bool {
+    c2, ok := other.(Circle)
+    return ok && c1.Centre.Equal(c2.Centre) && float32Equal(c1.Radius, c2.Radius)
+}
+
+// True if inner lies entirely within c
+func (c Circle) Contains(inner Circle) bool {
+    distance := c.DistanceTo(inner).Magnatude()
+    // Allow for a little float32 rounding on the boundary
+    return distance + inner.Radius <= c.Radius * (1 + 1e-5)
+}
+
+func (c Circle) ContainsPoint(point Vector) bool {
+    return point.Sub(c.Centre).Magnatude() <= c.Radius
+}
+
+func (c Circle) Validate() error {
+    if invalidVector(c.Centre) || invalidFloat(c.Radius) || c.Radius < 0 {
+        return invalidf("Circle must have a finite centre and a radius of " +
+                        "at least 0, got %+v",
+                        c)
+    }
+    return nil
 }
@@ -0,0 +1,143 @@
+package model
+
+// Oldest entries are dropped once the log gets this long
+const MaxDamageLogEntries = 1000
+
+type DamageEvent struct {
+    TokenId Identifier `json:",string"`
+    // Seconds of room time since the room was created
+    Time float32
+    Amount float32
+    HitPoints float32
+    Eliminated bool
+}
+
+// Damage per second dealt to tokens outside the fog circle, 0 turns it off
+func (r *Room) SetDamagePerSecond(damage float32) error {
+    if damage < 0 || invalidFloat(damage) {
+        return invalidf("Damage per second must be a positive number, got %v",
+                        damage)
+    }
+
+    r.damagePerSecond = damage
+    return nil
+}
+
+func (r *Room) DamagePerSecond() float32 {
+    return r.damagePerSecond
+}
+
+// Seconds of room time that have passed in Update. Rooms are only updated
+// while Active, so this stands still while nothing is happening.
+func (r *Room) Time() float32 {
+    return r.time
+}
+
+// Damage dealt after (room) time since, oldest first
+func (r *Room) DamageLog(since float32) []DamageEvent {
+    events := make([]DamageEvent, 0)
+
+    for _, event := range r.damageLog {
+        if event.Time > since {
+            events = append(events, event)
+        }
+    }
+
+    return events
+}
+
+// Set a token's hit points, bringing it back if it had been eliminated
+func (r *Room) SetTokenHitPoints(id Identifier, hitPoints float32) error {
+    token, err := r.findPlayerToken(id)
+
+    if err != nil {
+        return err
+    }
+
+    if hitPoints < 0 || invalidFloat(hitPoints) {
+        return invalidf("Hit points must be a positive number, got %v",
+                        hitPoints)
+    }
+
+    token.HitPoints = hitPoints
+    if hitPoints > token.MaxHitPoints {
+        token.MaxHitPoints = hitPoints
+    }
+    token.Eliminated = hitPoints == 0
+
+    return nil
+}
+
+// Work out which tokens are outside the fog as it is now, without any time
+// passing
+func (r *Room) RefreshZone() {
+    zone := r.fog.Current()
+
+    for i := range r.playerTokens {
+        token := &r.playerTokens[i]
+
+        if !token.Eliminated {
+            token.OutsideZone = !zone.ContainsPoint(token.Position)
+        }
+    }
+}
+
+// Whether time passing would change anything, either the fog is moving or
+// someone is outside it taking damage
+func (r *Room) Active() bool {
+    if !r.fog.Paused() {
+        return true
+    }
+
+    if r.damagePerSecond <= 0 {
+        return false
+    }
+
+    zone := r.fog.Current()
+
+    for _, token := range r.playerTokens {
+        if !token.Eliminated && !zone.ContainsPoint(token.Position) {
+            return true
+        }
+    }
+
+    return false
+}
+
+func (r *Room) applyZoneDamage(timeDelta float32) {
+    r.RefreshZone()
+    damage := r.damagePerSecond * timeDelta
+
+    if damage <= 0 {
+        return
+    }
+
+    for i := range r.playerTokens {
+        token := &r.playerTokens[i]
+
+        if token.Eliminated || !token.OutsideZone {
+            continue
+        }
+
+        dealt := damage
+        if dealt > token.HitPoints {
+            dealt = token.HitPoints
+        }
+
+        token.HitPoints -= dealt
+        token.Eliminated = token.HitPoints <= 0
+
+        r.logDamage(DamageEvent{TokenId: token.Id,
+                                Time: r.time,
+                                Amount: dealt,
+                                HitPoints: token.HitPoints,
+                                Eliminated: token.Eliminated})
+    }
+}
+
+func (r *Room) logDamage(event DamageEvent) {
+    if len(r.damageLog) >= MaxDamageLogEntries {
+        r.damageLog = r.damageLog[1:]
+    }
+    r.damageLog = append(r.damageLog, event)
+}
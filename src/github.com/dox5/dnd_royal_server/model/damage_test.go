@@ -0,0 +1,169 @@
+package model_test
+
+import (
+    "testing"
+
+    "github.com/dox5/dnd_royal_server/model"
+)
+
+func newDamageRoom(t *testing.T, damagePerSecond float32) *model.Room {
+    t.Helper()
+
+    config := model.RoomConfig{
+        Positions: []model.Vector{{0, 0}, {200, 0}},
+        Fog: model.AnyShape{Shape: model.Circle{Radius: 100}},
+        HitPoints: 10,
+        DamagePerSecond: damagePerSecond}
+
+    room, err := model.NewRoomFromConfig(model.NewPlayer(), config)
+
+    if err != nil {
+        t.Fatalf("Failed to create room: %s", err)
+    }
+
+    return room
+}
+
+func TestTokensOutsideZoneShouldTakeDamage(t *testing.T) {
+    room := newDamageRoom(t, 2)
+    room.Update(1.5)
+
+    inside := room.GetPlayerTokens()[0]
+    outside := room.GetPlayerTokens()[1]
+
+    if inside.OutsideZone || inside.HitPoints != 10 {
+        t.Errorf("Expected token inside the zone to be unharmed but it was %+v",
+                 inside)
+    }
+
+    if !outside.OutsideZone || outside.HitPoints != 7 {
+        t.Errorf("Expected token outside the zone to have 7 hit points but it was %+v",
+                 outside)
+    }
+}
+
+func TestTokenAtZeroHitPointsShouldBeEliminated(t *testing.T) {
+    room := newDamageRoom(t, 4)
+
+    for i := 0; i < 3; i += 1 {
+        room.Update(1)
+    }
+
+    outside := room.GetPlayerTokens()[1]
+
+    if !outside.Eliminated || outside.HitPoints != 0 {
+        t.Errorf("Expected token to be eliminated with 0 hit points but it was %+v",
+                 outside)
+    }
+
+    if err := room.MovePlayerToken(outside.Id, model.Vector{}); err == nil {
+        t.Error("Expected moving an eliminated token to fail")
+    }
+
+    log := room.DamageLog(0)
+    if len(log) != 3 {
+        t.Fatalf("Expected 3 damage events but there were %v", len(log))
+    }
+
+    last := log[len(log) - 1]
+    if last.Amount != 2 || !last.Eliminated || last.Time != 3 {
+        t.Errorf("Expected final hit of 2 eliminating the token at time 3 " +
+                 "but got %+v",
+                 last)
+    }
+}
+
+func TestNoDamageShouldStillTrackZoneStatus(t *testing.T) {
+    room := newDamageRoom(t, 0)
+    room.Update(10)
+
+    outside := room.GetPlayerTokens()[1]
+
+    if !outside.OutsideZone || outside.HitPoints != 10 {
+        t.Errorf("Expected token to be outside but unharmed, it was %+v", outside)
+    }
+
+    if len(room.DamageLog(0)) != 0 {
+        t.Error("Expected no damage to be logged")
+    }
+}
+
+func TestDamageLogShouldOnlyReturnNewerEvents(t *testing.T) {
+    room := newDamageRoom(t, 1)
+
+    room.Update(1)
+    room.Update(1)
+
+    log := room.DamageLog(1)
+    if len(log) != 1 || log[0].Time != 2 {
+        t.Errorf("Expected only the event at time 2 but got %+v", log)
+    }
+}
+
+func TestSetTokenHitPointsShouldRevive(t *testing.T) {
+    room := newDamageRoom(t, 100)
+    room.Update(1)
+
+    outside := room.GetPlayerTokens()[1]
+
+    if err := room.SetTokenHitPoints(outside.Id, 5); err != nil {
+        t.Fatalf("Failed to set hit points: %s", err)
+    }
+
+    revived, _ := room.GetPlayerToken(outside.Id)
+    if revived.Eliminated || revived.HitPoints != 5 {
+        t.Errorf("Expected token to be revived with 5 hit points but it was %+v",
+                 revived)
+    }
+
+    if err := room.SetTokenHitPoints(outside.Id, -1); err == nil {
+        t.Error("Expected negative hit points to be rejected")
+    }
+}
+
+func TestNegativeDamageShouldBeRejected(t *testing.T) {
+    room := newDamageRoom(t, 0)
+
+    if err := room.SetDamagePerSecond(-1); err == nil {
+        t.Error("Expected negative damage to be rejected")
+    }
+}
+
+func TestRoomShouldOnlyBeActiveWhenTimeMatters(t *testing.T) {
+    room := newDamageRoom(t, 0)
+
+    if room.Active() {
+        t.Error("Expected room with paused fog and no damage to be inactive")
+    }
+
+    room.SetDamagePerSecond(1)
+
+    if !room.Active() {
+        t.Error("Expected room with a token outside taking damage to be active")
+    }
+
+    outside := room.GetPlayerTokens()[1]
+    room.MovePlayerToken(outside.Id, model.Vector{10, 10})
+
+    if room.Active() {
+        t.Error("Expected room with everyone safe to be inactive")
+    }
+
+    room.Fog().Resume()
+
+    if !room.Active() {
+        t.Error("Expected room with moving fog to be active")
+    }
+}
+
+func TestRefreshZoneShouldNotDealDamage(t *testing.T) {
+    room := newDamageRoom(t, 5)
+    room.RefreshZone()
+
+    outside := room.GetPlayerTokens()[1]
+
+    if !outside.OutsideZone || outside.HitPoints != 10 || room.Time() != 0 {
+        t.Errorf("Expected token to be marked outside but unharmed, it was %+v",
+                 outside)
+    }
+}
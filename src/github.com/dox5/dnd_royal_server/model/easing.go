@@ -0,0 +1,79 @@
+package model
+
+import (
+    "math"
+)
+
+const (
+    // A steady shrink, the default
+    EasingLinear = "linear"
+    // Slow to start then speeding up
+    EasingIn = "easeIn"
+    // Fast to start then slowing down
+    EasingOut = "easeOut"
+    // Slow at both ends
+    EasingInOut = "easeInOut"
+    // Barely moving until a rush at the end
+    EasingExponential = "exponential"
+    // Jumps a quarter of the way at a time
+    EasingStep = "step"
+
+    easingSteps = 4
+)
+
+// How far through a move the fog is given how far through its time it is,
+// both from 0 to 1
+type easingFunc func(progress float64) float64
+
+var easings = map[string]easingFunc{
+    EasingLinear: func(p float64) float64 {
+        return p
+    },
+    EasingIn: func(p float64) float64 {
+        return p * p
+    },
+    EasingOut: func(p float64) float64 {
+        return 1 - (1 - p) * (1 - p)
+    },
+    EasingInOut: func(p float64) float64 {
+        if p < 0.5 {
+            return 2 * p * p
+        }
+        return 1 - 2 * (1 - p) * (1 - p)
+    },
+    EasingExponential: func(p float64) float64 {
+        if p <= 0 {
+            return 0
+        }
+        return math.Pow(2, 10 * p - 10)
+    },
+    EasingStep: func(p float64) float64 {
+        return math.Floor(p * easingSteps) / easingSteps
+    },
+}
+
+// An empty easing is linear
+func easingName(easing string) string {
+    if easing == "" {
+        return EasingLinear
+    }
+    return easing
+}
+
+func ValidateEasing(easing string) error {
+    if _, found := easings[easingName(easing)]; !found {
+        return invalidf("Unknown easing %q", easing)
+    }
+    return nil
+}
+
+func ease(easing string, progress float64) float64 {
+    if progress >= 1 {
+        return 1
+    }
+
+    if function, found := easings[easingName(easing)]; found {
+        return function(progress)
+    }
+    return progress
+}
@@ -0,0 +1,39 @@
+package model
+
+import (
+    "fmt"
+)
+
+type ErrorKind int
+
+const (
+    // The caller asked for something that doesn't make sense
+    InvalidArgument ErrorKind = iota
+    // The thing being operated on doesn't exist (any more)
+    NotFound
+    // The request was fine but the state of the room doesn't allow it
+    Conflict
+)
+
+// Errors from the model say what kind of problem they are, so callers can
+// tell bad input from missing things
+type Error struct {
+    Kind ErrorKind
+    Message string
+}
+
+func (e *Error) Error() string {
+    return e.Message
+}
+
+func invalidf(format string, args ...interface{}) error {
+    return &Error{Kind: InvalidArgument, Message: fmt.Sprintf(format, args...)}
+}
+
+func notFoundf(format string, args ...interface{}) error {
+    return &Error{Kind: NotFound, Message: fmt.Sprintf(format, args...)}
+}
+
+func conflictf(format string, args ...interface{}) error {
+    return &Error{Kind: Conflict, Message: fmt.Sprintf(format, args...)}
+}
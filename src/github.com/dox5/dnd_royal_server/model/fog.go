@@ -1,20 +1,55 @@
 package model
 
 import (
-    "math"
+    "reflect"
 )
 
+// One zone of a battle royale: wait for Hold seconds then shrink to Target
+// over Shrink seconds, following Easing (linear if empty)
+type FogStage struct {
+    Target AnyShape
+    Hold   float32
+    Shrink float32
+    Easing string
+}
+
+// Optional limits on where the game master can send the fog
+type FogRules struct {
+    // Each target must lie entirely inside the fog as it is now
+    TargetInsideCurrent bool
+    // Targets can't be bigger than the fog is now
+    NoGrowing bool
+}
+
+// The fog moves from start to target shape over period seconds, following an
+// easing curve, so where it is can be worked out for any time rather than
+// built up a step at a time. Time only passes for the fog while it is
+// resumed, pausing simply leaves the rest of the move for later.
 type Fog struct {
-    target      Circle
-    current     Circle
+    rules       FogRules
+    start       Shape
+    target      Shape
+    // When the move began, in seconds of fog time
+    startTime   float64
     period      float32
+    easing      string
+    // Whether a target or period has been set, the fog stays put until then
+    aimed       bool
     advance     bool
     advanceRate Rate
+    // Seconds the fog has been advanced for while resumed
+    time        float64
+
+    // Only used when following a schedule, start is where the stage began
+    schedule    []FogStage
+    stage       int
+    stageStarts []Shape
+    stageTime   float64
 }
 
-func NewFog(initial Circle) * Fog {
-    fog := &Fog{target: Circle{},
-                current: initial,
+func NewFog(initial Shape) * Fog {
+    fog := &Fog{start: initial,
+                target: Circle{},
                 period: 1,
                 advance: false,
                 advanceRate: Rate{}}
@@ -22,79 +57,232 @@ func NewFog(initial Circle) * Fog {
     return fog
 }
 
+// Only circles have a radius to change, every shape's centre moves
 func (f *Fog) recalculateRate() {
-    radiusDelta := f.target.Radius - f.current.Radius
-    radiusRate := radiusDelta / f.period
-    f.advanceRate.Radius = radiusRate
+    f.advanceRate = Rate{}
+
+    if f.period <= 0 || checkMove(f.start, f.target) != nil {
+        return
+    }
+
+    start, startIsCircle := f.start.(Circle)
+    target, targetIsCircle := f.target.(Circle)
+    if startIsCircle && targetIsCircle {
+        radiusDelta := target.Radius - start.Radius
+        f.advanceRate.Radius = radiusDelta / f.period
+    }
 
-    translation := f.current.DistanceTo(f.target)
+    translation := f.target.Centroid().Sub(f.start.Centroid())
     f.advanceRate.Translation = translation.DivideScalar(f.period)
 }
 
-func (f *Fog) SetTarget(target Circle) {
-    f.target = target
+// Begin a new move from the given shape, now
+func (f *Fog) restartFrom(from Shape) {
+    f.start = from
+    f.startTime = f.time
+    f.aimed = true
     f.recalculateRate()
 }
 
-func (f *Fog) SetPeriod(period float32) {
+func (f *Fog) SetRules(rules FogRules) {
+    f.rules = rules
+}
+
+func (f *Fog) Rules() FogRules {
+    return f.rules
+}
+
+// Check a target is a real shape that the fog can move to from current, and
+// that the rules allow it
+func (f *Fog) checkTarget(current Shape, target Shape) error {
+    if target == nil {
+        return invalidf("Target is missing")
+    }
+
+    err := target.Validate()
+
+    if err == nil {
+        err = checkMove(current, target)
+    }
+
+    if err != nil {
+        return err
+    }
+
+    if f.rules.NoGrowing && target.Area() > current.Area() {
+        return invalidf("Target area %v must not exceed the current area %v",
+                        target.Area(),
+                        current.Area())
+    }
+
+    if f.rules.TargetInsideCurrent && !Encloses(current, target) {
+        return invalidf("Target %+v must lie inside the current fog %+v",
+                        target,
+                        current)
+    }
+
+    return nil
+}
+
+func invalidDuration(seconds float32) bool {
+    return invalidFloat(seconds) || seconds < 0
+}
+
+// Setting the target by hand takes the fog off any schedule
+func (f *Fog) SetTarget(target Shape) error {
+    return f.SetTargetEased(target, EasingLinear)
+}
+
+// Move to target following one of the Easing curves rather than steadily
+func (f *Fog) SetTargetEased(target Shape, easing string) error {
+    err := ValidateEasing(easing)
+
+    if err != nil {
+        return err
+    }
+
+    current := f.Current()
+    err = f.checkTarget(current, target)
+
+    if err != nil {
+        return err
+    }
+
+    f.clearSchedule()
+    f.target = target
+    f.easing = easingName(easing)
+    f.restartFrom(current)
+    return nil
+}
+
+// Setting the period by hand takes the fog off any schedule
+func (f *Fog) SetPeriod(period float32) error {
+    if invalidDuration(period) || period == 0 {
+        return invalidf("Period must be a positive number of seconds, got %v",
+                        period)
+    }
+
+    current := f.Current()
+    f.clearSchedule()
     f.period = period
-    f.recalculateRate()
+    f.restartFrom(current)
+    return nil
 }
 
-func (f *Fog) Advance(timeDelta float32) {
+func (f *Fog) Advance(timeDelta float32) error {
+    if invalidDuration(timeDelta) {
+        return invalidf("Time to advance must be a positive number of " +
+                        "seconds, got %v",
+                        timeDelta)
+    }
+
     if !f.advance {
-        return
+        return nil
     }
 
-    deltaRadius := timeDelta * f.advanceRate.Radius
-    deltaTranslation := f.advanceRate.Translation.MultiplyScalar(timeDelta)
+    f.time += float64(timeDelta)
 
-    fullStep := Circle{Centre: f.current.Centre.Add(deltaTranslation),
-                       Radius: f.current.Radius + deltaRadius}
+    if f.Scheduled() {
+        f.advanceSchedule()
+        return nil
+    }
 
-    // Deal with the circle
-    fullStepDistance := f.current.DistanceTo(fullStep).Magnatude()
-    targetDistance := f.current.DistanceTo(f.target).Magnatude()
+    // A fog already on its target has arrived straight away
+    end := f.startTime + float64(f.period)
+    if f.aimed && (reflect.DeepEqual(f.start, f.target) || f.time >= end) {
+        f.Pause()
+    }
 
-    arrived := true
-    if fullStepDistance < targetDistance {
-        // Safe to do the full move
-        f.current.Centre = fullStep.Centre
-        // Not arrived
-        arrived = false
-    } else {
-        // Would land on or go past target
-        f.current.Centre = f.target.Centre
+    return nil
+}
+
+// A straight move of the fog, times are in fog time
+type FogMove struct {
+    From   Shape
+    To     Shape
+    Start  float64
+    End    float64
+    Easing string
+}
+
+// The move the fog is making, or will make once it has finished holding.
+// There is none until a target is set or once a schedule has finished.
+func (f *Fog) CurrentMove() (FogMove, bool) {
+    if !f.aimed || (f.Scheduled() && f.stage >= len(f.schedule)) {
+        return FogMove{}, false
     }
 
-    // Deal with the radius
-    deltaStepRadius   := math.Abs(float64(f.current.Radius - fullStep.Radius))
-    deltaTargetRadius := math.Abs(float64(f.current.Radius - f.target.Radius))
+    period := f.period
+    if f.Scheduled() {
+        period = f.schedule[f.stage].Shrink
+    }
 
-    if deltaStepRadius < deltaTargetRadius {
-        // Safe to do the full step
-        f.current.Radius = fullStep.Radius
-        // Not arrived
-        arrived = false
-    } else {
-        // Would be >= target radius
-        f.current.Radius = f.target.Radius
+    return FogMove{From: f.start,
+                   To: f.target,
+                   Start: f.startTime,
+                   End: f.startTime + float64(period),
+                   Easing: f.Easing()}, true
+}
+
+// The easing curve of the move in progress
+func (f *Fog) Easing() string {
+    if f.Scheduled() && f.stage < len(f.schedule) {
+        return easingName(f.schedule[f.stage].Easing)
     }
+    return easingName(f.easing)
+}
 
-    if arrived {
-        f.Pause()
+// Seconds the fog has spent resumed, the time scale At works in
+func (f *Fog) Time() float64 {
+    return f.time
+}
+
+// Where the fog will be at time t if it keeps moving. Times from before the
+// current move (or stage) began give where it started from.
+func (f *Fog) At(t float64) Shape {
+    if f.Scheduled() {
+        return f.scheduleAt(t)
     }
+
+    if !f.aimed {
+        return f.start
+    }
+
+    return moveAt(f.start, f.target, t - f.startTime, f.period, f.easing)
 }
 
-func (f *Fog) Target() Circle {
+// Part way along a move from start to target taking period seconds. A fog
+// with nowhere it can go stays put.
+func moveAt(start Shape,
+            target Shape,
+            elapsed float64,
+            period float32,
+            easing string) Shape {
+    if elapsed <= 0 || checkMove(start, target) != nil {
+        return start
+    }
+
+    if period <= 0 || elapsed >= float64(period) {
+        return target
+    }
+
+    return start.Towards(target, ease(easing, elapsed / float64(period)))
+}
+
+func (f *Fog) Target() Shape {
     return f.target
 }
 
-func (f *Fog) Current() Circle {
-    return f.current
+func (f *Fog) Current() Shape {
+    return f.At(f.time)
 }
 
+// The average rate over the move, eased moves go slower and faster than
+// this. The fog doesn't move while holding between stages.
 func (f *Fog) Rate() Rate {
+    if f.Holding() {
+        return Rate{}
+    }
     return f.advanceRate
 }
 
@@ -109,3 +297,186 @@ func (f *Fog) Resume() {
 func (f *Fog) Paused() bool {
     return !f.advance
 }
+
+// Follow the given stages in order, starting with the first. The fog still
+// needs to be resumed to start it moving.
+func (f *Fog) SetSchedule(stages []FogStage) error {
+    if len(stages) == 0 {
+        return invalidf("Schedule must have at least one stage")
+    }
+
+    // Each stage starts where the last one finished
+    current := f.Current()
+    start := current
+
+    for i, stage := range stages {
+        if invalidDuration(stage.Hold) || invalidDuration(stage.Shrink) {
+            return invalidf("Stage %d has a negative duration", i)
+        }
+
+        err := ValidateEasing(stage.Easing)
+
+        if err == nil {
+            err = f.checkTarget(start, stage.Target.Shape)
+        }
+
+        if err != nil {
+            return invalidf("Stage %d: %s", i, err)
+        }
+
+        start = stage.Target.Shape
+    }
+
+    f.schedule = make([]FogStage, len(stages))
+    copy(f.schedule, stages)
+    f.stageStarts = make([]Shape, 0, len(stages))
+    f.enterStage(0, f.time, current)
+
+    return nil
+}
+
+func (f *Fog) Schedule() []FogStage {
+    stages := make([]FogStage, len(f.schedule))
+    copy(stages, f.schedule)
+    return stages
+}
+
+func (f *Fog) Scheduled() bool {
+    return len(f.schedule) > 0
+}
+
+// Index of the stage in progress, -1 without a schedule and the number of
+// stages once the schedule has finished
+func (f *Fog) StageIndex() int {
+    if !f.Scheduled() {
+        return -1
+    }
+    return f.stage
+}
+
+// Waiting for the current stage to start shrinking
+func (f *Fog) Holding() bool {
+    return f.Scheduled() && f.stage < len(f.schedule) && f.time < f.startTime
+}
+
+// When the stage in progress finishes, in fog time
+func (f *Fog) stageEnd() float64 {
+    stage := f.schedule[f.stage]
+    return f.stageTime + float64(stage.Hold) + float64(stage.Shrink)
+}
+
+// Seconds of (un-paused) time until the current stage is complete
+func (f *Fog) StageTimeRemaining() float32 {
+    if !f.Scheduled() || f.stage >= len(f.schedule) {
+        return 0
+    }
+    return float32(f.stageEnd() - f.time)
+}
+
+// Jump straight to the end of the current stage and start the next one
+func (f *Fog) SkipStage() error {
+    if !f.Scheduled() {
+        return conflictf("Fog has no schedule")
+    }
+
+    if f.stage >= len(f.schedule) {
+        return conflictf("Schedule has already finished")
+    }
+
+    f.enterStage(f.stage + 1, f.time, f.target)
+
+    if f.stage >= len(f.schedule) {
+        f.Pause()
+    }
+
+    return nil
+}
+
+// Go back to the start of the previous stage (or restart the first)
+func (f *Fog) RewindStage() error {
+    if !f.Scheduled() {
+        return conflictf("Fog has no schedule")
+    }
+
+    previous := f.stage - 1
+    if previous < 0 {
+        previous = 0
+    }
+
+    f.enterStage(previous, f.time, f.stageStarts[previous])
+
+    return nil
+}
+
+func (f *Fog) clearSchedule() {
+    f.schedule = nil
+    f.stage = 0
+    f.stageStarts = nil
+    f.stageTime = 0
+}
+
+// Start stage index from the given circle at time at
+func (f *Fog) enterStage(index int, at float64, from Shape) {
+    f.stage = index
+    f.stageTime = at
+    f.start = from
+    f.startTime = at
+
+    if index >= len(f.schedule) {
+        return
+    }
+
+    f.stageStarts = append(f.stageStarts[:index], from)
+
+    stage := f.schedule[index]
+    f.target = stage.Target.Shape
+    f.startTime = at + float64(stage.Hold)
+    f.aimed = true
+
+    if stage.Shrink > 0 {
+        f.period = stage.Shrink
+        f.recalculateRate()
+    } else {
+        f.advanceRate = Rate{}
+    }
+}
+
+// Where the schedule puts the fog at time t, following it on past the
+// current stage
+func (f *Fog) scheduleAt(t float64) Shape {
+    from := f.start
+    stageTime := f.stageTime
+
+    for _, stage := range f.schedule[f.stage:] {
+        shrinkStart := stageTime + float64(stage.Hold)
+        end := shrinkStart + float64(stage.Shrink)
+
+        if t < end {
+            return moveAt(from,
+                          stage.Target.Shape,
+                          t - shrinkStart,
+                          stage.Shrink,
+                          stage.Easing)
+        }
+
+        from = stage.Target.Shape
+        stageTime = end
+    }
+
+    return from
+}
+
+// Start every stage that has finished by now, carrying over the time exactly
+func (f *Fog) advanceSchedule() {
+    for f.stage < len(f.schedule) {
+        end := f.stageEnd()
+
+        if f.time < end {
+            return
+        }
+
+        f.enterStage(f.stage + 1, end, f.schedule[f.stage].Target.Shape)
+    }
+
+    f.Pause()
+}
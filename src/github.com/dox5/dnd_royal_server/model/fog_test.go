@@ -1,11 +1,18 @@
 package model_test
 
 import (
+    "math"
     "testing"
 
     "github.com/dox5/dnd_royal_server/model"
 )
 
+func stageTo(target model.Shape, hold float32, shrink float32) model.FogStage {
+    return model.FogStage{Target: model.AnyShape{Shape: target},
+                          Hold: hold,
+                          Shrink: shrink}
+}
+
 func TestNewFogShouldHaveTargetAndCurrentSet(t *testing.T) {
     expectedTarget := model.Circle{Centre: model.Vector{0, 0}, Radius: 10}
     expectedCurrent := model.Circle{Centre: model.Vector{0, 0}, Radius: 25}
@@ -202,24 +209,21 @@ func TestAdvancePastTargetShouldStop(t *testing.T) {
     }
 }
 
-func TestAdvanceInSmallStepsGetsCloseToTarget(t *testing.T) {
+func TestAdvanceInSmallStepsShouldReachTarget(t *testing.T) {
     initial := model.Circle{Centre: model.Vector{0, 0}, Radius: 50}
     target := model.Circle{Centre: model.Vector{0, 0}, Radius: 40}
 
     fog := model.NewFog(initial)
     fog.SetTarget(target)
 
-    period := 1
-    steps  := 1001 // Rounding error means it's going to take a few more steps
-    fog.SetPeriod(float32(period))
+    fog.SetPeriod(1)
     fog.Resume()
 
-    stepSize := float64(period) / float64(steps)
-    for i := 0; i < steps ; i += 1 {
-        fog.Advance(float32(stepSize))
+    for i := 0; i < 1000 ; i += 1 {
+        fog.Advance(0.001)
     }
 
-    if !fog.Current().Equal(target) {
+    if fog.Current() != target {
         t.Errorf("Expcted to stop at target but did not!\n" +
                  "Target: %+v\n" +
                  "Current: %+v\n",
@@ -228,6 +232,81 @@ func TestAdvanceInSmallStepsGetsCloseToTarget(t *testing.T) {
     }
 }
 
+func TestFogShouldNotDependOnStepSize(t *testing.T) {
+    initial := model.Circle{Centre: model.Vector{3, 7}, Radius: 50}
+    target := model.Circle{Centre: model.Vector{-11, 2}, Radius: 13}
+
+    oneStep := model.NewFog(initial)
+    manySteps := model.NewFog(initial)
+
+    for _, fog := range []*model.Fog{oneStep, manySteps} {
+        fog.SetTarget(target)
+        fog.SetPeriod(7)
+        fog.Resume()
+    }
+
+    oneStep.Advance(3.5)
+
+    for i := 0; i < 14; i += 1 {
+        manySteps.Advance(0.25)
+    }
+
+    if oneStep.Current() != manySteps.Current() {
+        t.Errorf("Expected the same fog however it was stepped but got %+v " +
+                 "and %+v",
+                 oneStep.Current(),
+                 manySteps.Current())
+    }
+
+    if oneStep.At(3.5) != oneStep.Current() {
+        t.Errorf("Expected the fog at the current time to be %+v but was %+v",
+                 oneStep.Current(),
+                 oneStep.At(3.5))
+    }
+}
+
+func TestAtShouldGiveFogAtAnyTime(t *testing.T) {
+    initial := model.Circle{Centre: model.Vector{0, 0}, Radius: 50}
+    first := model.Circle{Centre: model.Vector{0, 0}, Radius: 40}
+    second := model.Circle{Centre: model.Vector{10, 0}, Radius: 20}
+
+    fog := model.NewFog(initial)
+    err := fog.SetSchedule([]model.FogStage{stageTo(first, 5, 5),
+                                            stageTo(second, 5, 10)})
+
+    if err != nil {
+        t.Fatalf("Failed to set schedule: %s", err)
+    }
+
+    expected := map[float64]model.Circle{
+        0: initial,
+        5: initial,
+        7.5: model.Circle{Centre: model.Vector{0, 0}, Radius: 45},
+        12: first,
+        20: model.Circle{Centre: model.Vector{5, 0}, Radius: 30},
+        100: second}
+
+    // Nothing has happened yet, this is all looking ahead
+    for at, circle := range expected {
+        if fog.At(at) != circle {
+            t.Errorf("Expected fog to be %+v at %vs but it was %+v",
+                     circle,
+                     at,
+                     fog.At(at))
+        }
+    }
+
+    // Paused time doesn't count
+    fog.Advance(20)
+
+    if fog.Time() != 0 || fog.Current() != initial {
+        t.Errorf("Expected paused fog to stay at %+v but it was %+v at %vs",
+                 initial,
+                 fog.Current(),
+                 fog.Time())
+    }
+}
+
 func TestArriveAtTargetShouldPause(t *testing.T) {
     target := model.Circle{Centre: model.Vector{10, 0}, Radius: 10}
     initial := model.Circle{Centre: model.Vector{0, 0}, Radius: 50}
@@ -264,3 +343,414 @@ func TestSetTargetShouldAdvanceRate(t *testing.T) {
 
 
 }
+
+func TestSetEmptyScheduleShouldFail(t *testing.T) {
+    fog := model.NewFog(model.Circle{Radius: 50})
+
+    if err := fog.SetSchedule([]model.FogStage{}); err == nil {
+        t.Error("Expected an empty schedule to be rejected")
+    }
+
+    if fog.Scheduled() {
+        t.Error("Fog should not have a schedule after it was rejected")
+    }
+}
+
+func TestSetScheduleWithNegativeDurationShouldFail(t *testing.T) {
+    fog := model.NewFog(model.Circle{Radius: 50})
+
+    stages := []model.FogStage{stageTo(model.Circle{Radius: 10}, -1, 10)}
+
+    if err := fog.SetSchedule(stages); err == nil {
+        t.Error("Expected a negative hold time to be rejected")
+    }
+}
+
+func TestScheduleShouldHoldThenShrink(t *testing.T) {
+    initial := model.Circle{Centre: model.Vector{0, 0}, Radius: 50}
+    target := model.Circle{Centre: model.Vector{0, 0}, Radius: 30}
+
+    fog := model.NewFog(initial)
+    err := fog.SetSchedule([]model.FogStage{stageTo(target, 10, 20)})
+
+    if err != nil {
+        t.Fatalf("Failed to set schedule: %s", err)
+    }
+
+    fog.Resume()
+    fog.Advance(10)
+
+    if fog.Current() != initial {
+        t.Errorf("Expected fog to hold at %+v but it was %+v",
+                 initial,
+                 fog.Current())
+    }
+
+    if fog.StageTimeRemaining() != 20 {
+        t.Errorf("Expected 20 seconds left of the stage but there were %v",
+                 fog.StageTimeRemaining())
+    }
+
+    fog.Advance(10)
+
+    halfWay := model.Circle{Centre: model.Vector{0, 0}, Radius: 40}
+    if fog.Current() != halfWay {
+        t.Errorf("Expected fog to have shrunk to %+v but it was %+v",
+                 halfWay,
+                 fog.Current())
+    }
+
+    fog.Advance(10)
+
+    if fog.Current() != target {
+        t.Errorf("Expected fog to reach %+v but it was %+v",
+                 target,
+                 fog.Current())
+    }
+
+    if fog.StageIndex() != 1 {
+        t.Errorf("Expected schedule to be finished (stage 1) but was at stage %v",
+                 fog.StageIndex())
+    }
+
+    if !fog.Paused() {
+        t.Error("Expected fog to pause once the schedule finished")
+    }
+}
+
+func TestScheduleShouldCarryTimeBetweenStages(t *testing.T) {
+    initial := model.Circle{Centre: model.Vector{0, 0}, Radius: 50}
+    first := model.Circle{Centre: model.Vector{0, 0}, Radius: 40}
+    second := model.Circle{Centre: model.Vector{10, 0}, Radius: 20}
+
+    fog := model.NewFog(initial)
+    err := fog.SetSchedule([]model.FogStage{stageTo(first, 5, 5),
+                                            stageTo(second, 5, 10)})
+
+    if err != nil {
+        t.Fatalf("Failed to set schedule: %s", err)
+    }
+
+    fog.Resume()
+    // All of the first stage, the second hold and half the second shrink
+    fog.Advance(20)
+
+    expected := model.Circle{Centre: model.Vector{5, 0}, Radius: 30}
+    if !fog.Current().Equal(expected) {
+        t.Errorf("Expected fog to be %+v but it was %+v",
+                 expected,
+                 fog.Current())
+    }
+
+    if fog.StageIndex() != 1 {
+        t.Errorf("Expected to be on stage 1 but was on stage %v",
+                 fog.StageIndex())
+    }
+}
+
+func TestSkipStageShouldJumpToStageTarget(t *testing.T) {
+    initial := model.Circle{Centre: model.Vector{0, 0}, Radius: 50}
+    first := model.Circle{Centre: model.Vector{0, 0}, Radius: 40}
+    second := model.Circle{Centre: model.Vector{0, 0}, Radius: 20}
+
+    fog := model.NewFog(initial)
+    err := fog.SetSchedule([]model.FogStage{stageTo(first, 5, 5),
+                                            stageTo(second, 5, 5)})
+
+    if err != nil {
+        t.Fatalf("Failed to set schedule: %s", err)
+    }
+
+    if err = fog.SkipStage(); err != nil {
+        t.Fatalf("Failed to skip stage: %s", err)
+    }
+
+    if fog.Current() != first {
+        t.Errorf("Expected fog to jump to %+v but it was %+v",
+                 first,
+                 fog.Current())
+    }
+
+    if fog.StageIndex() != 1 || fog.Target() != second {
+        t.Errorf("Expected to be on stage 1 heading for %+v but was on " +
+                 "stage %v heading for %+v",
+                 second,
+                 fog.StageIndex(),
+                 fog.Target())
+    }
+
+    fog.SkipStage()
+
+    if err = fog.SkipStage(); err == nil {
+        t.Error("Expected skipping past the end of the schedule to fail")
+    }
+}
+
+func TestRewindStageShouldRestartPreviousStage(t *testing.T) {
+    initial := model.Circle{Centre: model.Vector{0, 0}, Radius: 50}
+    first := model.Circle{Centre: model.Vector{0, 0}, Radius: 40}
+    second := model.Circle{Centre: model.Vector{0, 0}, Radius: 20}
+
+    fog := model.NewFog(initial)
+    err := fog.SetSchedule([]model.FogStage{stageTo(first, 5, 5),
+                                            stageTo(second, 5, 5)})
+
+    if err != nil {
+        t.Fatalf("Failed to set schedule: %s", err)
+    }
+
+    fog.Resume()
+    fog.Advance(12)
+
+    if err = fog.RewindStage(); err != nil {
+        t.Fatalf("Failed to rewind stage: %s", err)
+    }
+
+    if fog.StageIndex() != 0 || fog.Current() != initial {
+        t.Errorf("Expected to be back at the start of stage 0 (%+v) but " +
+                 "was on stage %v at %+v",
+                 initial,
+                 fog.StageIndex(),
+                 fog.Current())
+    }
+
+    if fog.StageTimeRemaining() != 10 {
+        t.Errorf("Expected the full 10 seconds of the stage to remain but " +
+                 "there were %v",
+                 fog.StageTimeRemaining())
+    }
+}
+
+func TestSetTargetShouldClearSchedule(t *testing.T) {
+    fog := model.NewFog(model.Circle{Radius: 50})
+    err := fog.SetSchedule([]model.FogStage{stageTo(model.Circle{Radius: 10},
+                                                    5,
+                                                    5)})
+
+    if err != nil {
+        t.Fatalf("Failed to set schedule: %s", err)
+    }
+
+    fog.SetTarget(model.Circle{Radius: 20})
+
+    if fog.Scheduled() || fog.StageIndex() != -1 {
+        t.Error("Expected setting the target by hand to clear the schedule")
+    }
+}
+
+func TestHoldingFogShouldHaveNoRate(t *testing.T) {
+    fog := model.NewFog(model.Circle{Radius: 50})
+    err := fog.SetSchedule([]model.FogStage{stageTo(model.Circle{Radius: 10},
+                                                    5,
+                                                    5)})
+
+    if err != nil {
+        t.Fatalf("Failed to set schedule: %s", err)
+    }
+
+    if fog.Rate() != (model.Rate{}) {
+        t.Errorf("Expected no rate while holding but it was %+v", fog.Rate())
+    }
+
+    fog.Resume()
+    fog.Advance(5)
+
+    if fog.Rate() == (model.Rate{}) {
+        t.Error("Expected a rate once the fog starts shrinking")
+    }
+}
+
+func TestInvalidTargetsShouldBeRejected(t *testing.T) {
+    nan := float32(math.NaN())
+    inf := float32(math.Inf(1))
+    initial := model.Circle{Radius: 50}
+
+    invalid := []model.Circle{
+        {Radius: -1},
+        {Radius: nan},
+        {Radius: inf},
+        {Centre: model.Vector{nan, 0}, Radius: 10},
+        {Centre: model.Vector{0, -inf}, Radius: 10},
+    }
+
+    for _, target := range invalid {
+        fog := model.NewFog(initial)
+        fog.SetTarget(initial)
+
+        err := fog.SetTarget(target)
+
+        if err == nil {
+            t.Errorf("Expected target %+v to be rejected", target)
+        }
+
+        if fog.Target() != initial {
+            t.Errorf("Expected rejected target %+v to leave target as %+v " +
+                     "but it was %+v",
+                     target,
+                     initial,
+                     fog.Target())
+        }
+    }
+}
+
+func TestInvalidPeriodsShouldBeRejected(t *testing.T) {
+    for _, period := range []float32{0, -1, float32(math.NaN()),
+                                     float32(math.Inf(1))} {
+        fog := model.NewFog(model.Circle{Radius: 50})
+        fog.SetTarget(model.Circle{Radius: 10})
+        fog.SetPeriod(10)
+
+        err := fog.SetPeriod(period)
+
+        if err == nil {
+            t.Errorf("Expected period %v to be rejected", period)
+        }
+
+        if rate := fog.Rate(); rate.Radius != -4 {
+            t.Errorf("Expected rejected period %v to keep the rate at -4 " +
+                     "but it was %+v",
+                     period,
+                     rate)
+        }
+    }
+}
+
+func TestInvalidAdvancesShouldBeRejected(t *testing.T) {
+    for _, amount := range []float32{-1, float32(math.NaN()),
+                                     float32(math.Inf(1))} {
+        initial := model.Circle{Radius: 50}
+        fog := model.NewFog(initial)
+        fog.SetTarget(model.Circle{Radius: 10})
+        fog.SetPeriod(10)
+        fog.Resume()
+
+        err := fog.Advance(amount)
+
+        if err == nil {
+            t.Errorf("Expected advancing by %v to be rejected", amount)
+        }
+
+        if fog.Current() != initial {
+            t.Errorf("Expected advancing by %v not to move the fog but it " +
+                     "is now %+v",
+                     amount,
+                     fog.Current())
+        }
+    }
+}
+
+func TestFogRulesShouldLimitTargets(t *testing.T) {
+    initial := model.Circle{Radius: 50}
+
+    inside := model.NewFog(initial)
+    inside.SetRules(model.FogRules{TargetInsideCurrent: true})
+
+    if err := inside.SetTarget(model.Circle{model.Vector{45, 0}, 10}); err == nil {
+        t.Error("Expected target poking out of the fog to be rejected")
+    }
+
+    if err := inside.SetTarget(model.Circle{model.Vector{40, 0}, 10}); err != nil {
+        t.Errorf("Expected target just inside the fog to be allowed: %s", err)
+    }
+
+    noGrowing := model.NewFog(initial)
+    noGrowing.SetRules(model.FogRules{NoGrowing: true})
+
+    if err := noGrowing.SetTarget(model.Circle{model.Vector{500, 0}, 60}); err == nil {
+        t.Error("Expected target bigger than the fog to be rejected")
+    }
+
+    if err := noGrowing.SetTarget(model.Circle{model.Vector{500, 0}, 50}); err != nil {
+        t.Errorf("Expected target the same size as the fog to be allowed: %s",
+                 err)
+    }
+}
+
+func TestFogRulesShouldApplyToEachStage(t *testing.T) {
+    fog := model.NewFog(model.Circle{Radius: 50})
+    fog.SetRules(model.FogRules{TargetInsideCurrent: true, NoGrowing: true})
+
+    err := fog.SetSchedule([]model.FogStage{
+        stageTo(model.Circle{model.Vector{20, 0}, 20}, 5, 5),
+        // Inside the fog to start with but not the first stage
+        stageTo(model.Circle{model.Vector{-20, 0}, 10}, 5, 5),
+    })
+
+    if err == nil {
+        t.Error("Expected stage outside the previous stage to be rejected")
+    }
+
+    if fog.Scheduled() {
+        t.Error("Expected rejected schedule not to be followed")
+    }
+
+    err = fog.SetSchedule([]model.FogStage{
+        stageTo(model.Circle{model.Vector{0, 0}, 10}, float32(math.NaN()), 0),
+    })
+
+    if err == nil {
+        t.Error("Expected NaN hold to be rejected")
+    }
+}
+
+func TestEasedMoveShouldFollowCurve(t *testing.T) {
+    initial := model.Circle{Centre: model.Vector{0, 0}, Radius: 100}
+    target := model.Circle{Centre: model.Vector{0, 0}, Radius: 20}
+
+    expected := map[string]float32{model.EasingLinear: 60,
+                                    model.EasingIn: 80,
+                                    model.EasingOut: 40,
+                                    model.EasingInOut: 60,
+                                    model.EasingStep: 60}
+
+    for easing, radius := range expected {
+        fog := model.NewFog(initial)
+
+        if err := fog.SetTargetEased(target, easing); err != nil {
+            t.Fatalf("Failed to set %s target: %s", easing, err)
+        }
+
+        fog.SetPeriod(10)
+        fog.Resume()
+        fog.Advance(5)
+
+        if fog.Current().(model.Circle).Radius != radius {
+            t.Errorf("Expected %s fog to have radius %v half way but it was %v",
+                     easing,
+                     radius,
+                     fog.Current().(model.Circle).Radius)
+        }
+
+        if fog.Easing() != easing {
+            t.Errorf("Expected fog easing to be %s but it was %s",
+                     easing,
+                     fog.Easing())
+        }
+
+        fog.Advance(5)
+
+        if fog.Current() != target || !fog.Paused() {
+            t.Errorf("Expected %s fog to stop at %+v but it was %+v",
+                     easing,
+                     target,
+                     fog.Current())
+        }
+    }
+}
+
+func TestUnknownEasingShouldBeRejected(t *testing.T) {
+    initial := model.Circle{Centre: model.Vector{0, 0}, Radius: 100}
+    target := model.Circle{Centre: model.Vector{0, 0}, Radius: 20}
+    fog := model.NewFog(initial)
+
+    if err := fog.SetTargetEased(target, "bounce"); err == nil {
+        t.Error("Expected an unknown easing to be rejected")
+    }
+
+    stages := []model.FogStage{stageTo(target, 0, 10)}
+    stages[0].Easing = "bounce"
+
+    if err := fog.SetSchedule(stages); err == nil {
+        t.Error("Expected a stage with an unknown easing to be rejected")
+    }
+}
@@ -0,0 +1,112 @@
+package model
+
+import (
+    "fmt"
+    "strings"
+)
+
+// Size of the map image in the same units as the fog, the map covers
+// (0, 0) to (Width, Height)
+type MapSize struct {
+    Width int
+    Height int
+}
+
+func (s MapSize) Known() bool {
+    return s.Width > 0 && s.Height > 0
+}
+
+func (s MapSize) Contains(point Vector) bool {
+    return point.X >= 0 && point.Y >= 0 &&
+           point.X <= float32(s.Width) && point.Y <= float32(s.Height)
+}
+
+func (s MapSize) ContainsShape(shape Shape) bool {
+    min, max := shape.Bounds()
+    return s.Contains(min) && s.Contains(max)
+}
+
+// The nearest point on the map
+func (s MapSize) Clamp(point Vector) Vector {
+    return Vector{X: clamp(point.X, 0, float32(s.Width)),
+                  Y: clamp(point.Y, 0, float32(s.Height))}
+}
+
+func clamp(value float32, min float32, max float32) float32 {
+    if value < min {
+        return min
+    }
+    if value > max {
+        return max
+    }
+    return value
+}
+
+// A map too small for the fog, or where it is heading, is turned away as
+// there is no sensible way to shrink it to fit. Tokens off the map are moved
+// to its edge.
+func (r *Room) SetMap(asset string, size MapSize) error {
+    if !size.Known() {
+        return invalidf("Map must have a positive size, got %+v", size)
+    }
+
+    off := make([]string, 0)
+
+    if !size.ContainsShape(r.fog.Current()) {
+        off = append(off, "the fog")
+    }
+
+    if !size.ContainsShape(r.fog.Target()) {
+        off = append(off, "the fog's target")
+    }
+
+    for i := r.fog.stage; r.fog.Scheduled() && i < len(r.fog.schedule); i++ {
+        if !size.ContainsShape(r.fog.schedule[i].Target.Value()) {
+            off = append(off, fmt.Sprintf("stage %d's target", i))
+        }
+    }
+
+    if len(off) > 0 {
+        return conflictf("The %dx%d map would leave %s off the map",
+                         size.Width,
+                         size.Height,
+                         strings.Join(off, ", "))
+    }
+
+    r.mapAsset = asset
+    r.mapSize = size
+
+    for i := range r.playerTokens {
+        r.playerTokens[i].Position = size.Clamp(r.playerTokens[i].Position)
+    }
+
+    return nil
+}
+
+// The whole of the shape has to be on the map, not just its middle
+func (r *Room) CheckShapeOnMap(shape Shape) error {
+    if r.mapSize.Known() && !r.mapSize.ContainsShape(shape) {
+        return invalidf("Shape %+v goes off the %dx%d map",
+                        shape,
+                        r.mapSize.Width,
+                        r.mapSize.Height)
+    }
+
+    return nil
+}
+
+// Zero if no map has been uploaded
+func (r *Room) MapSize() MapSize {
+    return r.mapSize
+}
+
+// Points have to be on the map, once the size of the map is known
+func (r *Room) CheckOnMap(point Vector) error {
+    if r.mapSize.Known() && !r.mapSize.Contains(point) {
+        return invalidf("Point %+v is off the %dx%d map",
+                        point,
+                        r.mapSize.Width,
+                        r.mapSize.Height)
+    }
+    return nil
+}
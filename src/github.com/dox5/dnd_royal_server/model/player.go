@@ -1,14 +1,50 @@
 package model
 
+import (
+    "regexp"
+)
+
+const MaxPlayerNameLength = 32
+
+var colourPattern = regexp.MustCompile("^#[0-9a-fA-F]{6}$")
+
 type player struct {
     id Identifier
+    name string
+    colour string
 }
 
 func NewPlayer() *player {
     return &player{id: MakeId()}
 }
 
+// A player who has joined a room, colour is like "#ff8800"
+func NewNamedPlayer(name string, colour string) (*player, error) {
+    if len(name) == 0 || len(name) > MaxPlayerNameLength {
+        return nil, invalidf("Player name must be 1 to %d characters long",
+                             MaxPlayerNameLength)
+    }
+
+    if !colourPattern.MatchString(colour) {
+        return nil, invalidf("Colour must look like #rrggbb, got %q", colour)
+    }
+
+    return &player{id: MakeId(), name: name, colour: colour}, nil
+}
+
 func (p *player) Id() Identifier {
     return p.id
 }
 
+func (p *player) Name() string {
+    return p.name
+}
+
+func (p *player) Colour() string {
+    return p.colour
+}
+
+// Recreate a player that already has an id (e.g. loaded from disk)
+func RestorePlayer(id Identifier) *player {
+    return &player{id: id}
+}
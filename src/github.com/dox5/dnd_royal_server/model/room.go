@@ -1,18 +1,35 @@
 package model
 
+import (
+    "sort"
+)
+
 type Room struct {
     id Identifier
     fog Fog
     gameMaster *player
     mapAsset string
+    mapSize MapSize
     playerTokens []Token
+    lastZoneSeed int64
+    players map[Identifier]*player
+    nextTokenId Identifier
+    removedTokens map[Identifier]bool
+
+    time float32
+    damagePerSecond float32
+    startingHitPoints float32
+    damageLog []DamageEvent
 }
 
 func NewRoom(gameMaster *player) *Room {
     return &Room{id: MakeId(),
+                 fog: *NewFog(Circle{}),
                  gameMaster: gameMaster,
-                 mapAsset: "CoolJenniMap",
-                 playerTokens: make([]Token, 0, 3)}
+                 playerTokens: make([]Token, 0, 3),
+                 players: make(map[Identifier]*player),
+                 removedTokens: make(map[Identifier]bool),
+                 startingHitPoints: DefaultHitPoints}
 }
 
 func (r *Room) GameMaster() * player {
@@ -27,24 +44,171 @@ func (r *Room) Fog() * Fog {
     return &r.fog
 }
 
+// Empty until a map has been uploaded
 func (r *Room) MapAsset() string {
     return r.mapAsset
 }
 
-func (r *Room) Update(timeDelta float32) {
-    r.fog.Advance(timeDelta)
+// Aim the fog at a random zone inside the current circle (and on the map once
+// there is one), remembering the seed so the choice can be reproduced
+func (r *Room) RandomTarget(radius float32,
+                            edgeBias float32,
+                            seed int64) (Circle, error) {
+    current, circular := r.fog.Current().(Circle)
+
+    if !circular {
+        return Circle{}, invalidf("Random targets need a circular fog, not a %s",
+                                  r.fog.Current().Kind())
+    }
+
+    generator := NewZoneGenerator(seed)
+    target, err := generator.NextZoneOnMap(current,
+                                           radius,
+                                           edgeBias,
+                                           r.mapSize)
+
+    if err != nil {
+        return Circle{}, err
+    }
+
+    err = r.fog.SetTarget(target)
+
+    if err != nil {
+        return Circle{}, err
+    }
+
+    r.lastZoneSeed = seed
+
+    return target, nil
 }
 
-func (r *Room) AddPlayerToken(position Vector) {
+func (r *Room) LastZoneSeed() int64 {
+    return r.lastZoneSeed
+}
+
+func (r *Room) Update(timeDelta float32) error {
+    err := r.fog.Advance(timeDelta)
+
+    if err != nil {
+        return err
+    }
+
+    r.time += timeDelta
+    r.applyZoneDamage(timeDelta)
+    return nil
+}
+
+// Tokens get ids in order, ids of removed tokens are never reused
+func (r *Room) AddPlayerToken(position Vector) Identifier {
     token := Token {
-        Id: Identifier(len(r.playerTokens)),
-        Position: position }
+        Id: r.nextTokenId,
+        Position: position,
+        HitPoints: r.startingHitPoints,
+        MaxHitPoints: r.startingHitPoints }
 
+    r.nextTokenId += 1
     r.playerTokens = append(r.playerTokens, token)
+
+    return token.Id
+}
+
+func (r *Room) AddNamedPlayerToken(position Vector, name string) (Identifier, error) {
+    if invalidVector(position) {
+        return 0, invalidf("Position must be a finite point, got %+v", position)
+    }
+
+    if err := r.CheckOnMap(position); err != nil {
+        return 0, err
+    }
+
+    if len(name) > MaxTokenNameLength {
+        return 0, invalidf("Token name must be at most %d characters long",
+                           MaxTokenNameLength)
+    }
+
+    id := r.AddPlayerToken(position)
+    r.playerTokens[len(r.playerTokens) - 1].Name = name
+
+    return id, nil
 }
 
+// A copy of the tokens, safe to use after the room is unlocked
 func (r *Room) GetPlayerTokens() []Token {
-    return r.playerTokens
+    tokens := make([]Token, len(r.playerTokens))
+    copy(tokens, r.playerTokens)
+    return tokens
+}
+
+// Like GetPlayerToken but says why the token couldn't be found
+func (r *Room) findPlayerToken(id Identifier) (*Token, error) {
+    token, found := r.GetPlayerToken(id)
+
+    if found {
+        return token, nil
+    }
+
+    if r.removedTokens[id] {
+        return nil, notFoundf("Token %v has been removed", id)
+    }
+
+    return nil, notFoundf("No token found with ID %v", id)
+}
+
+func (r *Room) RemovePlayerToken(id Identifier) error {
+    _, err := r.findPlayerToken(id)
+
+    if err != nil {
+        return err
+    }
+
+    for i := 0; i < len(r.playerTokens); i += 1 {
+        if r.playerTokens[i].Id == id {
+            r.playerTokens = append(r.playerTokens[:i], r.playerTokens[i+1:]...)
+            break
+        }
+    }
+
+    r.removedTokens[id] = true
+    return nil
+}
+
+func (r *Room) RenamePlayerToken(id Identifier, name string) error {
+    if len(name) > MaxTokenNameLength {
+        return invalidf("Token name must be at most %d characters long",
+                        MaxTokenNameLength)
+    }
+
+    token, err := r.findPlayerToken(id)
+
+    if err != nil {
+        return err
+    }
+
+    token.Name = name
+    return nil
+}
+
+func (r *Room) MovePlayerToken(id Identifier, position Vector) error {
+    if invalidVector(position) {
+        return invalidf("Position must be a finite point, got %+v", position)
+    }
+
+    if err := r.CheckOnMap(position); err != nil {
+        return err
+    }
+
+    token, err := r.findPlayerToken(id)
+
+    if err != nil {
+        return err
+    }
+
+    if token.Eliminated {
+        return conflictf("Token %v has been eliminated", id)
+    }
+
+    token.Position = position
+    return nil
 }
 
 func (r *Room) GetPlayerToken(id Identifier) (*Token, bool) {
@@ -56,3 +220,72 @@ func (r *Room) GetPlayerToken(id Identifier) (*Token, bool) {
     }
     return nil, false
 }
+
+// Add a player to the room, giving them a token. They get tokenId if asked for
+// (and nobody else has it), otherwise the first free token or a new one.
+func (r *Room) Join(joining *player, tokenId *Identifier) (*Token, error) {
+    var token *Token
+
+    if tokenId != nil {
+        var err error
+        token, err = r.findPlayerToken(*tokenId)
+
+        if err != nil {
+            return nil, err
+        }
+
+        if token.Owner != 0 {
+            return nil, conflictf("Token %v already belongs to a player",
+                                  *tokenId)
+        }
+    } else {
+        token = r.firstUnclaimedToken()
+
+        if token == nil {
+            token, _ = r.GetPlayerToken(r.AddPlayerToken(r.fog.Current().Centroid()))
+        }
+    }
+
+    token.Owner = joining.Id()
+    r.players[joining.Id()] = joining
+
+    return token, nil
+}
+
+func (r *Room) firstUnclaimedToken() *Token {
+    for i := 0; i < len(r.playerTokens); i += 1 {
+        if r.playerTokens[i].Owner == 0 {
+            return &r.playerTokens[i]
+        }
+    }
+    return nil
+}
+
+func (r *Room) Player(id Identifier) (*player, bool) {
+    p, found := r.players[id]
+    return p, found
+}
+
+func (r *Room) Players() []*player {
+    players := make([]*player, 0, len(r.players))
+
+    for _, p := range r.players {
+        players = append(players, p)
+    }
+
+    sort.Slice(players, func(i, j int) bool {
+        return players[i].id < players[j].id
+    })
+
+    return players
+}
+
+// The game master can move any token, players only their own
+func (r *Room) CanMoveToken(userId Identifier, tokenId Identifier) bool {
+    if userId == r.gameMaster.Id() {
+        return true
+    }
+
+    token, found := r.GetPlayerToken(tokenId)
+    return found && token.Owner != 0 && token.Owner == userId
+}
@@ -1,6 +1,10 @@
 package model_test
 
 import (
+    "encoding/json"
+    "math"
+    "reflect"
+    "strings"
     "testing"
 
     "github.com/dox5/dnd_royal_server/model"
@@ -26,6 +30,25 @@ func TestNewRoomShouldHaveNoPlayerTokens(t *testing.T) {
     }
 }
 
+func TestNewRoomFogShouldTakeTargetWithFiniteRate(t *testing.T) {
+    room := model.NewRoom(model.NewPlayer())
+
+    err := room.Fog().SetTarget(model.Circle{Centre: model.Vector{10, 0}})
+
+    if err != nil {
+        t.Fatalf("Failed to set target: %s", err)
+    }
+
+    rate := room.Fog().Rate()
+    values := []float32{rate.Radius, rate.Translation.X, rate.Translation.Y}
+
+    for _, value := range values {
+        if math.IsNaN(float64(value)) || math.IsInf(float64(value), 0) {
+            t.Errorf("Expected a finite rate but got %+v", rate)
+        }
+    }
+}
+
 func TestAddPlayerTokenToRoomShouldIncreaseLength(t *testing.T) {
     gm := model.NewPlayer()
     room := model.NewRoom(gm)
@@ -85,3 +108,369 @@ func TestAddPlayerTokenShouldBeGivenUniqueIdendifier(t *testing.T) {
         }
     }
 }
+
+func TestRestoredRoomShouldMatchSnapshot(t *testing.T) {
+    gm := model.NewPlayer()
+    room := model.NewRoom(gm)
+    room.AddPlayerToken(model.Vector{X: 3, Y: 4})
+
+    fog := room.Fog()
+    fog.SetPeriod(10)
+    fog.SetTarget(model.Circle{Centre: model.Vector{10, 10}, Radius: 5})
+    fog.Resume()
+
+    restored := model.RestoreRoom(room.Snapshot())
+
+    if restored.Id() != room.Id() {
+        t.Errorf("Expected restored room id to be %v but it was %v",
+                 room.Id(),
+                 restored.Id())
+    }
+
+    if restored.GameMaster().Id() != gm.Id() {
+        t.Errorf("Expected restored game master id to be %v but it was %v",
+                 gm.Id(),
+                 restored.GameMaster().Id())
+    }
+
+    if !reflect.DeepEqual(restored.Fog().Snapshot(), fog.Snapshot()) {
+        t.Errorf("Expected restored fog to be %+v but it was %+v",
+                 fog.Snapshot(),
+                 restored.Fog().Snapshot())
+    }
+
+    if restored.Fog().Rate() != fog.Rate() {
+        t.Errorf("Expected restored fog rate to be %+v but it was %+v",
+                 fog.Rate(),
+                 restored.Fog().Rate())
+    }
+
+    tokens := restored.GetPlayerTokens()
+    if len(tokens) != 1 || tokens[0] != room.GetPlayerTokens()[0] {
+        t.Errorf("Expected restored tokens to be %+v but they were %+v",
+                 room.GetPlayerTokens(),
+                 tokens)
+    }
+}
+
+func TestNamedPlayerShouldBeValidated(t *testing.T) {
+    cases := []struct {
+        name string
+        colour string
+    }{
+        {"", "#ff8800"},
+        {"A name that is far too long to fit on the board", "#ff8800"},
+        {"Jenni", "orange"},
+        {"Jenni", "#ff88"},
+    }
+
+    for _, c := range cases {
+        if _, err := model.NewNamedPlayer(c.name, c.colour); err == nil {
+            t.Errorf("Expected player %q with colour %q to be rejected",
+                     c.name,
+                     c.colour)
+        }
+    }
+}
+
+func TestJoinShouldClaimFirstFreeToken(t *testing.T) {
+    room := model.NewRoom(model.NewPlayer())
+    room.AddPlayerToken(model.Vector{})
+    room.AddPlayerToken(model.Vector{})
+
+    first, _ := model.NewNamedPlayer("First", "#ff0000")
+    second, _ := model.NewNamedPlayer("Second", "#00ff00")
+
+    firstToken, err := room.Join(first, nil)
+
+    if err != nil {
+        t.Fatalf("Failed to join: %s", err)
+    }
+
+    secondToken, err := room.Join(second, nil)
+
+    if err != nil {
+        t.Fatalf("Failed to join: %s", err)
+    }
+
+    if firstToken.Id == secondToken.Id {
+        t.Errorf("Expected players to get different tokens but both got %v",
+                 firstToken.Id)
+    }
+
+    if firstToken.Owner != first.Id() || secondToken.Owner != second.Id() {
+        t.Error("Expected tokens to be owned by the players that joined")
+    }
+
+    if len(room.Players()) != 2 {
+        t.Errorf("Expected 2 players in the room but there were %v",
+                 len(room.Players()))
+    }
+}
+
+func TestJoinWithNoFreeTokensShouldAddToken(t *testing.T) {
+    room := model.NewRoom(model.NewPlayer())
+    joining, _ := model.NewNamedPlayer("Late", "#0000ff")
+
+    token, err := room.Join(joining, nil)
+
+    if err != nil {
+        t.Fatalf("Failed to join: %s", err)
+    }
+
+    if len(room.GetPlayerTokens()) != 1 || token.Owner != joining.Id() {
+        t.Errorf("Expected a new token to be added for the player, tokens: %+v",
+                 room.GetPlayerTokens())
+    }
+}
+
+func TestJoinShouldNotClaimSomeoneElsesToken(t *testing.T) {
+    room := model.NewRoom(model.NewPlayer())
+    room.AddPlayerToken(model.Vector{})
+    tokenId := room.GetPlayerTokens()[0].Id
+
+    first, _ := model.NewNamedPlayer("First", "#ff0000")
+    second, _ := model.NewNamedPlayer("Second", "#00ff00")
+
+    if _, err := room.Join(first, &tokenId); err != nil {
+        t.Fatalf("Failed to join: %s", err)
+    }
+
+    if _, err := room.Join(second, &tokenId); err == nil {
+        t.Error("Expected claiming an owned token to fail")
+    }
+
+    missing := model.Identifier(999)
+    if _, err := room.Join(second, &missing); err == nil {
+        t.Error("Expected claiming a missing token to fail")
+    }
+}
+
+func TestOnlyOwnerAndGameMasterCanMoveToken(t *testing.T) {
+    gm := model.NewPlayer()
+    room := model.NewRoom(gm)
+    room.AddPlayerToken(model.Vector{})
+    room.AddPlayerToken(model.Vector{})
+
+    owner, _ := model.NewNamedPlayer("Owner", "#ff0000")
+    other, _ := model.NewNamedPlayer("Other", "#00ff00")
+
+    token, _ := room.Join(owner, nil)
+    room.Join(other, nil)
+
+    if !room.CanMoveToken(gm.Id(), token.Id) {
+        t.Error("Expected the game master to be able to move any token")
+    }
+
+    if !room.CanMoveToken(owner.Id(), token.Id) {
+        t.Error("Expected the owner to be able to move their token")
+    }
+
+    if room.CanMoveToken(other.Id(), token.Id) {
+        t.Error("Expected other players to not be able to move the token")
+    }
+}
+
+func TestRemovedTokenIdsShouldNotBeReused(t *testing.T) {
+    room := model.NewRoom(model.NewPlayer())
+    first := room.AddPlayerToken(model.Vector{})
+    second := room.AddPlayerToken(model.Vector{})
+
+    if err := room.RemovePlayerToken(first); err != nil {
+        t.Fatalf("Failed to remove token: %s", err)
+    }
+
+    third := room.AddPlayerToken(model.Vector{})
+
+    if third == first || third == second {
+        t.Errorf("Expected a new id for the token but got %v", third)
+    }
+
+    if len(room.GetPlayerTokens()) != 2 {
+        t.Errorf("Expected 2 tokens but there were %v",
+                 len(room.GetPlayerTokens()))
+    }
+}
+
+func TestRemovedTokenShouldRejectOperations(t *testing.T) {
+    room := model.NewRoom(model.NewPlayer())
+    id := room.AddPlayerToken(model.Vector{})
+
+    if err := room.RemovePlayerToken(id); err != nil {
+        t.Fatalf("Failed to remove token: %s", err)
+    }
+
+    if _, found := room.GetPlayerToken(id); found {
+        t.Error("Expected removed token to not be found")
+    }
+
+    if err := room.RemovePlayerToken(id); err == nil {
+        t.Error("Expected removing a token twice to fail")
+    }
+
+    if err := room.MovePlayerToken(id, model.Vector{X: 1}); err == nil {
+        t.Error("Expected moving a removed token to fail")
+    }
+
+    if err := room.RenamePlayerToken(id, "Ghost"); err == nil {
+        t.Error("Expected renaming a removed token to fail")
+    }
+
+    joining, _ := model.NewNamedPlayer("Late", "#0000ff")
+    if _, err := room.Join(joining, &id); err == nil {
+        t.Error("Expected claiming a removed token to fail")
+    }
+}
+
+func TestRenamePlayerTokenShouldSetName(t *testing.T) {
+    room := model.NewRoom(model.NewPlayer())
+    id := room.AddPlayerToken(model.Vector{})
+
+    if err := room.RenamePlayerToken(id, "Goblin"); err != nil {
+        t.Fatalf("Failed to rename token: %s", err)
+    }
+
+    token, _ := room.GetPlayerToken(id)
+    if token.Name != "Goblin" {
+        t.Errorf("Expected token name to be Goblin but it was %q", token.Name)
+    }
+
+    tooLong := "A name far too long to be shown next to the token"
+    if err := room.RenamePlayerToken(id, tooLong); err == nil {
+        t.Error("Expected a long name to be rejected")
+    }
+}
+
+func TestRestoredRoomShouldNotReuseTokenIds(t *testing.T) {
+    room := model.NewRoom(model.NewPlayer())
+    room.AddPlayerToken(model.Vector{})
+    removed := room.AddPlayerToken(model.Vector{})
+    room.RemovePlayerToken(removed)
+
+    // Through JSON as the room store would, ids are written as strings
+    encoded, err := json.Marshal(room.Snapshot())
+
+    if err != nil {
+        t.Fatalf("Failed to encode snapshot: %s", err)
+    }
+
+    var snapshot model.RoomSnapshot
+    if err := json.Unmarshal(encoded, &snapshot); err != nil {
+        t.Fatalf("Failed to decode snapshot %s: %s", encoded, err)
+    }
+
+    if !strings.Contains(string(encoded), `"NextTokenId":"`) {
+        t.Errorf("Expected NextTokenId to be written as a string in %s", encoded)
+    }
+
+    restored := model.RestoreRoom(snapshot)
+
+    if id := restored.AddPlayerToken(model.Vector{}); id == removed {
+        t.Errorf("Expected a fresh token id but reused %v", id)
+    }
+
+    if err := restored.MovePlayerToken(removed, model.Vector{}); err == nil {
+        t.Error("Expected removed token to stay removed after restore")
+    }
+}
+
+func TestAddNamedPlayerTokenShouldRejectBadTokens(t *testing.T) {
+    room := model.NewRoom(model.NewPlayer())
+    nan := float32(math.NaN())
+
+    if _, err := room.AddNamedPlayerToken(model.Vector{nan, 0}, "Bad"); err == nil {
+        t.Error("Expected a NaN position to be rejected")
+    }
+
+    if len(room.GetPlayerTokens()) != 0 {
+        t.Error("Expected a rejected token to not be added")
+    }
+
+    id, err := room.AddNamedPlayerToken(model.Vector{1, 2}, "Orc")
+
+    if err != nil {
+        t.Fatalf("Failed to add token: %s", err)
+    }
+
+    token, _ := room.GetPlayerToken(id)
+    if token.Name != "Orc" || token.Position != (model.Vector{1, 2}) {
+        t.Errorf("Expected token Orc at {1, 2} but got %+v", token)
+    }
+}
+
+func TestSmallerMapShouldNotLeaveFogOrTokensOff(t *testing.T) {
+    fog := model.Circle{Centre: model.Vector{50, 50}, Radius: 40}
+    config := model.RoomConfig{Fog: model.AnyShape{Shape: fog},
+                               Positions: []model.Vector{{500, 10}}}
+    room, err := model.NewRoomFromConfig(model.NewPlayer(), config)
+
+    if err != nil {
+        t.Fatalf("Failed to create room: %s", err)
+    }
+
+    err = room.SetMap("small.png", model.MapSize{Width: 60, Height: 60})
+
+    if err == nil || !strings.Contains(err.Error(), "the fog") {
+        t.Errorf("Expected a map smaller than the fog to be rejected, got %v", err)
+    }
+
+    if room.MapSize().Known() {
+        t.Errorf("Expected the rejected map not to be used but got %+v",
+                 room.MapSize())
+    }
+
+    err = room.SetMap("map.png", model.MapSize{Width: 100, Height: 100})
+
+    if err != nil {
+        t.Fatalf("Expected a map around the fog to be used: %s", err)
+    }
+
+    token := room.GetPlayerTokens()[0]
+    if token.Position != (model.Vector{100, 10}) {
+        t.Errorf("Expected the token to be moved to the edge of the map but it was at %+v",
+                 token.Position)
+    }
+}
+
+func TestPointsShouldBeBoundedByMap(t *testing.T) {
+    room := model.NewRoom(model.NewPlayer())
+
+    if err := room.CheckOnMap(model.Vector{-500, 9000}); err != nil {
+        t.Errorf("Expected any point to be allowed without a map: %s", err)
+    }
+
+    err := room.SetMap("map.png", model.MapSize{Width: 100, Height: 50})
+
+    if err != nil {
+        t.Fatalf("Failed to set map: %s", err)
+    }
+
+    if err := room.CheckOnMap(model.Vector{50, 25}); err != nil {
+        t.Errorf("Expected point on the map to be allowed: %s", err)
+    }
+
+    if err := room.CheckOnMap(model.Vector{50, 51}); err == nil {
+        t.Error("Expected point off the map to be rejected")
+    }
+
+    // Big shapes can go off the map even with their middle on it
+    if err := room.CheckShapeOnMap(model.Circle{Centre: model.Vector{50, 25},
+                                                Radius: 20}); err != nil {
+        t.Errorf("Expected a circle on the map to be allowed: %s", err)
+    }
+
+    if err := room.CheckShapeOnMap(model.Circle{Centre: model.Vector{50, 25},
+                                                Radius: 30}); err == nil {
+        t.Error("Expected a circle hanging off the map to be rejected")
+    }
+
+    id := room.AddPlayerToken(model.Vector{10, 10})
+
+    if err := room.MovePlayerToken(id, model.Vector{101, 10}); err == nil {
+        t.Error("Expected a token moved off the map to be rejected")
+    }
+
+    if err := room.SetMap("map.png", model.MapSize{}); err == nil {
+        t.Error("Expected a map with no size to be rejected")
+    }
+}
@@ -0,0 +1,211 @@
+package model
+
+import (
+    "math"
+    "strings"
+)
+
+const (
+    // Tokens in a column to the left of the fog
+    PlacementLine = "line"
+    // Tokens evenly spaced on a circle around the fog centre
+    PlacementRing = "ring"
+    // Tokens anywhere inside the fog
+    PlacementRandom = "random"
+
+    MaxTokensPerRoom = 100
+
+    tokenSpacing = 50
+)
+
+var placements = []string{PlacementLine, PlacementRing, PlacementRandom}
+
+// How a room should be set up when it is created. Either give the Positions
+// of the tokens or the number of Tokens and a Placement to lay them out.
+type RoomConfig struct {
+    Tokens int
+    Positions []Vector
+    Placement string
+    // Distance of the tokens from the fog centre for ring placement, defaults
+    // to half way to the nearest side of the fog
+    RingRadius float32
+    // Used for random placement, 0 picks one
+    Seed int64 `json:",string"`
+    // Any shape, an empty circle if not given
+    Fog AnyShape
+    // Limits on where the fog can be sent, none by default
+    FogRules FogRules
+    // Hit points every token starts with, 0 gives DefaultHitPoints
+    HitPoints float32
+    // Damage dealt to tokens outside the fog, 0 for none
+    DamagePerSecond float32
+}
+
+func DefaultRoomConfig() RoomConfig {
+    return RoomConfig{Tokens: 3, Placement: PlacementLine}
+}
+
+func invalidFloat(value float32) bool {
+    return math.IsNaN(float64(value)) || math.IsInf(float64(value), 0)
+}
+
+func invalidVector(v Vector) bool {
+    return invalidFloat(v.X) || invalidFloat(v.Y)
+}
+
+func (c RoomConfig) placement() string {
+    if c.Placement == "" {
+        return PlacementLine
+    }
+    return c.Placement
+}
+
+func (c RoomConfig) hitPoints() float32 {
+    if c.HitPoints == 0 {
+        return DefaultHitPoints
+    }
+    return c.HitPoints
+}
+
+func (c RoomConfig) Validate() error {
+    if c.Tokens < 0 || c.Tokens > MaxTokensPerRoom {
+        return invalidf("Tokens must be between 0 and %d, got %d",
+                        MaxTokensPerRoom,
+                        c.Tokens)
+    }
+
+    if err := c.Fog.Value().Validate(); err != nil {
+        return invalidf("Fog: %s", err)
+    }
+
+    if c.HitPoints < 0 || invalidFloat(c.HitPoints) {
+        return invalidf("HitPoints must be a positive number (or 0 for %v), got %v",
+                        DefaultHitPoints,
+                        c.HitPoints)
+    }
+
+    if c.DamagePerSecond < 0 || invalidFloat(c.DamagePerSecond) {
+        return invalidf("DamagePerSecond must be a positive number, got %v",
+                        c.DamagePerSecond)
+    }
+
+    if len(c.Positions) > 0 {
+        if len(c.Positions) > MaxTokensPerRoom {
+            return invalidf("At most %d Positions can be given, got %d",
+                            MaxTokensPerRoom,
+                            len(c.Positions))
+        }
+
+        if c.Tokens != 0 && c.Tokens != len(c.Positions) {
+            return invalidf("Positions has %d entries but Tokens is %d",
+                            len(c.Positions),
+                            c.Tokens)
+        }
+
+        for i, position := range c.Positions {
+            if invalidVector(position) {
+                return invalidf("Position %d is not a finite point: %+v",
+                                i,
+                                position)
+            }
+        }
+
+        return nil
+    }
+
+    switch c.placement() {
+    case PlacementLine:
+    case PlacementRing:
+        if c.RingRadius < 0 || invalidFloat(c.RingRadius) {
+            return invalidf("RingRadius must be a positive number, got %v",
+                            c.RingRadius)
+        }
+    case PlacementRandom:
+        if c.Tokens > 0 && c.Fog.Value().Area() <= 0 {
+            return invalidf("Random placement needs a fog with a positive area")
+        }
+    default:
+        return invalidf("Unknown placement %q, expected one of %s",
+                        c.Placement,
+                        strings.Join(placements, ", "))
+    }
+
+    return nil
+}
+
+// Where each token should start, the config must be valid
+func (c RoomConfig) StartingPositions() []Vector {
+    if len(c.Positions) > 0 {
+        positions := make([]Vector, len(c.Positions))
+        copy(positions, c.Positions)
+        return positions
+    }
+
+    positions := make([]Vector, c.Tokens)
+    fog := c.Fog.Value()
+    centre := fog.Centroid()
+    min, max := fog.Bounds()
+
+    switch c.placement() {
+    case PlacementLine:
+        left := min.X - tokenSpacing
+        for i := range positions {
+            positions[i] = Vector{X: left,
+                                  Y: centre.Y + float32(tokenSpacing * i)}
+        }
+
+    case PlacementRing:
+        radius := c.RingRadius
+        if radius == 0 {
+            radius = float32(math.Min(float64(max.X - min.X),
+                                      float64(max.Y - min.Y))) / 4
+        }
+
+        for i := range positions {
+            angle := 2 * math.Pi * float64(i) / float64(len(positions))
+            offset := Vector{X: float32(math.Cos(angle)) * radius,
+                             Y: float32(math.Sin(angle)) * radius}
+            positions[i] = centre.Add(offset)
+        }
+
+    case PlacementRandom:
+        generator := NewZoneGenerator(c.Seed)
+        for i := range positions {
+            positions[i] = generator.RandomPointIn(fog)
+        }
+    }
+
+    return positions
+}
+
+func NewRoomFromConfig(gameMaster *player, config RoomConfig) (*Room, error) {
+    err := config.Validate()
+
+    if err != nil {
+        return nil, err
+    }
+
+    if config.Seed == 0 && config.placement() == PlacementRandom {
+        config.Seed = int64(MakeId())
+    }
+
+    room := NewRoom(gameMaster)
+    room.damagePerSecond = config.DamagePerSecond
+    room.startingHitPoints = config.hitPoints()
+
+    room.fog = *NewFog(config.Fog.Value())
+    room.fog.SetRules(config.FogRules)
+
+    // Stay put until the game master picks a target
+    err = room.fog.SetTarget(config.Fog.Value())
+
+    if err != nil {
+        return nil, err
+    }
+
+    for _, position := range config.StartingPositions() {
+        room.AddPlayerToken(position)
+    }
+
+    return room, nil
+}
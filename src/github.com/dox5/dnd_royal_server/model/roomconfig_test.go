@@ -0,0 +1,207 @@
+package model_test
+
+import (
+    "math"
+    "testing"
+
+    "github.com/dox5/dnd_royal_server/model"
+)
+
+func TestDefaultRoomConfigShouldMatchOriginalLayout(t *testing.T) {
+    room, err := model.NewRoomFromConfig(model.NewPlayer(),
+                                         model.DefaultRoomConfig())
+
+    if err != nil {
+        t.Fatalf("Failed to create room: %s", err)
+    }
+
+    tokens := room.GetPlayerTokens()
+
+    if len(tokens) != 3 {
+        t.Fatalf("Expected 3 tokens but there were %v", len(tokens))
+    }
+
+    for i, token := range tokens {
+        expected := model.Vector{X: -50, Y: float32(50 * i)}
+
+        if token.Position != expected {
+            t.Errorf("Expected token %v at %+v but it was at %+v",
+                     i,
+                     expected,
+                     token.Position)
+        }
+    }
+}
+
+func TestExplicitPositionsShouldBeUsed(t *testing.T) {
+    positions := []model.Vector{{1, 2}, {3, 4}}
+    config := model.RoomConfig{Positions: positions}
+
+    room, err := model.NewRoomFromConfig(model.NewPlayer(), config)
+
+    if err != nil {
+        t.Fatalf("Failed to create room: %s", err)
+    }
+
+    tokens := room.GetPlayerTokens()
+
+    if len(tokens) != len(positions) {
+        t.Fatalf("Expected %v tokens but there were %v",
+                 len(positions),
+                 len(tokens))
+    }
+
+    for i, token := range tokens {
+        if token.Position != positions[i] {
+            t.Errorf("Expected token %v at %+v but it was at %+v",
+                     i,
+                     positions[i],
+                     token.Position)
+        }
+    }
+}
+
+func TestRingPlacementShouldSurroundFogCentre(t *testing.T) {
+    fog := model.Circle{Centre: model.Vector{100, 100}, Radius: 80}
+    config := model.RoomConfig{Tokens: 6,
+                               Placement: model.PlacementRing,
+                               Fog: model.AnyShape{Shape: fog}}
+
+    room, err := model.NewRoomFromConfig(model.NewPlayer(), config)
+
+    if err != nil {
+        t.Fatalf("Failed to create room: %s", err)
+    }
+
+    for _, token := range room.GetPlayerTokens() {
+        distance := token.Position.Sub(fog.Centre).Magnatude()
+
+        if math.Abs(float64(distance - 40)) > 0.001 {
+            t.Errorf("Expected token to be 40 from the centre but it was %v",
+                     distance)
+        }
+    }
+}
+
+func TestRandomPlacementShouldBeInsideFogAndReproducible(t *testing.T) {
+    fog := model.Circle{Centre: model.Vector{-20, 30}, Radius: 60}
+    config := model.RoomConfig{Tokens: 20,
+                               Placement: model.PlacementRandom,
+                               Seed: 99,
+                               Fog: model.AnyShape{Shape: fog}}
+
+    first := config.StartingPositions()
+    second := config.StartingPositions()
+
+    for i, position := range first {
+        if !fog.Contains(model.Circle{Centre: position}) {
+            t.Errorf("Token %v at %+v is outside the fog %+v", i, position, fog)
+        }
+
+        if position != second[i] {
+            t.Errorf("Expected the same seed to give the same positions")
+        }
+    }
+}
+
+func TestNewRoomFromConfigShouldStartFogAtConfig(t *testing.T) {
+    fog := model.Circle{Centre: model.Vector{5, 5}, Radius: 500}
+    config := model.RoomConfig{Fog: model.AnyShape{Shape: fog}}
+
+    room, err := model.NewRoomFromConfig(model.NewPlayer(), config)
+
+    if err != nil {
+        t.Fatalf("Failed to create room: %s", err)
+    }
+
+    room.Fog().Resume()
+    room.Update(10)
+
+    if room.Fog().Current() != fog {
+        t.Errorf("Expected fog to stay at %+v until given a target but it was %+v",
+                 fog,
+                 room.Fog().Current())
+    }
+}
+
+func TestHitPointsShouldSurviveRestore(t *testing.T) {
+    for _, hitPoints := range []float32{0, 25} {
+        config := model.RoomConfig{Tokens: 1, HitPoints: hitPoints}
+        room, err := model.NewRoomFromConfig(model.NewPlayer(), config)
+
+        if err != nil {
+            t.Fatalf("Failed to create room: %s", err)
+        }
+
+        expected := hitPoints
+        if expected == 0 {
+            expected = model.DefaultHitPoints
+        }
+
+        restored := model.RestoreRoom(room.Snapshot())
+        token := restored.GetPlayerTokens()[0]
+
+        if token.HitPoints != expected || token.MaxHitPoints != expected {
+            t.Errorf("Expected a token with %v hit points but got %+v",
+                     expected,
+                     token)
+        }
+
+        added, _ := restored.GetPlayerToken(restored.AddPlayerToken(model.Vector{}))
+
+        if added.HitPoints != expected {
+            t.Errorf("Expected new tokens to start with %v hit points but got %+v",
+                     expected,
+                     added)
+        }
+    }
+}
+
+func TestInvalidRoomConfigsShouldBeRejected(t *testing.T) {
+    nan := float32(math.NaN())
+
+    cases := []struct {
+        name string
+        config model.RoomConfig
+    }{
+        {"negative tokens", model.RoomConfig{Tokens: -1}},
+        {"too many tokens", model.RoomConfig{Tokens: model.MaxTokensPerRoom + 1}},
+        {"unknown placement", model.RoomConfig{Tokens: 1, Placement: "spiral"}},
+        {"negative fog radius",
+         model.RoomConfig{Fog: model.AnyShape{Shape: model.Circle{Radius: -1}}}},
+        {"NaN fog centre",
+         model.RoomConfig{Fog: model.AnyShape{
+             Shape: model.Circle{Centre: model.Vector{nan, 0}}}}},
+        {"positions don't match tokens",
+         model.RoomConfig{Tokens: 3, Positions: []model.Vector{{0, 0}}}},
+        {"NaN position",
+         model.RoomConfig{Positions: []model.Vector{{0, nan}}}},
+        {"random placement without fog",
+         model.RoomConfig{Tokens: 2, Placement: model.PlacementRandom}},
+        {"negative ring radius",
+         model.RoomConfig{Tokens: 2,
+                          Placement: model.PlacementRing,
+                          RingRadius: -5}},
+        {"negative hit points", model.RoomConfig{HitPoints: -10}},
+        {"NaN hit points", model.RoomConfig{HitPoints: nan}},
+    }
+
+    for _, c := range cases {
+        if _, err := model.NewRoomFromConfig(model.NewPlayer(), c.config); err == nil {
+            t.Errorf("Expected %s to be rejected", c.name)
+        }
+    }
+}
+
+func TestPolygonFogShouldBeAllowedInsideItself(t *testing.T) {
+    config := model.DefaultRoomConfig()
+    config.Fog = model.AnyShape{Shape: model.Polygon{
+        Points: []model.Vector{{0, 0}, {100, 0}, {100, 100}, {0, 100}}}}
+    config.FogRules = model.FogRules{TargetInsideCurrent: true}
+
+    _, err := model.NewRoomFromConfig(model.NewPlayer(), config)
+
+    if err != nil {
+        t.Errorf("Expected a polygon fog to stay inside itself but got %s", err)
+    }
+}
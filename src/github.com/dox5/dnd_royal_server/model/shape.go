@@ -0,0 +1,597 @@
+package model
+
+import (
+    "bytes"
+    "encoding/json"
+    "math"
+)
+
+const (
+    ShapeCircle = "circle"
+    ShapeRectangle = "rectangle"
+    ShapeEllipse = "ellipse"
+    ShapePolygon = "polygon"
+
+    // Points taken around curved shapes when checking one is inside another
+    outlinePoints = 32
+    // How far from a polygon edge a point can be and still be on it
+    edgeTolerance = 1e-3
+)
+
+// An area the fog can cover. The fog can only move between shapes of the
+// same kind, see Towards.
+type Shape interface {
+    Kind() string
+    Validate() error
+    ContainsPoint(point Vector) bool
+    // Close enough to the same shape, allowing for float32 rounding
+    Equal(other Shape) bool
+    Centroid() Vector
+    // The corners of a box around the shape
+    Bounds() (Vector, Vector)
+    Area() float32
+    // Points around the edge
+    Outline() []Vector
+    // The shape progress (0 to 1) of the way to target, which must be of the
+    // same kind
+    Towards(target Shape, progress float64) Shape
+}
+
+func lerp(from float32, to float32, progress float64) float32 {
+    return float32(float64(from) + (float64(to) - float64(from)) * progress)
+}
+
+func lerpVector(from Vector, to Vector, progress float64) Vector {
+    return Vector{X: lerp(from.X, to.X, progress),
+                  Y: lerp(from.Y, to.Y, progress)}
+}
+
+// Whether the fog can move smoothly from one shape to the other
+func checkMove(from Shape, to Shape) error {
+    if from.Kind() != to.Kind() {
+        return invalidf("The fog can't change from a %s to a %s",
+                        from.Kind(),
+                        to.Kind())
+    }
+
+    if polygon, ok := from.(Polygon); ok {
+        points := len(to.(Polygon).Points)
+
+        if points != len(polygon.Points) {
+            return invalidf("Polygon needs the same %d points as the fog, " +
+                            "got %d",
+                            len(polygon.Points),
+                            points)
+        }
+    }
+
+    return nil
+}
+
+// True if inner lies entirely within outer. Only circles are checked
+// exactly, otherwise it is the outline of inner that has to fit. Every other
+// outer shape is convex apart from polygons, where the edges of inner also
+// mustn't cross out through the edges of outer.
+func Encloses(outer Shape, inner Shape) bool {
+    outerCircle, outerIsCircle := outer.(Circle)
+    innerCircle, innerIsCircle := inner.(Circle)
+
+    if outerIsCircle && innerIsCircle {
+        return outerCircle.Contains(innerCircle)
+    }
+
+    outline := inner.Outline()
+
+    for _, point := range outline {
+        if !outer.ContainsPoint(point) {
+            return false
+        }
+    }
+
+    polygon, outerIsPolygon := outer.(Polygon)
+
+    if !outerIsPolygon {
+        return true
+    }
+
+    for i, j := 0, len(outline) - 1; i < len(outline); j, i = i, i + 1 {
+        a, b := outline[j], outline[i]
+
+        if !polygon.ContainsPoint(lerpVector(a, b, 0.5)) ||
+           polygon.crosses(a, b) {
+            return false
+        }
+    }
+
+    return true
+}
+
+// Twice the signed area of the triangle a, b, c, positive when anticlockwise
+func cross(a Vector, b Vector, c Vector) float64 {
+    return (float64(b.X) - float64(a.X)) * (float64(c.Y) - float64(a.Y)) -
+           (float64(b.Y) - float64(a.Y)) * (float64(c.X) - float64(a.X))
+}
+
+// True if the segments a-b and c-d cross each other, touching doesn't count
+func segmentsCross(a Vector, b Vector, c Vector, d Vector) bool {
+    return cross(a, b, c) * cross(a, b, d) < 0 &&
+           cross(c, d, a) * cross(c, d, b) < 0
+}
+
+// True if point lies on the segment a-b, give or take edgeTolerance
+func onSegment(point Vector, a Vector, b Vector) bool {
+    length := float64(b.Sub(a).Magnatude())
+
+    if length == 0 {
+        return float64(point.Sub(a).Magnatude()) <= edgeTolerance
+    }
+
+    if math.Abs(cross(a, b, point)) / length > edgeTolerance {
+        return false
+    }
+
+    // Past either end the point is only on the segment near the end itself
+    along := (float64(point.X - a.X) * float64(b.X - a.X) +
+              float64(point.Y - a.Y) * float64(b.Y - a.Y)) / length
+    return along >= -edgeTolerance && along <= length + edgeTolerance
+}
+
+// Points around an ellipse, a circle being one with equal radii
+func ellipseOutline(centre Vector, radiusX float32, radiusY float32) []Vector {
+    points := make([]Vector, outlinePoints)
+
+    for i := range points {
+        angle := 2 * math.Pi * float64(i) / outlinePoints
+        points[i] = Vector{X: centre.X + radiusX * float32(math.Cos(angle)),
+                           Y: centre.Y + radiusY * float32(math.Sin(angle))}
+    }
+
+    return points
+}
+
+func (c Circle) Kind() string {
+    return ShapeCircle
+}
+
+func (c Circle) Centroid() Vector {
+    return c.Centre
+}
+
+func (c Circle) Bounds() (Vector, Vector) {
+    return c.Centre.SubScalar(c.Radius), c.Centre.AddScalar(c.Radius)
+}
+
+func (c Circle) Area() float32 {
+    return math.Pi * c.Radius * c.Radius
+}
+
+func (c Circle) Outline() []Vector {
+    return ellipseOutline(c.Centre, c.Radius, c.Radius)
+}
+
+func (c Circle) Towards(target Shape, progress float64) Shape {
+    to, ok := target.(Circle)
+
+    if !ok {
+        return c
+    }
+
+    return Circle{Centre: lerpVector(c.Centre, to.Centre, progress),
+                  Radius: lerp(c.Radius, to.Radius, progress)}
+}
+
+// An axis aligned rectangle, good for corridors
+type Rectangle struct {
+    Centre Vector
+    Width  float32
+    Height float32
+}
+
+func (r Rectangle) Kind() string {
+    return ShapeRectangle
+}
+
+func (r Rectangle) Validate() error {
+    if invalidVector(r.Centre) ||
+       invalidFloat(r.Width) || invalidFloat(r.Height) ||
+       r.Width < 0 || r.Height < 0 {
+        return invalidf("Rectangle must have a finite centre and a width " +
+                        "and height of at least 0, got %+v",
+                        r)
+    }
+    return nil
+}
+
+func (r Rectangle) ContainsPoint(point Vector) bool {
+    min, max := r.Bounds()
+    return point.X >= min.X && point.X <= max.X &&
+           point.Y >= min.Y && point.Y <= max.Y
+}
+
+func (r Rectangle) Equal(other Shape) bool {
+    o, ok := other.(Rectangle)
+    return ok &&
+           r.Centre.Equal(o.Centre) &&
+           float32Equal(r.Width, o.Width) &&
+           float32Equal(r.Height, o.Height)
+}
+
+func (r Rectangle) Centroid() Vector {
+    return r.Centre
+}
+
+func (r Rectangle) Bounds() (Vector, Vector) {
+    half := Vector{X: r.Width / 2, Y: r.Height / 2}
+    return r.Centre.Sub(half), r.Centre.Add(half)
+}
+
+func (r Rectangle) Area() float32 {
+    return r.Width * r.Height
+}
+
+func (r Rectangle) Outline() []Vector {
+    min, max := r.Bounds()
+    return []Vector{min,
+                    Vector{X: max.X, Y: min.Y},
+                    max,
+                    Vector{X: min.X, Y: max.Y}}
+}
+
+func (r Rectangle) Towards(target Shape, progress float64) Shape {
+    to, ok := target.(Rectangle)
+
+    if !ok {
+        return r
+    }
+
+    return Rectangle{Centre: lerpVector(r.Centre, to.Centre, progress),
+                     Width: lerp(r.Width, to.Width, progress),
+                     Height: lerp(r.Height, to.Height, progress)}
+}
+
+// An axis aligned ellipse, good for islands
+type Ellipse struct {
+    Centre  Vector
+    RadiusX float32
+    RadiusY float32
+}
+
+func (e Ellipse) Kind() string {
+    return ShapeEllipse
+}
+
+func (e Ellipse) Validate() error {
+    if invalidVector(e.Centre) ||
+       invalidFloat(e.RadiusX) || invalidFloat(e.RadiusY) ||
+       e.RadiusX < 0 || e.RadiusY < 0 {
+        return invalidf("Ellipse must have a finite centre and radii of at " +
+                        "least 0, got %+v",
+                        e)
+    }
+    return nil
+}
+
+func (e Ellipse) ContainsPoint(point Vector) bool {
+    offset := point.Sub(e.Centre)
+
+    if e.RadiusX == 0 || e.RadiusY == 0 {
+        return offset.X == 0 && offset.Y == 0
+    }
+
+    x := float64(offset.X / e.RadiusX)
+    y := float64(offset.Y / e.RadiusY)
+    // Allow for a little float32 rounding on the boundary
+    return x * x + y * y <= 1 + 1e-5
+}
+
+func (e Ellipse) Equal(other Shape) bool {
+    o, ok := other.(Ellipse)
+    return ok &&
+           e.Centre.Equal(o.Centre) &&
+           float32Equal(e.RadiusX, o.RadiusX) &&
+           float32Equal(e.RadiusY, o.RadiusY)
+}
+
+func (e Ellipse) Centroid() Vector {
+    return e.Centre
+}
+
+func (e Ellipse) Bounds() (Vector, Vector) {
+    radii := Vector{X: e.RadiusX, Y: e.RadiusY}
+    return e.Centre.Sub(radii), e.Centre.Add(radii)
+}
+
+func (e Ellipse) Area() float32 {
+    return math.Pi * e.RadiusX * e.RadiusY
+}
+
+func (e Ellipse) Outline() []Vector {
+    return ellipseOutline(e.Centre, e.RadiusX, e.RadiusY)
+}
+
+func (e Ellipse) Towards(target Shape, progress float64) Shape {
+    to, ok := target.(Ellipse)
+
+    if !ok {
+        return e
+    }
+
+    return Ellipse{Centre: lerpVector(e.Centre, to.Centre, progress),
+                   RadiusX: lerp(e.RadiusX, to.RadiusX, progress),
+                   RadiusY: lerp(e.RadiusY, to.RadiusY, progress)}
+}
+
+// Any outline that doesn't cross itself, points in order around the edge
+type Polygon struct {
+    Points []Vector
+}
+
+func (p Polygon) Kind() string {
+    return ShapePolygon
+}
+
+func (p Polygon) Validate() error {
+    if len(p.Points) < 3 {
+        return invalidf("Polygon must have at least 3 points, got %d",
+                        len(p.Points))
+    }
+
+    for i, point := range p.Points {
+        if invalidVector(point) {
+            return invalidf("Polygon point %d is not a finite point: %+v",
+                            i,
+                            point)
+        }
+    }
+
+    // Every pair of edges that don't share a corner
+    n := len(p.Points)
+    for i := 0; i < n; i++ {
+        for j := i + 2; j < n; j++ {
+            if i == 0 && j == n - 1 {
+                continue
+            }
+
+            if segmentsCross(p.Points[i], p.Points[i + 1],
+                             p.Points[j], p.Points[(j + 1) % n]) {
+                return invalidf("Polygon edges %d and %d cross each other",
+                                i,
+                                j)
+            }
+        }
+    }
+
+    return nil
+}
+
+// Counts how many edges a line out to the right crosses. Points on the edge
+// are inside, as they are for the other shapes.
+func (p Polygon) ContainsPoint(point Vector) bool {
+    inside := false
+
+    for i, j := 0, len(p.Points) - 1; i < len(p.Points); j, i = i, i + 1 {
+        a, b := p.Points[i], p.Points[j]
+
+        if onSegment(point, a, b) {
+            return true
+        }
+
+        if (a.Y > point.Y) == (b.Y > point.Y) {
+            continue
+        }
+
+        crossing := a.X + (point.Y - a.Y) / (b.Y - a.Y) * (b.X - a.X)
+        if point.X <= crossing {
+            inside = !inside
+        }
+    }
+
+    return inside
+}
+
+// True if the segment a-b crosses any edge of the polygon
+func (p Polygon) crosses(a Vector, b Vector) bool {
+    for i, j := 0, len(p.Points) - 1; i < len(p.Points); j, i = i, i + 1 {
+        if segmentsCross(a, b, p.Points[j], p.Points[i]) {
+            return true
+        }
+    }
+
+    return false
+}
+
+func (p Polygon) Equal(other Shape) bool {
+    o, ok := other.(Polygon)
+
+    if !ok || len(o.Points) != len(p.Points) {
+        return false
+    }
+
+    for i := range p.Points {
+        if !p.Points[i].Equal(o.Points[i]) {
+            return false
+        }
+    }
+
+    return true
+}
+
+// Twice the area, positive when the points go anticlockwise
+func (p Polygon) signedArea2() float64 {
+    area := 0.0
+
+    for i, j := 0, len(p.Points) - 1; i < len(p.Points); j, i = i, i + 1 {
+        a, b := p.Points[j], p.Points[i]
+        area += float64(a.X) * float64(b.Y) - float64(b.X) * float64(a.Y)
+    }
+
+    return area
+}
+
+func (p Polygon) Centroid() Vector {
+    area2 := p.signedArea2()
+
+    // A polygon shrunk to nothing has no area to balance, use its points
+    if area2 == 0 {
+        var sum Vector
+        for _, point := range p.Points {
+            sum = sum.Add(point)
+        }
+        return sum.DivideScalar(float32(len(p.Points)))
+    }
+
+    var x, y float64
+    for i, j := 0, len(p.Points) - 1; i < len(p.Points); j, i = i, i + 1 {
+        a, b := p.Points[j], p.Points[i]
+        cross := float64(a.X) * float64(b.Y) - float64(b.X) * float64(a.Y)
+        x += float64(a.X + b.X) * cross
+        y += float64(a.Y + b.Y) * cross
+    }
+
+    return Vector{X: float32(x / (3 * area2)), Y: float32(y / (3 * area2))}
+}
+
+func (p Polygon) Bounds() (Vector, Vector) {
+    if len(p.Points) == 0 {
+        return Vector{}, Vector{}
+    }
+
+    min, max := p.Points[0], p.Points[0]
+
+    for _, point := range p.Points[1:] {
+        min.X = float32(math.Min(float64(min.X), float64(point.X)))
+        min.Y = float32(math.Min(float64(min.Y), float64(point.Y)))
+        max.X = float32(math.Max(float64(max.X), float64(point.X)))
+        max.Y = float32(math.Max(float64(max.Y), float64(point.Y)))
+    }
+
+    return min, max
+}
+
+func (p Polygon) Area() float32 {
+    return float32(math.Abs(p.signedArea2()) / 2)
+}
+
+func (p Polygon) Outline() []Vector {
+    return append([]Vector{}, p.Points...)
+}
+
+// Each point moves towards the matching point of the target
+func (p Polygon) Towards(target Shape, progress float64) Shape {
+    to, ok := target.(Polygon)
+
+    if !ok || len(to.Points) != len(p.Points) {
+        return p
+    }
+
+    points := make([]Vector, len(p.Points))
+    for i := range points {
+        points[i] = lerpVector(p.Points[i], to.Points[i], progress)
+    }
+
+    return Polygon{Points: points}
+}
+
+// Holds any Shape so it can go in and out of JSON, with a "Type" saying
+// which kind it is. Objects without a Type are circles, as all fog used to be.
+type AnyShape struct {
+    Shape
+}
+
+// The shape, or an empty circle if there isn't one
+func (s AnyShape) Value() Shape {
+    if s.Shape == nil {
+        return Circle{}
+    }
+    return s.Shape
+}
+
+func (s AnyShape) Validate() error {
+    if s.Shape == nil {
+        return invalidf("Shape is missing")
+    }
+    return s.Shape.Validate()
+}
+
+func (s AnyShape) MarshalJSON() ([]byte, error) {
+    if s.Shape == nil {
+        return []byte("null"), nil
+    }
+
+    fields, err := json.Marshal(s.Shape)
+
+    if err != nil {
+        return nil, err
+    }
+
+    kind, err := json.Marshal(s.Shape.Kind())
+
+    if err != nil {
+        return nil, err
+    }
+
+    // Every shape is an object with at least one field, put the type first
+    encoded := append([]byte(`{"Type":`), kind...)
+    encoded = append(encoded, ',')
+    return append(encoded, fields[1:]...), nil
+}
+
+// Decode like a request body would be, rejecting unknown fields
+func decodeStrict(data []byte, v interface{}) error {
+    decoder := json.NewDecoder(bytes.NewReader(data))
+    decoder.DisallowUnknownFields()
+    return decoder.Decode(v)
+}
+
+func (s *AnyShape) UnmarshalJSON(data []byte) error {
+    if string(data) == "null" {
+        s.Shape = nil
+        return nil
+    }
+
+    var header struct {
+        Type string
+    }
+
+    err := json.Unmarshal(data, &header)
+
+    if err != nil {
+        return err
+    }
+
+    // Type is the only field allowed on top of the shape's own
+    switch header.Type {
+    case "", ShapeCircle:
+        var circle struct {
+            Type string
+            Circle
+        }
+        err = decodeStrict(data, &circle)
+        s.Shape = circle.Circle
+    case ShapeRectangle:
+        var rectangle struct {
+            Type string
+            Rectangle
+        }
+        err = decodeStrict(data, &rectangle)
+        s.Shape = rectangle.Rectangle
+    case ShapeEllipse:
+        var ellipse struct {
+            Type string
+            Ellipse
+        }
+        err = decodeStrict(data, &ellipse)
+        s.Shape = ellipse.Ellipse
+    case ShapePolygon:
+        var polygon struct {
+            Type string
+            Polygon
+        }
+        err = decodeStrict(data, &polygon)
+        s.Shape = polygon.Polygon
+    default:
+        return invalidf("Unknown shape type %q", header.Type)
+    }
+
+    return err
+}
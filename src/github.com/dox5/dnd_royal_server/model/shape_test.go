@@ -0,0 +1,204 @@
+package model_test
+
+import (
+    "encoding/json"
+    "reflect"
+    "testing"
+
+    "github.com/dox5/dnd_royal_server/model"
+)
+
+func TestShapesShouldRoundTripThroughJSON(t *testing.T) {
+    shapes := []model.Shape{
+        model.Circle{Centre: model.Vector{1, 2}, Radius: 3},
+        model.Rectangle{Centre: model.Vector{4, 5}, Width: 6, Height: 7},
+        model.Ellipse{Centre: model.Vector{8, 9}, RadiusX: 10, RadiusY: 11},
+        model.Polygon{Points: []model.Vector{{0, 0}, {10, 0}, {0, 10}}}}
+
+    for _, shape := range shapes {
+        encoded, err := json.Marshal(model.AnyShape{Shape: shape})
+
+        if err != nil {
+            t.Fatalf("Failed to encode %+v: %s", shape, err)
+        }
+
+        var decoded model.AnyShape
+        err = json.Unmarshal(encoded, &decoded)
+
+        if err != nil || !reflect.DeepEqual(decoded.Shape, shape) {
+            t.Errorf("Expected %s to decode to %+v but got %+v (%v)",
+                     encoded,
+                     shape,
+                     decoded.Shape,
+                     err)
+        }
+    }
+
+    // Everything used to be a circle
+    var untyped model.AnyShape
+    err := json.Unmarshal([]byte(`{"Centre": {"X": 1, "Y": 2}, "Radius": 3}`),
+                          &untyped)
+
+    if err != nil || untyped.Shape != shapes[0] {
+        t.Errorf("Expected an untyped shape to be a circle but got %+v (%v)",
+                 untyped.Shape,
+                 err)
+    }
+
+    err = json.Unmarshal([]byte(`{"Type": "star"}`), &untyped)
+
+    if err == nil {
+        t.Error("Expected an unknown shape type to be rejected")
+    }
+
+    misspelled := []string{
+        `{"Centre": {"X": 1, "Y": 2}, "Radus": 5}`,
+        `{"Type": "rectangle", "Width": 5, "Hieght": 5}`,
+        `{"Type": "polygon", "Points": [], "Radius": 5}`,
+    }
+
+    for _, shape := range misspelled {
+        if json.Unmarshal([]byte(shape), &untyped) == nil {
+            t.Errorf("Expected %s to be rejected for its unknown field", shape)
+        }
+    }
+}
+
+func TestPolygonsShouldBeValidated(t *testing.T) {
+    cases := []struct {
+        name string
+        polygon model.Polygon
+        valid bool
+    }{
+        {"triangle",
+         model.Polygon{Points: []model.Vector{{0, 0}, {10, 0}, {0, 10}}},
+         true},
+        {"concave L",
+         model.Polygon{Points: []model.Vector{{0, 0}, {20, 0}, {20, 10},
+                                              {10, 10}, {10, 20}, {0, 20}}},
+         true},
+        {"two points",
+         model.Polygon{Points: []model.Vector{{0, 0}, {10, 0}}},
+         false},
+        // Top and bottom edges swapped over, crossing in the middle
+        {"bow tie",
+         model.Polygon{Points: []model.Vector{{0, 0}, {10, 10},
+                                              {10, 0}, {0, 10}}},
+         false},
+    }
+
+    for _, c := range cases {
+        err := c.polygon.Validate()
+
+        if (err == nil) != c.valid {
+            t.Errorf("Expected %s to be valid: %v, got %v", c.name, c.valid, err)
+        }
+    }
+}
+
+func TestShapesShouldContainPoints(t *testing.T) {
+    // An L, with the corner at the origin
+    l := model.Polygon{Points: []model.Vector{{0, 0}, {20, 0}, {20, 10},
+                                              {10, 10}, {10, 20}, {0, 20}}}
+
+    cases := []struct {
+        shape model.Shape
+        point model.Vector
+        inside bool
+    }{
+        {model.Rectangle{Width: 20, Height: 10}, model.Vector{9, -4}, true},
+        {model.Rectangle{Width: 20, Height: 10}, model.Vector{9, 6}, false},
+        {model.Ellipse{RadiusX: 20, RadiusY: 10}, model.Vector{19, 0}, true},
+        {model.Ellipse{RadiusX: 20, RadiusY: 10}, model.Vector{0, 11}, false},
+        {model.Ellipse{RadiusX: 20, RadiusY: 10}, model.Vector{15, 8}, false},
+        {l, model.Vector{5, 15}, true},
+        {l, model.Vector{15, 5}, true},
+        {l, model.Vector{15, 15}, false},
+        {l, model.Vector{-1, 5}, false},
+    }
+
+    for _, c := range cases {
+        if c.shape.ContainsPoint(c.point) != c.inside {
+            t.Errorf("Expected %+v containing %+v to be %v",
+                     c.shape,
+                     c.point,
+                     c.inside)
+        }
+    }
+
+    // Edges and corners are inside, as they are for rectangles
+    square := model.Polygon{Points: []model.Vector{{0, 0}, {10, 0},
+                                                   {10, 10}, {0, 10}}}
+
+    for _, point := range append(square.Points, model.Vector{10, 5}) {
+        if !square.ContainsPoint(point) {
+            t.Errorf("Expected %+v on the edge of the square to be inside",
+                     point)
+        }
+    }
+
+    if !model.Encloses(square, square) {
+        t.Error("Expected a square to enclose itself")
+    }
+
+    if !model.Encloses(l, model.Rectangle{Centre: model.Vector{5, 5},
+                                           Width: 8,
+                                           Height: 8}) {
+        t.Error("Expected a rectangle in the corner to fit inside the L")
+    }
+
+    if model.Encloses(l, model.Rectangle{Centre: model.Vector{10, 10},
+                                          Width: 8,
+                                          Height: 8}) {
+        t.Error("Expected a rectangle over the inside corner not to fit")
+    }
+
+    // Every corner is inside the L but the long edge cuts across the gap
+    across := model.Polygon{Points: []model.Vector{{5, 19}, {19, 5}, {5, 5}}}
+
+    if model.Encloses(l, across) {
+        t.Error("Expected a triangle crossing the inside corner not to fit")
+    }
+}
+
+func TestFogShouldMoveBetweenShapesOfTheSameKind(t *testing.T) {
+    initial := model.Polygon{Points: []model.Vector{{0, 0}, {40, 0}, {0, 40}}}
+    target := model.Polygon{Points: []model.Vector{{0, 0}, {20, 0}, {0, 20}}}
+
+    fog := model.NewFog(initial)
+
+    if err := fog.SetTarget(model.Circle{Radius: 10}); err == nil {
+        t.Error("Expected a polygon fog not to accept a circle target")
+    }
+
+    square := model.Polygon{Points: []model.Vector{{0, 0}, {10, 0},
+                                                   {10, 10}, {0, 10}}}
+
+    if err := fog.SetTarget(square); err == nil {
+        t.Error("Expected a target with a different number of points to fail")
+    }
+
+    if err := fog.SetTarget(target); err != nil {
+        t.Fatalf("Failed to set polygon target: %s", err)
+    }
+
+    fog.SetPeriod(10)
+    fog.Resume()
+    fog.Advance(5)
+
+    halfWay := model.Polygon{Points: []model.Vector{{0, 0}, {30, 0}, {0, 30}}}
+
+    if !fog.Current().Equal(halfWay) {
+        t.Errorf("Expected fog to be %+v half way but it was %+v",
+                 halfWay,
+                 fog.Current())
+    }
+
+    fog.Advance(5)
+
+    if !reflect.DeepEqual(fog.Current(), target) || !fog.Paused() {
+        t.Errorf("Expected fog to stop at %+v but it was %+v",
+                 target,
+                 fog.Current())
+    }
+}
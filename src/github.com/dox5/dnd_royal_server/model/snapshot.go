@@ -0,0 +1,178 @@
+package model
+
+import (
+    "sort"
+)
+
+// Plain, exported copies of the model state so that rooms can be written out
+// (and read back in) without exposing the internals of Room and Fog.
+
+type FogSnapshot struct {
+    Rules   FogRules
+    Current AnyShape
+    Target  AnyShape
+    Period  float32
+    Advance bool
+
+    // The move in progress, Current follows from these
+    Start     AnyShape
+    StartTime float64
+    Easing    string
+    Aimed     bool
+    Time      float64
+
+    Schedule    []FogStage
+    Stage       int
+    StageStarts []AnyShape
+    StageTime   float64
+}
+
+type PlayerSnapshot struct {
+    Id     Identifier `json:",string"`
+    Name   string
+    Colour string
+}
+
+type RoomSnapshot struct {
+    Id           Identifier `json:",string"`
+    GameMasterId Identifier `json:",string"`
+    MapAsset     string
+    MapSize      MapSize
+    Fog          FogSnapshot
+    Tokens       []Token
+    LastZoneSeed int64 `json:",string"`
+    Players      []PlayerSnapshot
+    NextTokenId  Identifier `json:",string"`
+    RemovedTokens []Identifier
+
+    Time float32
+    DamagePerSecond float32
+    StartingHitPoints float32
+    DamageLog []DamageEvent
+}
+
+func (f *Fog) Snapshot() FogSnapshot {
+    return FogSnapshot{Rules: f.rules,
+                       Current: AnyShape{f.Current()},
+                       Target: AnyShape{f.target},
+                       Period: f.period,
+                       Advance: f.advance,
+                       Start: AnyShape{f.start},
+                       StartTime: f.startTime,
+                       Easing: f.easing,
+                       Aimed: f.aimed,
+                       Time: f.time,
+                       Schedule: f.Schedule(),
+                       Stage: f.stage,
+                       StageStarts: anyShapes(f.stageStarts),
+                       StageTime: f.stageTime}
+}
+
+func anyShapes(shapes []Shape) []AnyShape {
+    wrapped := make([]AnyShape, len(shapes))
+    for i, shape := range shapes {
+        wrapped[i] = AnyShape{shape}
+    }
+    return wrapped
+}
+
+func RestoreFog(snapshot FogSnapshot) *Fog {
+    fog := NewFog(snapshot.Start.Value())
+    fog.rules = snapshot.Rules
+    fog.target = snapshot.Target.Value()
+    fog.period = snapshot.Period
+    fog.advance = snapshot.Advance
+    fog.startTime = snapshot.StartTime
+    fog.easing = snapshot.Easing
+    fog.aimed = snapshot.Aimed
+    fog.time = snapshot.Time
+
+    if len(snapshot.Schedule) > 0 {
+        fog.schedule = append([]FogStage{}, snapshot.Schedule...)
+        fog.stage = snapshot.Stage
+        fog.stageStarts = make([]Shape, len(snapshot.StageStarts))
+        for i, start := range snapshot.StageStarts {
+            fog.stageStarts[i] = start.Value()
+        }
+        fog.stageTime = snapshot.StageTime
+    }
+
+    // A room's fog starts out zeroed, there is no rate to work out until a
+    // period has been set
+    if fog.aimed && fog.period != 0 {
+        fog.recalculateRate()
+    }
+
+    if fog.Scheduled() &&
+       fog.stage < len(fog.schedule) &&
+       fog.schedule[fog.stage].Shrink == 0 {
+        fog.advanceRate = Rate{}
+    }
+
+    return fog
+}
+
+func (r *Room) Snapshot() RoomSnapshot {
+    tokens := make([]Token, len(r.playerTokens))
+    copy(tokens, r.playerTokens)
+
+    players := make([]PlayerSnapshot, 0, len(r.players))
+    for _, p := range r.players {
+        players = append(players, PlayerSnapshot{Id: p.id,
+                                                 Name: p.name,
+                                                 Colour: p.colour})
+    }
+
+    removed := make([]Identifier, 0, len(r.removedTokens))
+    for id := range r.removedTokens {
+        removed = append(removed, id)
+    }
+    sort.Slice(removed, func(i, j int) bool { return removed[i] < removed[j] })
+
+    return RoomSnapshot{Id: r.id,
+                        GameMasterId: r.gameMaster.Id(),
+                        MapAsset: r.mapAsset,
+                        MapSize: r.mapSize,
+                        Fog: r.fog.Snapshot(),
+                        Tokens: tokens,
+                        LastZoneSeed: r.lastZoneSeed,
+                        Players: players,
+                        NextTokenId: r.nextTokenId,
+                        RemovedTokens: removed,
+                        Time: r.time,
+                        DamagePerSecond: r.damagePerSecond,
+                        StartingHitPoints: r.startingHitPoints,
+                        DamageLog: append([]DamageEvent{}, r.damageLog...)}
+}
+
+// Rebuild a room from a snapshot, keeping the room and game master ids so
+// that existing links to the room still work.
+func RestoreRoom(snapshot RoomSnapshot) *Room {
+    tokens := make([]Token, len(snapshot.Tokens))
+    copy(tokens, snapshot.Tokens)
+
+    players := make(map[Identifier]*player, len(snapshot.Players))
+    for _, p := range snapshot.Players {
+        players[p.Id] = &player{id: p.Id, name: p.Name, colour: p.Colour}
+    }
+
+    removed := make(map[Identifier]bool, len(snapshot.RemovedTokens))
+    for _, id := range snapshot.RemovedTokens {
+        removed[id] = true
+    }
+
+    return &Room{id: snapshot.Id,
+                 fog: *RestoreFog(snapshot.Fog),
+                 gameMaster: RestorePlayer(snapshot.GameMasterId),
+                 mapAsset: snapshot.MapAsset,
+                 mapSize: snapshot.MapSize,
+                 playerTokens: tokens,
+                 lastZoneSeed: snapshot.LastZoneSeed,
+                 players: players,
+                 nextTokenId: snapshot.NextTokenId,
+                 removedTokens: removed,
+                 time: snapshot.Time,
+                 damagePerSecond: snapshot.DamagePerSecond,
+                 startingHitPoints: snapshot.StartingHitPoints,
+                 damageLog: append([]DamageEvent{}, snapshot.DamageLog...)}
+}
@@ -1,6 +1,23 @@
 package model
 
+const (
+    MaxTokenNameLength = 32
+    DefaultHitPoints float32 = 100
+)
+
+// Ids go out as strings, like every other id, so JavaScript clients don't
+// lose precision on them
 type Token struct {
-    Id Identifier
+    Id Identifier `json:",string"`
     Position Vector
+    // The player the token belongs to, 0 if nobody has claimed it
+    Owner Identifier `json:",string"`
+    Name string
+
+    HitPoints float32
+    MaxHitPoints float32
+    // Outside the fog circle as of the last update
+    OutsideZone bool
+    // Ran out of hit points, eliminated tokens take no further part
+    Eliminated bool
 }
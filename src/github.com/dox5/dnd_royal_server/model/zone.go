@@ -0,0 +1,165 @@
+package model
+
+import (
+    "math"
+    "math/rand"
+)
+
+// Tries at finding a random point inside a shape before settling for its
+// centre
+const maxPointAttempts = 1000
+
+// Picks new safe zones at random. The same seed, current zone and parameters
+// always give the same next zone.
+type ZoneGenerator struct {
+    seed int64
+    rng  *rand.Rand
+}
+
+func NewZoneGenerator(seed int64) *ZoneGenerator {
+    return &ZoneGenerator{seed: seed,
+                          rng: rand.New(rand.NewSource(seed))}
+}
+
+func (g *ZoneGenerator) Seed() int64 {
+    return g.seed
+}
+
+// A circle of the given radius placed somewhere fully inside outer.
+//
+// With an edgeBias of 0 every valid position is equally likely, larger values
+// push the zone further from the edge of outer.
+func (g *ZoneGenerator) NextZone(outer Circle,
+                                 radius float32,
+                                 edgeBias float32) (Circle, error) {
+    return g.NextZoneOnMap(outer, radius, edgeBias, MapSize{})
+}
+
+// Like NextZone but once the map size is known the zone has to be on the map
+// too, and edgeBias pushes it away from the edges of the map instead
+func (g *ZoneGenerator) NextZoneOnMap(outer Circle,
+                                      radius float32,
+                                      edgeBias float32,
+                                      onMap MapSize) (Circle, error) {
+    if radius <= 0 {
+        return Circle{}, invalidf("Zone radius must be positive, got %v", radius)
+    }
+
+    if radius > outer.Radius {
+        return Circle{}, invalidf("Zone radius %v does not fit inside radius %v",
+                                  radius,
+                                  outer.Radius)
+    }
+
+    if edgeBias < 0 {
+        return Circle{}, invalidf("Edge bias must not be negative, got %v",
+                                  edgeBias)
+    }
+
+    // The new centre can be anywhere in this circle and still have the zone
+    // fully inside outer
+    freedom := Circle{Centre: outer.Centre, Radius: outer.Radius - radius}
+
+    if !onMap.Known() {
+        centre := g.pointInside(freedom, edgeBias)
+        return Circle{Centre: centre, Radius: radius}, nil
+    }
+
+    centre, err := g.centreOnMap(freedom, radius, edgeBias, onMap)
+    return Circle{Centre: centre, Radius: radius}, err
+}
+
+// A centre in freedom that keeps a zone of the given radius on the map. Each
+// point tried is kept with a chance that drops towards the edges of the map,
+// so with an edgeBias of 0 every point is kept and all are equally likely.
+func (g *ZoneGenerator) centreOnMap(freedom Circle,
+                                    radius float32,
+                                    edgeBias float32,
+                                    onMap MapSize) (Vector, error) {
+    width := float32(onMap.Width) - 2 * radius
+    height := float32(onMap.Height) - 2 * radius
+
+    if width < 0 || height < 0 {
+        return Vector{}, invalidf("Zone radius %v does not fit on the %dx%d map",
+                                  radius,
+                                  onMap.Width,
+                                  onMap.Height)
+    }
+
+    // How far a centre is from the nearest place the zone would touch an
+    // edge of the map, negative if it would go off the map
+    depth := func(centre Vector) float64 {
+        return math.Min(math.Min(float64(centre.X - radius),
+                                 float64(width + radius - centre.X)),
+                        math.Min(float64(centre.Y - radius),
+                                 float64(height + radius - centre.Y)))
+    }
+    deepest := math.Min(float64(width), float64(height)) / 2
+
+    var best Vector
+    bestDepth := -1.0
+
+    for i := 0; i < maxPointAttempts; i += 1 {
+        centre := g.pointInside(freedom, 0)
+        d := depth(centre)
+
+        if d < 0 {
+            continue
+        }
+
+        if d > bestDepth {
+            best, bestDepth = centre, d
+        }
+
+        if deepest == 0 ||
+           g.rng.Float64() <= math.Pow(d / deepest, float64(edgeBias)) {
+            return centre, nil
+        }
+    }
+
+    if bestDepth < 0 {
+        return Vector{}, invalidf("Couldn't find room for a zone of radius %v " +
+                                  "on the map inside the fog",
+                                  radius)
+    }
+
+    return best, nil
+}
+
+// A point anywhere inside area, all equally likely
+func (g *ZoneGenerator) RandomPoint(area Circle) Vector {
+    return g.pointInside(area, 0)
+}
+
+// A point anywhere inside any shape, all equally likely
+func (g *ZoneGenerator) RandomPointIn(area Shape) Vector {
+    if circle, ok := area.(Circle); ok {
+        return g.RandomPoint(circle)
+    }
+
+    // Keep picking from the box around the shape until one lands inside
+    min, max := area.Bounds()
+    for i := 0; i < maxPointAttempts; i += 1 {
+        point := Vector{X: lerp(min.X, max.X, g.rng.Float64()),
+                        Y: lerp(min.Y, max.Y, g.rng.Float64())}
+
+        if area.ContainsPoint(point) {
+            return point
+        }
+    }
+
+    return area.Centroid()
+}
+
+func (g *ZoneGenerator) pointInside(area Circle, edgeBias float32) Vector {
+    // sqrt makes the point uniform over the area rather than bunched up in
+    // the middle, the bias then pulls it back in
+    distance := float64(area.Radius) *
+                math.Pow(g.rng.Float64(), 0.5 * float64(1 + edgeBias))
+    angle := 2 * math.Pi * g.rng.Float64()
+
+    offset := Vector{X: float32(distance * math.Cos(angle)),
+                     Y: float32(distance * math.Sin(angle))}
+
+    return area.Centre.Add(offset)
+}
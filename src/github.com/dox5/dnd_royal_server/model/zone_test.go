@@ -0,0 +1,183 @@
+package model_test
+
+import (
+    "math"
+    "testing"
+
+    "github.com/dox5/dnd_royal_server/model"
+)
+
+func TestSameSeedShouldGiveSameZone(t *testing.T) {
+    outer := model.Circle{Centre: model.Vector{100, 50}, Radius: 80}
+
+    first, err := model.NewZoneGenerator(42).NextZone(outer, 20, 0)
+
+    if err != nil {
+        t.Fatalf("Failed to generate zone: %s", err)
+    }
+
+    second, err := model.NewZoneGenerator(42).NextZone(outer, 20, 0)
+
+    if err != nil {
+        t.Fatalf("Failed to generate zone: %s", err)
+    }
+
+    if first != second {
+        t.Errorf("Expected the same seed to give the same zone but got %+v and %+v",
+                 first,
+                 second)
+    }
+}
+
+func TestGeneratedZonesShouldBeInsideCurrentCircle(t *testing.T) {
+    outer := model.Circle{Centre: model.Vector{-30, 70}, Radius: 60}
+    generator := model.NewZoneGenerator(7)
+
+    for i := 0; i < 1000; i += 1 {
+        zone, err := generator.NextZone(outer, 25, 0)
+
+        if err != nil {
+            t.Fatalf("Failed to generate zone: %s", err)
+        }
+
+        if zone.Radius != 25 {
+            t.Fatalf("Expected zone radius to be 25 but it was %v", zone.Radius)
+        }
+
+        if !outer.Contains(zone) {
+            t.Fatalf("Zone %+v is not inside %+v", zone, outer)
+        }
+    }
+}
+
+func TestZoneTheSameSizeAsCurrentShouldNotMove(t *testing.T) {
+    outer := model.Circle{Centre: model.Vector{10, 10}, Radius: 60}
+
+    zone, err := model.NewZoneGenerator(3).NextZone(outer, 60, 0)
+
+    if err != nil {
+        t.Fatalf("Failed to generate zone: %s", err)
+    }
+
+    if zone != outer {
+        t.Errorf("Expected zone to be %+v but it was %+v", outer, zone)
+    }
+}
+
+func TestEdgeBiasShouldPullZonesTowardsCentre(t *testing.T) {
+    outer := model.Circle{Centre: model.Vector{0, 0}, Radius: 100}
+
+    averageDistance := func(edgeBias float32) float32 {
+        generator := model.NewZoneGenerator(11)
+        total := float32(0)
+
+        for i := 0; i < 1000; i += 1 {
+            zone, err := generator.NextZone(outer, 10, edgeBias)
+
+            if err != nil {
+                t.Fatalf("Failed to generate zone: %s", err)
+            }
+
+            total += zone.Centre.Magnatude()
+        }
+
+        return total / 1000
+    }
+
+    unbiased := averageDistance(0)
+    biased := averageDistance(4)
+
+    if biased >= unbiased {
+        t.Errorf("Expected biased zones (average distance %v) to be closer " +
+                 "to the centre than unbiased zones (average distance %v)",
+                 biased,
+                 unbiased)
+    }
+}
+
+func TestEdgeBiasShouldPushZonesAwayFromMapEdges(t *testing.T) {
+    // The fog reaches right to the left and bottom edges of the map
+    outer := model.Circle{Centre: model.Vector{100, 100}, Radius: 100}
+    onMap := model.MapSize{Width: 400, Height: 400}
+
+    averageDepth := func(edgeBias float32) float32 {
+        generator := model.NewZoneGenerator(11)
+        total := float32(0)
+
+        for i := 0; i < 1000; i += 1 {
+            zone, err := generator.NextZoneOnMap(outer, 10, edgeBias, onMap)
+
+            if err != nil {
+                t.Fatalf("Failed to generate zone: %s", err)
+            }
+
+            min, _ := zone.Bounds()
+            if min.X < 0 || min.Y < 0 {
+                t.Fatalf("Expected zone %+v to be on the map", zone)
+            }
+
+            total += float32(math.Min(float64(min.X), float64(min.Y)))
+        }
+
+        return total / 1000
+    }
+
+    unbiased := averageDepth(0)
+    biased := averageDepth(4)
+
+    if biased <= unbiased {
+        t.Errorf("Expected biased zones (average %v from the edge) to be " +
+                 "further from the map edges than unbiased zones (%v)",
+                 biased,
+                 unbiased)
+    }
+}
+
+func TestInvalidZoneRequestsShouldFail(t *testing.T) {
+    outer := model.Circle{Centre: model.Vector{0, 0}, Radius: 50}
+    generator := model.NewZoneGenerator(1)
+
+    cases := []struct {
+        name string
+        radius float32
+        edgeBias float32
+    }{
+        {"zero radius", 0, 0},
+        {"negative radius", -5, 0},
+        {"bigger than current", 51, 0},
+        {"negative bias", 10, -1},
+    }
+
+    for _, c := range cases {
+        if _, err := generator.NextZone(outer, c.radius, c.edgeBias); err == nil {
+            t.Errorf("Expected %s to be rejected", c.name)
+        }
+    }
+}
+
+func TestRoomRandomTargetShouldSetFogTarget(t *testing.T) {
+    room := model.NewRoom(model.NewPlayer())
+
+    // Grow the fog out from nothing so there is room for a zone
+    room.Fog().SetPeriod(1)
+    room.Fog().SetTarget(model.Circle{Radius: 100})
+    room.Fog().Resume()
+    room.Fog().Advance(1)
+
+    target, err := room.RandomTarget(30, 0, 1234)
+
+    if err != nil {
+        t.Fatalf("Failed to pick random target: %s", err)
+    }
+
+    if room.Fog().Target() != target {
+        t.Errorf("Expected fog target to be %+v but it was %+v",
+                 target,
+                 room.Fog().Target())
+    }
+
+    if room.LastZoneSeed() != 1234 {
+        t.Errorf("Expected the seed to be remembered but it was %v",
+                 room.LastZoneSeed())
+    }
+}
@@ -1,3 +1,20 @@
+// Signed token from creating or joining a room, sent with every request
+var sessionToken = undefined
+
+function setSessionToken(token) {
+    sessionToken = token
+}
+
+// Keep game master sessions so the room can be picked back up later
+function rememberSession(roomId, session) {
+    window.localStorage.setItem("session-" + roomId, JSON.stringify(session))
+}
+
+function rememberedSession(roomId) {
+    var session = window.localStorage.getItem("session-" + roomId)
+    return session === null ? undefined : JSON.parse(session)
+}
+
 function do_http_request(uri, method, data) {
     return new Promise((resolve, reject) => {
         var request = new XMLHttpRequest();
@@ -7,14 +24,23 @@ function do_http_request(uri, method, data) {
                 if(request.status == 200) {
                     resolve(request.response)
                 } else {
+                    var error = (request.response && request.response.Error) || {}
                     reject({status: request.status,
-                            statusText: request.statusText})
+                            statusText: request.statusText,
+                            code: error.Code,
+                            message: error.Message})
                 }
             }
         }
         
         request.responseType = "json"
         request.open(method, uri, true)
+        if (data !== undefined) {
+            request.setRequestHeader("Content-Type", "application/json")
+        }
+        if (sessionToken !== undefined) {
+            request.setRequestHeader("Authorization", "Bearer " + sessionToken)
+        }
         request.send(data)
     })
 }
@@ -10,15 +10,26 @@ function getRoom() {
     }
 }
 
-function getUser() {
-    re = /[0-9]+/
-    match = getUserInput("UserId: ", re)
+function getGameMasterSession(roomId) {
+    var session = rememberedSession(roomId)
 
-    if(match === undefined) {
+    if (session !== undefined) {
+        return session
+    }
+
+    var userId = getUserInput("UserId: ", /[0-9]+/)
+    var token = getUserInput("Session token: ")
+
+    if (userId === undefined || token === undefined) {
         return undefined
-    } else {
-        return match[0]
     }
+
+    return {userId: userId[0], token: token}
+}
+
+function randomColour() {
+    var colour = Math.floor(Math.random() * 0xffffff).toString(16)
+    return "#" + colour.padStart(6, "0")
 }
 
 var HostJoinScene = new Phaser.Class({
@@ -39,20 +50,39 @@ var HostJoinScene = new Phaser.Class({
         makeButton(this, {idle: 8, click: 9}, {x: 100, y: 100})
             .on("click", function() {
                 var roomId = getRoom()
-                do_http_get("api/v1/fog/location?RoomId="+roomId)
-                    .then(function() {
-                              this.scene.start("PlayingScene", {"roomId": roomId})
+                var name = getUserInput("Name: ")
+
+                if (roomId === undefined || name === undefined) {
+                    return
+                }
+
+                var request = {
+                    RoomId: roomId,
+                    Name: name,
+                    Colour: randomColour()
+                }
+
+                do_http_post("api/v1/room/join", JSON.stringify(request))
+                    .then(function(joined) {
+                              setSessionToken(joined.Token)
+                              this.scene.start("PlayingScene",
+                                               {"roomId": roomId,
+                                                "playerId": joined.PlayerId})
                           }.bind(this),
                           function() {
-                              console.log("No room with key ", roomId, " was found")
+                              console.log("Failed to join room ", roomId)
                           })
             }, this)
 
         // Create Room
         makeButton(this, {idle: 10, click: 11}, {x: 100, y: 164})
             .on("click", function() {
-                do_http_post("api/v1/room/create", {})
+                do_http_post("api/v1/room/create")
                     .then(function(createdRoom) {
+                              setSessionToken(createdRoom.Token)
+                              rememberSession(createdRoom.RoomId,
+                                              {userId: createdRoom.GameMasterId,
+                                               token: createdRoom.Token})
                               this.scene.start("PlayingScene",
                                                {roomId: createdRoom.RoomId,
                                                 userId: createdRoom.GameMasterId})
@@ -67,8 +97,28 @@ var HostJoinScene = new Phaser.Class({
         makeButton(this, {idle: 12, click: 13}, {x: 100, y: 228})
             .on("click", function() {
                 var roomId = getRoom()
-                var userId = getUser()
-                do_http_get("api/v1/fog/location?RoomId="+roomId)
+
+                if (roomId === undefined) {
+                    return
+                }
+
+                var session = getGameMasterSession(roomId)
+
+                if (session === undefined) {
+                    return
+                }
+
+                var userId = session.userId
+                setSessionToken(session.token)
+                // The remembered token may have expired since, swap it for
+                // a fresh one
+                do_http_post("api/v1/room/refreshSession")
+                    .then(function(refreshed) {
+                              session.token = refreshed.Token
+                              rememberSession(roomId, session)
+                              setSessionToken(session.token)
+                              return do_http_get("api/v1/fog/location?RoomId="+roomId)
+                          })
                     .then(function() {
                               this.scene.start("PlayingScene",
                                                {"roomId": roomId,
@@ -43,40 +43,48 @@ function listenForDoubleClick(gameObject) {
 var PlayerTokenController = new Phaser.Class({
     radius: 25,
     textures: [],
-    updateRateHz: 2,
-    timeTillUpdate: 0,
     tokens: {},
 
     Extends: Phaser.EventEmitter,
     
-    initialize: function(scene, roomId, textures) {
-        this.roomId = roomId
-        this.updatePeriodMS = (1 / this.updateRateHz) * 1000,
-        this.timeTillUpdate = 0
+    initialize: function(scene, roomEvents, textures) {
         this.textures = textures
         this.scene = scene
 
         Phaser.EventEmitter.call(this)
+
+        roomEvents.addEventListener("token", (event) => {
+            this.onTokenState(JSON.parse(event.data))
+        })
+
+        roomEvents.addEventListener("tokenRemoved", (event) => {
+            this.onTokenRemoved(JSON.parse(event.data))
+        })
     },
 
     createGameTokenFor: function(token) {
         sprite = this.scene.add.sprite(token.Position.X,
                                        token.Position.Y,
-                                       this.textures.pop())
+                                       this.textures[token.Id % this.textures.length])
 
         gameToken = {
             sprite: sprite,
             updatePosition: true,
-            id: token.Id
+            id: token.Id,
+            owner: token.Owner
         }
 
         this.tokens[token.Id]  = gameToken
+        // Eliminated players drop off the board
+        sprite.setVisible(!token.Eliminated)
         
         this.emit("create", gameToken)
     },
 
     updateGameToken: function(token) {
         gameToken = this.tokens[token.Id]
+        gameToken.sprite.setVisible(!token.Eliminated)
+
         if (!gameToken.updatePosition) {
             return
         }
@@ -85,31 +93,22 @@ var PlayerTokenController = new Phaser.Class({
         gameToken.sprite.y = token.Position.Y
     },
 
-    pollState: function() {
-        do_http_get("api/v1/token/getTokens?RoomId=" + this.roomId)
-            .then((tokenData) => {
-                tokenData.forEach((token) => {
-                    gameToken = this.tokens[token.Id]
-
-                    if (gameToken === undefined) {
-                        this.createGameTokenFor(token)
-                    } else {
-                        this.updateGameToken(token)
-                    }
-                })
-            }, console.log)
-    },
+    onTokenRemoved: function(removed) {
+        gameToken = this.tokens[removed.Id]
 
-    update: function(time, timeDelta) {
-        if (isNaN(timeDelta)) {
-            timeDelta = 0
+        if (gameToken !== undefined) {
+            gameToken.sprite.destroy()
+            delete this.tokens[removed.Id]
         }
+    },
 
-        this.timeTillUpdate -= timeDelta
+    onTokenState: function(token) {
+        gameToken = this.tokens[token.Id]
 
-        if (this.timeTillUpdate <= 0) {
-            this.timeTillUpdate = this.updatePeriodMS
-            this.pollState()
+        if (gameToken === undefined) {
+            this.createGameTokenFor(token)
+        } else {
+            this.updateGameToken(token)
         }
     }
 })
@@ -133,6 +132,8 @@ var PlayingScene = new Phaser.Class({
         this.load.image('token2', 'assets/token2.png')
         this.load.image('token3', 'assets/token3.png')
         this.load.image('map',   'assets/map-update-3.png');
+        // Only there if the GM has uploaded one, otherwise use the default
+        this.load.image('room-map', 'api/v1/room/map?RoomId=' + this.roomId);
         this.load.image('grid',  'assets/grid.png');
         this.load.image('fog',   'assets/fog-pattern.png');
         this.load.image('circle-centre', 'assets/circle-centre.png');
@@ -146,10 +147,12 @@ var PlayingScene = new Phaser.Class({
         console.log(data)
         this.roomId = data.roomId
         this.userId = data.userId
+        this.playerId = data.playerId
     },
 
     create: function() {
-        var map = this.add.sprite(0, 0, 'map').setOrigin(0,0)
+        var mapKey = this.textures.exists('room-map') ? 'room-map' : 'map'
+        var map = this.add.sprite(0, 0, mapKey).setOrigin(0,0)
         var grid = this.add.sprite(0, 0, 'grid').setOrigin(0, 0)
         grid.alpha = 0.3
 
@@ -178,12 +181,18 @@ var PlayingScene = new Phaser.Class({
 
         this.cameras.main.setBackgroundColor("#b8e0d9")
 
+        // Fog and token changes are pushed from the server
+        this.roomEvents = new EventSource("api/v1/room/events?RoomId=" + this.roomId)
+        this.events.once("shutdown", function() {
+            this.roomEvents.close()
+        }, this)
+
         this.updaters.push(this.camera_controls([map, grid]))
         this.updaters.push(this.createTargetDisplay())
         this.updaters.push(this.createFog())
 
         this.playerTokenController = new PlayerTokenController(this,
-                                                               this.roomId,
+                                                               this.roomEvents,
                                                                ['token1',
                                                                'token2',
                                                                'token3'])
@@ -193,7 +202,11 @@ var PlayingScene = new Phaser.Class({
             this.createGMControls()
         }
 
-        this.updaters.push(this.playerTokenController.update.bind(this.playerTokenController))
+        if(typeof(this.userId) !== 'undefined' ||
+           typeof(this.playerId) !== 'undefined') {
+            this.createTokenControls()
+        }
+
     },
 
     endError: function(error) {
@@ -204,8 +217,7 @@ var PlayingScene = new Phaser.Class({
     createGMControls: function() {
         var pauseResumeHandler = function(pauseOrResume) {
             var request = {
-                RoomId: this.roomId,
-                GameMasterId: this.userId
+                RoomId: this.roomId
             }
             
             var stringRequest = JSON.stringify(request)
@@ -220,33 +232,6 @@ var PlayingScene = new Phaser.Class({
                 .then(undefined, console.log)
         }
 
-        this.playerTokenController.on("create", function(gameToken) {
-            gameToken.sprite.setInteractive()
-            this.input.setDraggable(gameToken.sprite)
-            gameToken.sprite.on("drag", makeDragHandler(gameToken.sprite), this)
-
-            gameToken.sprite.on("dragstart", function() {
-                gameToken.updatePosition = false
-            }, this)
-
-            gameToken.sprite.on("dragend", function() {
-                gameToken.updatePosition = true
-
-                request = {
-                    TokenId: "" + gameToken.id,
-                    RoomId: this.roomId,
-                    GameMasterId: this.userId,
-                    Position: {X: gameToken.sprite.x,
-                               Y: gameToken.sprite.y}
-                }
-
-                do_http_post("api/v1/token/setTokenPosition",
-                            JSON.stringify(request))
-                    .then(undefined, console.log)
-            }, this)
-        }, this)
-
-    
         pauseResumeHandler = pauseResumeHandler.bind(this)
 
         function getPeriodFromUser(helpText) {
@@ -289,8 +274,7 @@ var PlayingScene = new Phaser.Class({
 
                 var request  = {
                     Period: time,
-                    RoomId: this.roomId,
-                    GameMasterId: this.userId
+                    RoomId: this.roomId
                 }
 
                 do_http_post("api/v1/fog/setPeriod", JSON.stringify(request))
@@ -304,8 +288,7 @@ var PlayingScene = new Phaser.Class({
 
                 var request = {
                     Amount: time,
-                    RoomId: this.roomId,
-                    GameMasterId: this.userId
+                    RoomId: this.roomId
                 }
 
                 do_http_post("api/v1/fog/advanceTime", JSON.stringify(request))
@@ -321,6 +304,40 @@ var PlayingScene = new Phaser.Class({
         gmControlCam.setBackgroundColor("#222244")
     },
 
+    // The GM can drag any token, players only their own
+    createTokenControls: function() {
+        this.playerTokenController.on("create", function(gameToken) {
+            var isGM = typeof(this.userId) !== 'undefined'
+
+            if (!isGM && gameToken.owner !== this.playerId) {
+                return
+            }
+
+            gameToken.sprite.setInteractive()
+            this.input.setDraggable(gameToken.sprite)
+            gameToken.sprite.on("drag", makeDragHandler(gameToken.sprite), this)
+
+            gameToken.sprite.on("dragstart", function() {
+                gameToken.updatePosition = false
+            }, this)
+
+            gameToken.sprite.on("dragend", function() {
+                gameToken.updatePosition = true
+
+                request = {
+                    TokenId: "" + gameToken.id,
+                    RoomId: this.roomId,
+                    Position: {X: gameToken.sprite.x,
+                               Y: gameToken.sprite.y}
+                }
+
+                do_http_post("api/v1/token/setTokenPosition",
+                            JSON.stringify(request))
+                    .then(undefined, console.log)
+            }, this)
+        }, this)
+    },
+
     createTargetDisplay: function() {
         var targetCircle = new Phaser.Geom.Circle(0, 0, 0)
         var gfx = this.make.graphics()
@@ -386,7 +403,6 @@ var PlayingScene = new Phaser.Class({
         circleCentre.on('doubleclick', function() {
             newTarget = {
                 RoomId: this.roomId,
-                GameMasterId: this.userId,
                 FogTarget: {
                     Centre: {
                         X: circleCentre.x,
@@ -474,29 +490,23 @@ var PlayingScene = new Phaser.Class({
 
         fog.alpha =1
 
-        timeTillNextPollMs = 0
+        var onFogState = function(event) {
+            var loc = JSON.parse(event.data)
+
+            rate.radius = loc.Rate.Radius
+            rate.position.x = loc.Rate.Translation.X
+            rate.position.y = loc.Rate.Translation.Y
+
+            maskShape.x = loc.Current.Centre.X
+            maskShape.y = loc.Current.Centre.Y
+            maskShape.radius = loc.Current.Radius
+        }
+
+        this.roomEvents.addEventListener("fog", onFogState)
+        this.roomEvents.addEventListener("paused", onFogState)
+        this.roomEvents.addEventListener("resumed", onFogState)
 
         return function(time, timeDelta) {
-            pollPeriodMs = 2000
-            timeTillNextPollMs -= timeDelta
-
-            if(timeTillNextPollMs < 0) {
-                timeTillNextPollMs = pollPeriodMs
-                do_http_get("api/v1/fog/location?RoomId="+this.roomId, null).then(
-                    function(loc) {
-                        rate.radius = loc.Rate.Radius
-                        rate.position.x = loc.Rate.Translation.X
-                        rate.position.y = loc.Rate.Translation.Y
-
-                        // Fix the position of the circle (It's likely going to be
-                        // out!)
-                        maskShape.x = loc.Current.Centre.X
-                        maskShape.y = loc.Current.Centre.Y
-                        maskShape.radius = loc.Current.Radius
-                    }.bind(this), console.log
-                )
-            }
-            
             // TODO: The resetting of the position upsets this because it is out
             // of sync
             //timeDeltaSeconds = timeDelta/1000